@@ -1,17 +1,29 @@
 package main
 
 import (
+	"context"
+	"os"
+	"os/signal"
+	"syscall"
+
 	"github.com/MKhiriev/stunning-adventure/internal/agent"
 	"github.com/MKhiriev/stunning-adventure/internal/config"
 	"github.com/MKhiriev/stunning-adventure/internal/logger"
 )
 
 func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
 	cfg := config.GetAgentConfigs()
 	log := logger.NewLogger("metrics-agent")
 	log.Debug().Any("cfg-agent", cfg).Msg("")
 	log.Info().Msg("Agent started")
 
-	err := agent.NewMetricsAgent("updates", cfg, log).Run()
-	log.Err(err).Caller().Str("func", "main").Msg("error occurred in agent during running")
+	err := agent.NewMetricsAgent("updates", cfg, log).Run(ctx)
+	if err != nil {
+		log.Err(err).Caller().Str("func", "main").Msg("error occurred in agent during running")
+		return
+	}
+	log.Info().Msg("Agent stopped")
 }
@@ -2,18 +2,39 @@ package main
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
 	"github.com/MKhiriev/stunning-adventure/internal/handlers"
+	"github.com/MKhiriev/stunning-adventure/internal/ingest"
 	"github.com/MKhiriev/stunning-adventure/internal/logger"
 	"github.com/MKhiriev/stunning-adventure/internal/server"
 	"github.com/MKhiriev/stunning-adventure/internal/service"
 	"github.com/MKhiriev/stunning-adventure/internal/store"
 )
 
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	ctx := context.Background()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 	log := logger.NewLogger("metrics-server")
+
+	// `server migrate up|down|status` manages database schema and exits
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(ctx, os.Args[2:], log); err != nil {
+			log.Err(err).Msg("migration failed")
+			os.Exit(1)
+		}
+		return
+	}
+
 	cfg, err := config.GetServerConfigs()
 	if err != nil {
 		log.Err(err).Msg("invalid server configuration was passed")
@@ -22,33 +43,153 @@ func main() {
 	log.Info().Any("cfg-srv", cfg).Msg("Server started")
 
 	memStorage := store.NewMemStorage(log)
-	conn, err := store.NewConnectPostgres(ctx, cfg, log)
-	if err != nil {
-		log.Err(err).Msg("connection to database failed")
+	// DATABASE_DSN with `sqlite://` scheme selects SQLite instead of PostgreSQL
+	var conn *store.DB
+	var sqliteConn *store.SQLiteDB
+	var dbPinger service.DBPinger
+	if store.IsSQLiteDSN(cfg.DatabaseDSN) {
+		sqliteConn, err = store.NewConnectSQLite(ctx, cfg, log)
+		if err != nil {
+			log.Err(err).Msg("connection to sqlite database failed")
+		} else {
+			dbPinger = sqliteConn
+		}
+	} else {
+		conn, err = store.NewConnectPostgres(ctx, cfg, log)
+		if err != nil {
+			log.Err(err).Msg("connection to database failed")
+		} else {
+			dbPinger = conn
+		}
 	}
 	fileStorage, err := store.NewFileStorage(ctx, memStorage, cfg, log)
 	if err != nil {
 		log.Err(err).Msg("file storage creation failed")
 	}
 
+	var boltStorage *store.BoltStorage
+	if cfg.BoltPath != "" {
+		boltStorage, err = store.NewBoltStorage(cfg, log)
+		if err != nil {
+			log.Err(err).Msg("bolt storage creation failed")
+		}
+	}
+
+	var historyStorage store.MetricsHistoryStorage
+	if cfg.StoreHistory {
+		if conn != nil {
+			historyStorage = conn
+		} else {
+			historyStorage = store.NewMemHistoryStorage(log)
+		}
+	}
+
 	metricsValidationService := service.NewValidatingMetricsService(log)
-	metricsService, err := service.NewMetricsServiceBuilder(cfg, log).
+	metricsService, err := service.NewMetricsServiceBuilder(ctx, cfg, log).
 		WithDB(conn).
+		WithSQLite(sqliteConn).
+		WithBolt(boltStorage).
 		WithFile(fileStorage).
 		WithCache(memStorage).
+		WithHistory(historyStorage).
 		WithWrapper(metricsValidationService).
 		Build()
 	if err != nil {
 		log.Err(err).Msg("creation of metrics service failed")
 		return
 	}
-	pingService, err := service.NewPingDBService(conn, log)
+	retentionPolicy, err := service.NewRetentionPolicy(cfg)
+	if err != nil {
+		log.Err(err).Msg("invalid retention configuration was passed")
+		return
+	}
+	// janitor stops on shutdown signal
+	if retentionPolicy.Enabled() {
+		service.NewRetentionJanitor(metricsService, retentionPolicy, cfg, log).Run(ctx)
+	}
+
+	pingService, err := service.NewPingDBService(dbPinger, log)
 	if err != nil {
 		log.Err(err).Msg("creation of ping db service failed")
 		return
 	}
 
-	handler := handlers.NewHandler(metricsService, pingService, cfg, log)
-	myServer := new(server.Server)
-	myServer.ServerRun(handler.Init(), cfg)
+	var historyService service.MetricsHistoryService
+	if historyStorage != nil {
+		historyService = service.NewHistoryMetricsService(historyStorage, log)
+	}
+
+	// ingest listeners stop on shutdown signal
+	listeners := &sync.WaitGroup{}
+	if cfg.StatsDAddress != "" {
+		statsDListener := ingest.NewStatsDListener(metricsService, cfg, log)
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			if err := statsDListener.ListenAndServe(ctx); err != nil {
+				log.Err(err).Msg("StatsD listener failed")
+			}
+		}()
+	}
+
+	if cfg.GraphiteAddress != "" {
+		graphiteListener := ingest.NewGraphiteListener(metricsService, cfg, log)
+		listeners.Add(1)
+		go func() {
+			defer listeners.Done()
+			if err := graphiteListener.ListenAndServe(ctx); err != nil {
+				log.Err(err).Msg("Graphite listener failed")
+			}
+		}()
+	}
+
+	agentRegistry := service.NewAgentRegistry(cfg, log)
+
+	handler := handlers.NewHandler(metricsService, historyService, agentRegistry, pingService, cfg, log)
+	myServer := server.NewServer(handler.Init(), cfg)
+	go func() {
+		if err := myServer.ServerRun(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Err(err).Msg("server failed")
+			stop()
+		}
+	}()
+
+	<-ctx.Done()
+	log.Info().Msg("Server is shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+
+	// stop accepting requests and finish in-flight ones
+	if err := myServer.Shutdown(shutdownCtx); err != nil {
+		log.Err(err).Msg("error during server shutdown")
+	}
+	listeners.Wait()
+
+	// save everything left in cache
+	if err := metricsService.Flush(shutdownCtx); err != nil {
+		log.Err(err).Msg("error during flushing metrics")
+	}
+	if fileStorage != nil {
+		if err := fileStorage.Close(); err != nil {
+			log.Err(err).Msg("error during closing file storage")
+		}
+	}
+	if boltStorage != nil {
+		if err := boltStorage.Close(); err != nil {
+			log.Err(err).Msg("error during closing bolt storage")
+		}
+	}
+	if conn != nil {
+		if err := conn.Close(); err != nil {
+			log.Err(err).Msg("error during closing database connection")
+		}
+	}
+	if sqliteConn != nil {
+		if err := sqliteConn.Close(); err != nil {
+			log.Err(err).Msg("error during closing sqlite database")
+		}
+	}
+
+	log.Info().Msg("Server stopped")
 }
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/schema"
+	sqliteschema "github.com/MKhiriev/stunning-adventure/schema/sqlite"
+	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/rs/zerolog"
+)
+
+const migrateUsage = "usage: server migrate [-d dsn] up|down|status"
+
+// runMigrate выполняет подкоманду `migrate up|down|status`
+func runMigrate(ctx context.Context, args []string, log *zerolog.Logger) error {
+	flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dsn := flags.String("d", os.Getenv("DATABASE_DSN"), "Postgres database connection string or sqlite://path for SQLite")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *dsn == "" {
+		return errors.New("no database connection string: set DATABASE_DSN or -d")
+	}
+	if flags.NArg() != 1 {
+		return errors.New(migrateUsage)
+	}
+
+	var db *sql.DB
+	var migrator *store.Migrator
+	var err error
+	if store.IsSQLiteDSN(*dsn) {
+		if db, err = store.OpenSQLite(*dsn); err != nil {
+			return err
+		}
+		defer db.Close()
+		migrator, err = store.NewSQLiteMigrator(db, sqliteschema.Migrations, log)
+	} else {
+		if db, err = sql.Open("pgx", *dsn); err != nil {
+			return fmt.Errorf("error occured during database connection: %w", err)
+		}
+		defer db.Close()
+		migrator, err = store.NewMigrator(db, schema.Migrations, log)
+	}
+	if err != nil {
+		return err
+	}
+
+	switch flags.Arg(0) {
+	case "up":
+		return migrator.Up(ctx)
+	case "down":
+		return migrator.Down(ctx)
+	case "status":
+		statuses, err := migrator.Status(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = status.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return errors.New(migrateUsage)
+	}
+}
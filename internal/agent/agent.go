@@ -3,11 +3,13 @@ package agent
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
 	"net/url"
+	"os"
 	"runtime"
 	"strconv"
 	"sync"
@@ -33,6 +35,10 @@ type MetricsAgent struct {
 	retryIntervals map[int]time.Duration
 	hasher         *utils.Hasher
 	rateLimit      int64
+	instanceID     string
+	labels         map[string]string
+	spool          *Spool
+	spoolMu        *sync.Mutex
 }
 
 func NewMetricsAgent(route string, cfg *config.AgentConfig, logger *zerolog.Logger) *MetricsAgent {
@@ -53,8 +59,34 @@ func NewMetricsAgent(route string, cfg *config.AgentConfig, logger *zerolog.Logg
 		},
 		hasher:    utils.NewHasher(cfg.HashKey),
 		rateLimit: cfg.RateLimit,
+		spoolMu:   &sync.Mutex{},
 	}
 
+	// unsent batches are kept on disk if spool directory is set
+	if cfg.SpoolDir != "" {
+		spool, err := NewSpool(cfg.SpoolDir, cfg.SpoolMaxSize, logger)
+		if err != nil {
+			logger.Err(err).Caller().Str("func", "NewMetricsAgent").Msg("spool is disabled")
+		} else {
+			agent.spool = spool
+		}
+	}
+
+	// identify agent: instance ID defaults to hostname
+	hostname, err := os.Hostname()
+	if err != nil {
+		logger.Err(err).Caller().Str("func", "NewMetricsAgent").Msg("error occurred during getting hostname")
+	}
+	agent.instanceID = cfg.InstanceID
+	if agent.instanceID == "" {
+		agent.instanceID = hostname
+	}
+	staticLabels, err := parseLabels(cfg.Labels)
+	if err != nil {
+		logger.Err(err).Caller().Str("func", "NewMetricsAgent").Msg("static labels are ignored")
+	}
+	agent.labels = agentLabels(staticLabels, hostname, agent.instanceID)
+
 	// add retry mechanism
 	agent.client.SetRetryCount(3).
 		SetRetryAfter(func(client *resty.Client, response *resty.Response) (time.Duration, error) {
@@ -68,7 +100,7 @@ func (m *MetricsAgent) ReadMetrics() error {
 	memStats := runtime.MemStats{}
 	runtime.ReadMemStats(&memStats)
 
-	allMetrics := m.getSliceOfMetrics(memStats)
+	allMetrics := m.withLabels(m.getSliceOfMetrics(memStats))
 	if len(allMetrics) == 0 {
 		m.logger.Error().Caller().Str("func", "*MetricsAgent.ReadMetrics").Msg("error occurred during getting MemStats metrics: no metrics in MemStats")
 		return errors.New("error occurred during getting MemStats metrics: no metrics in MemStats")
@@ -105,6 +137,7 @@ func (m *MetricsAgent) SendBatchMetricsJSON() error {
 			"Content-Type":     "application/json",
 			"Content-Encoding": "gzip",
 		}).
+		SetHeaders(m.identityHeaders()).
 		SetBody(compressedMetrics).
 		Post(route)
 	if sendMetricError != nil {
@@ -179,8 +212,9 @@ func (m *MetricsAgent) sendMetrics(metric ...models.Metrics) error {
 	m.logger.Debug().Any("metric", metric).Any("hash", headers).Msg("")
 
 	var response models.Metrics
-	_, sendMetricError := m.client.R().
+	sendResponse, sendMetricError := m.client.R().
 		SetHeaders(headers).
+		SetHeaders(m.identityHeaders()).
 		SetBody(compressedMetric).
 		SetResult(&response).
 		Post(route)
@@ -189,6 +223,11 @@ func (m *MetricsAgent) sendMetrics(metric ...models.Metrics) error {
 		return fmt.Errorf("error occurred during sending metric: %w", sendMetricError)
 	}
 
+	if sendResponse.IsError() {
+		m.logger.Error().Caller().Str("func", "*MetricsAgent.sendMetrics").Str("response.Status", sendResponse.Status()).Msg("error occurred during sending metric")
+		return fmt.Errorf("error during metrics sending: %s", sendResponse.Status())
+	}
+
 	m.logger.Info().Caller().Str("func", "*MetricsAgent.sendMetrics").Any("request", compressedMetric).Any("response", response).Msg("metric is sent!")
 	return nil
 }
@@ -212,6 +251,7 @@ func (m *MetricsAgent) SendMetrics() error {
 
 		response, sendMetricError := m.client.R().
 			SetHeader("Content-Type", "text/plain").
+			SetHeaders(m.identityHeaders()).
 			Post(route)
 		if sendMetricError != nil {
 			m.logger.Err(sendMetricError).Caller().Str("func", "*MetricsAgent.SendMetrics").Msg("error occurred during sending metric")
@@ -229,13 +269,23 @@ func (m *MetricsAgent) SendMetrics() error {
 	return nil
 }
 
-// ReadMetricsGenerator reads metrics and returns a channel that will feed the worker metrics for sending
-func (m *MetricsAgent) ReadMetricsGenerator(pollInterval *time.Ticker, reportInterval *time.Ticker) chan []models.Metrics {
+// ReadMetricsGenerator reads metrics and returns a channel that will feed the worker metrics for sending.
+// When ctx is done, tickers are stopped, last metrics are read and sent and the channel is closed
+func (m *MetricsAgent) ReadMetricsGenerator(ctx context.Context, pollInterval *time.Ticker, reportInterval *time.Ticker) chan []models.Metrics {
 	metricsChannel := make(chan []models.Metrics)
 
 	go func() {
+		defer close(metricsChannel)
+		defer pollInterval.Stop()
+		defer reportInterval.Stop()
+
 		for {
 			select {
+			case <-ctx.Done():
+				m.logger.Debug().Str("func", "ReadMetricsGenerator").Msg("time to READ and SEND last metrics")
+				_ = m.ReadMetrics()
+				metricsChannel <- m.memory.GetAllMetrics()
+				return
 			case <-pollInterval.C:
 				m.logger.Debug().Str("func", "ReadMetricsGenerator").Msg("time to READ metrics")
 				_ = m.ReadMetrics()
@@ -254,25 +304,84 @@ func (m *MetricsAgent) ReadMetricsGenerator(pollInterval *time.Ticker, reportInt
 func (m *MetricsAgent) SendMetricsWorker(metricBatches <-chan []models.Metrics) {
 	for batch := range metricBatches {
 		m.logger.Debug().Any("batch", batch).Msg("worker is called")
-		_ = m.sendMetrics(batch...)
+		_ = m.sendOrSpool(batch)
 		m.pollCount = 0
 	}
 }
 
-func (m *MetricsAgent) Run() error {
+// sendOrSpool отправляет пачку метрик. Если отправить не удалось, пачка сохраняется в буфер на диске.
+// Пока буфер не пуст, новые пачки встают в его конец, чтобы сервер получал метрики в исходном порядке.
+// Проверка буфера и отправка выполняются под spoolMu, иначе пачка другого воркера может обогнать неудачно отправленную
+func (m *MetricsAgent) sendOrSpool(batch []models.Metrics) error {
+	if m.spool == nil {
+		return m.sendMetrics(batch...)
+	}
+
+	m.spoolMu.Lock()
+	defer m.spoolMu.Unlock()
+
+	if m.spool.Len() == 0 {
+		sendErr := m.sendMetrics(batch...)
+		if sendErr == nil {
+			return nil
+		}
+		if err := m.spool.Push(batch); err != nil {
+			m.logger.Err(err).Caller().Str("func", "*MetricsAgent.sendOrSpool").Msg("error occurred during spooling metrics: batch is lost")
+			return errors.Join(sendErr, err)
+		}
+		m.logger.Warn().Str("func", "*MetricsAgent.sendOrSpool").Int("spooled batches", m.spool.Len()).Msg("server is unreachable: batch is spooled")
+		return sendErr
+	}
+
+	if err := m.spool.Push(batch); err != nil {
+		m.logger.Err(err).Caller().Str("func", "*MetricsAgent.sendOrSpool").Msg("error occurred during spooling metrics: batch is lost")
+		return err
+	}
+	return m.replaySpoolLocked()
+}
+
+// replaySpoolLocked отправляет пачки из буфера от старых к новым до первой ошибки. Вызывается под spoolMu
+func (m *MetricsAgent) replaySpoolLocked() error {
+	for {
+		seq, batch, err := m.spool.Peek()
+		if errors.Is(err, ErrSpoolEmpty) {
+			m.logger.Info().Str("func", "*MetricsAgent.replaySpoolLocked").Msg("all spooled batches are sent")
+			return nil
+		}
+		if err != nil {
+			m.logger.Err(err).Caller().Str("func", "*MetricsAgent.replaySpoolLocked").Msg("error occurred during reading spool")
+			return err
+		}
+
+		if err := m.sendMetrics(batch...); err != nil {
+			m.logger.Warn().Str("func", "*MetricsAgent.replaySpoolLocked").Int("spooled batches", m.spool.Len()).Msg("server is still unreachable")
+			return err
+		}
+		if err := m.spool.Remove(seq); err != nil {
+			m.logger.Err(err).Caller().Str("func", "*MetricsAgent.replaySpoolLocked").Msg("error occurred during removing sent batch from spool")
+			return err
+		}
+	}
+}
+
+// Run reads and sends metrics until ctx is done. Before return last metrics are sent and all workers are drained
+func (m *MetricsAgent) Run(ctx context.Context) error {
 	// reading metrics part
 	pollTicker, reportTicker := getTickers(time.Duration(m.pollInterval)*time.Second, time.Duration(m.reportInterval)*time.Second)
 	m.logger.Debug().Str("func", "Run").Msg("preparing to run goroutine for reading metrics")
-	jobs := m.ReadMetricsGenerator(pollTicker, reportTicker)
+	jobs := m.ReadMetricsGenerator(ctx, pollTicker, reportTicker)
 
 	// creating workers
 	m.logger.Debug().Str("func", "Run").Msg("creating workers")
-	m.withWorkers(func() {
+	workers := m.withWorkers(func() {
 		m.SendMetricsWorker(jobs)
 	}, m.rateLimit)
 	m.logger.Debug().Str("func", "Run").Msg("workers are created")
 
-	select {} // block main routine forever
+	// wait for the last batch to be sent
+	workers.Wait()
+	m.logger.Info().Str("func", "Run").Msg("all workers are stopped")
+	return nil
 }
 
 func newHTTPClient() *resty.Client {
@@ -283,12 +392,18 @@ func getTickers(pollIntervalDuration time.Duration, reportIntervalDuration time.
 	return time.NewTicker(pollIntervalDuration), time.NewTicker(reportIntervalDuration)
 }
 
-func (m *MetricsAgent) withWorkers(fn func(), count int64) {
+func (m *MetricsAgent) withWorkers(fn func(), count int64) *sync.WaitGroup {
+	wg := &sync.WaitGroup{}
 	for i := range count {
 		m.logger.Debug().Str("func", "withWorkers").Msgf("creating worker #%d", i)
-		go fn()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			fn()
+		}()
 		m.logger.Debug().Msgf("worker#%d is created", i)
 	}
+	return wg
 }
 
 func (m *MetricsAgent) getRoute(metric models.Metrics) (string, error) {
@@ -299,8 +414,8 @@ func (m *MetricsAgent) getRoute(metric models.Metrics) (string, error) {
 			return "", errors.New("no metric's data has been passed: field Delta is nil")
 		}
 
-		return fmt.Sprintf("%s/%s/%s/%s/%d", m.serverAddress, m.route,
-			metric.MType, metric.ID, *metric.Delta), nil
+		return fmt.Sprintf("%s/%s/%s/%s/%d%s", m.serverAddress, m.route,
+			metric.MType, metric.ID, *metric.Delta, labelsQuery(metric.Labels)), nil
 	}
 
 	if metric.MType == models.Gauge {
@@ -310,13 +425,26 @@ func (m *MetricsAgent) getRoute(metric models.Metrics) (string, error) {
 			return "", errors.New("no metric's data has been passed: field Value in nil")
 		}
 
-		return fmt.Sprintf("%s/%s/%s/%s/%s", m.serverAddress, m.route,
-			metric.MType, metric.ID, strconv.FormatFloat(*metric.Value, 'f', -1, 64)), nil
+		return fmt.Sprintf("%s/%s/%s/%s/%s%s", m.serverAddress, m.route,
+			metric.MType, metric.ID, strconv.FormatFloat(*metric.Value, 'f', -1, 64), labelsQuery(metric.Labels)), nil
 	}
 
 	return "", errors.New("error occurred during route construction")
 }
 
+// labelsQuery передает метки метрики параметрами запроса: `?label.host=web01&label.instance=web01`
+func labelsQuery(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	query := url.Values{}
+	for name, value := range labels {
+		query.Set(models.LabelQueryPrefix+name, value)
+	}
+	return "?" + query.Encode()
+}
+
 func gzipCompress(metric ...models.Metrics) ([]byte, error) {
 	var jsonData []byte
 	var err error
@@ -1,10 +1,13 @@
 package agent
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
 	"github.com/MKhiriev/stunning-adventure/models"
@@ -158,3 +161,94 @@ func mDelta(v int) *int64 {
 func mValue(v float64) *float64 {
 	return &v
 }
+
+func TestParseLabels(t *testing.T) {
+	tests := []struct {
+		name    string
+		labels  string
+		want    map[string]string
+		wantErr bool
+	}{
+		{name: "empty", labels: "", want: map[string]string{}},
+		{name: "single label", labels: "dc=eu-1", want: map[string]string{"dc": "eu-1"}},
+		{name: "several labels with spaces", labels: " dc=eu-1, role = db ,", want: map[string]string{"dc": "eu-1", "role": "db"}},
+		{name: "empty value", labels: "dc=", want: map[string]string{"dc": ""}},
+		{name: "no value", labels: "dc", wantErr: true},
+		{name: "no name", labels: "=eu-1", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			labels, err := parseLabels(test.labels)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, labels)
+		})
+	}
+}
+
+func TestAgentIdentity(t *testing.T) {
+	cfg := &config.AgentConfig{
+		ServerAddress:  "0.0.0.0",
+		ReportInterval: 2,
+		PollInterval:   1,
+		InstanceID:     "web-01",
+		Labels:         "dc=eu-1,instance=ignored",
+	}
+	agent := NewMetricsAgent("update", cfg, &zerolog.Logger{})
+
+	require.NoError(t, agent.ReadMetrics())
+	for _, metric := range agent.memory.GetAllMetrics() {
+		assert.Equal(t, "web-01", metric.Labels[models.InstanceLabel])
+		assert.Equal(t, "eu-1", metric.Labels["dc"])
+		assert.NotEmpty(t, metric.Labels[models.HostLabel])
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "web-01", r.Header.Get(models.AgentIDHeader))
+		assert.Equal(t, Version, r.Header.Get(models.AgentVersionHeader))
+		assert.Equal(t, "web-01", r.URL.Query().Get(models.LabelQueryPrefix+models.InstanceLabel))
+		assert.Equal(t, "eu-1", r.URL.Query().Get(models.LabelQueryPrefix+"dc"))
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	agent.serverAddress = server.URL
+
+	require.NoError(t, agent.SendMetrics())
+}
+
+func TestRunGracefulShutdown(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.AgentConfig{
+		ServerAddress:  "0.0.0.0",
+		ReportInterval: 60,
+		PollInterval:   60,
+		RateLimit:      2,
+	}
+	agent := NewMetricsAgent("updates", cfg, &zerolog.Logger{})
+	agent.serverAddress = server.URL
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- agent.Run(ctx)
+	}()
+	cancel()
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(10 * time.Second):
+		t.Fatal("agent did not stop")
+	}
+	// intervals are not reached: only the final batch is sent
+	assert.Equal(t, int64(1), requests.Load())
+}
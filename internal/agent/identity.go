@@ -0,0 +1,75 @@
+package agent
+
+import (
+	"fmt"
+	"maps"
+	"strconv"
+	"strings"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+// Version версия агента, передается серверу в заголовке X-Agent-Version
+const Version = "1.1.0"
+
+// parseLabels разбирает статические метки агента в форме `k=v,k2=v2`
+func parseLabels(s string) (map[string]string, error) {
+	labels := make(map[string]string)
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+
+		name, value, ok := strings.Cut(pair, "=")
+		name = strings.TrimSpace(name)
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label %q: need label in a form `name=value`", pair)
+		}
+		labels[name] = strings.TrimSpace(value)
+	}
+
+	return labels, nil
+}
+
+// agentLabels собирает метки, которые агент прикрепляет к каждой метрике.
+// Метки host и instance нельзя переопределить статическими метками
+func agentLabels(static map[string]string, hostname, instanceID string) map[string]string {
+	labels := maps.Clone(static)
+	if labels == nil {
+		labels = make(map[string]string)
+	}
+	if hostname != "" {
+		labels[models.HostLabel] = hostname
+	}
+	if instanceID != "" {
+		labels[models.InstanceLabel] = instanceID
+	}
+
+	return labels
+}
+
+// withLabels прикрепляет метки агента к метрикам
+func (m *MetricsAgent) withLabels(metrics []models.Metrics) []models.Metrics {
+	if len(m.labels) == 0 {
+		return metrics
+	}
+
+	for i := range metrics {
+		metrics[i].Labels = m.labels
+	}
+	return metrics
+}
+
+// identityHeaders заголовки, которыми агент представляется серверу
+func (m *MetricsAgent) identityHeaders() map[string]string {
+	headers := map[string]string{
+		models.AgentVersionHeader:        Version,
+		models.AgentReportIntervalHeader: strconv.FormatInt(m.reportInterval, 10),
+	}
+	if m.instanceID != "" {
+		headers[models.AgentIDHeader] = m.instanceID
+	}
+
+	return headers
+}
@@ -1,13 +1,15 @@
 package agent
 
 import (
+	"context"
+
 	"github.com/MKhiriev/stunning-adventure/models"
 )
 
 type Agent interface {
 	ReadMetrics() error
 	SendMetrics() error
-	Run() error
+	Run(ctx context.Context) error
 }
 
 type MemStorage interface {
@@ -32,7 +32,7 @@ func (c *AgentStorage) RefreshAllMetrics(metrics ...models.Metrics) {
 
 	// assign passed metrics to new map
 	for _, metric := range metrics {
-		c.metrics[metric.ID] = metric
+		c.metrics[metric.SeriesKey()] = metric
 	}
 }
 
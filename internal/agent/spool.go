@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+const spoolFileExt = ".json"
+
+// ErrSpoolEmpty в буфере нет неотправленных пачек метрик
+var ErrSpoolEmpty = errors.New("spool is empty")
+
+// Spool дисковый буфер пачек метрик, которые не удалось отправить на сервер.
+// Каждая пачка хранится в отдельном файле с порядковым номером в имени, что сохраняет порядок отправки.
+// При превышении maxSize самые старые пачки удаляются
+type Spool struct {
+	dir     string
+	maxSize int64
+	files   []spoolFile // от старых к новым
+	size    int64
+	nextSeq uint64
+	mu      *sync.Mutex
+	log     *zerolog.Logger
+}
+
+type spoolFile struct {
+	seq  uint64
+	size int64
+}
+
+// NewSpool открывает буфер в директории dir, подхватывая пачки, оставшиеся с прошлого запуска
+func NewSpool(dir string, maxSize int64, log *zerolog.Logger) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error during spool directory creation: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error during reading spool directory: %w", err)
+	}
+
+	spool := &Spool{
+		dir:     dir,
+		maxSize: maxSize,
+		mu:      &sync.Mutex{},
+		log:     log,
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, spoolFileExt) {
+			continue
+		}
+		seq, err := strconv.ParseUint(strings.TrimSuffix(name, spoolFileExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return nil, fmt.Errorf("error during reading spool file info: %w", err)
+		}
+
+		spool.files = append(spool.files, spoolFile{seq: seq, size: info.Size()})
+		spool.size += info.Size()
+		spool.nextSeq = max(spool.nextSeq, seq+1)
+	}
+	slices.SortFunc(spool.files, func(a, b spoolFile) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	log.Info().Str("func", "agent.NewSpool").Str("dir", dir).Int("batches", len(spool.files)).Int64("size", spool.size).Msg("spool opened")
+	return spool, nil
+}
+
+// Push сохраняет пачку метрик в конец буфера, вытесняя самые старые пачки при переполнении
+func (s *Spool) Push(batch []models.Metrics) error {
+	data, err := json.Marshal(batch)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	seq := s.nextSeq
+	path := s.path(seq)
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, 0o644); err != nil {
+		return fmt.Errorf("error during writing spool file: %w", err)
+	}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("error during renaming spool file: %w", err)
+	}
+
+	s.nextSeq++
+	s.files = append(s.files, spoolFile{seq: seq, size: int64(len(data))})
+	s.size += int64(len(data))
+
+	// FIFO eviction: keep the newest batch even if it alone exceeds the limit
+	for s.maxSize > 0 && s.size > s.maxSize && len(s.files) > 1 {
+		oldest := s.files[0]
+		s.log.Warn().Str("func", "*Spool.Push").Uint64("batch", oldest.seq).Msg("spool is full: the oldest batch is dropped")
+		if err := s.removeLocked(oldest.seq); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Peek возвращает самую старую пачку метрик и ее номер, не удаляя ее из буфера.
+// Поврежденные пачки отправить невозможно, поэтому они удаляются
+func (s *Spool) Peek() (uint64, []models.Metrics, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.files) > 0 {
+		seq := s.files[0].seq
+		data, err := os.ReadFile(s.path(seq))
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return 0, nil, fmt.Errorf("error during reading spool file: %w", err)
+		}
+
+		var batch []models.Metrics
+		if err == nil {
+			err = json.Unmarshal(data, &batch)
+		}
+		if err == nil {
+			return seq, batch, nil
+		}
+
+		s.log.Err(err).Str("func", "*Spool.Peek").Uint64("batch", seq).Msg("corrupted batch is dropped")
+		if err := s.removeLocked(seq); err != nil {
+			return 0, nil, err
+		}
+	}
+
+	return 0, nil, ErrSpoolEmpty
+}
+
+// Remove удаляет отправленную пачку из буфера
+func (s *Spool) Remove(seq uint64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.removeLocked(seq)
+}
+
+// Len количество пачек в буфере
+func (s *Spool) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.files)
+}
+
+func (s *Spool) removeLocked(seq uint64) error {
+	idx := slices.IndexFunc(s.files, func(f spoolFile) bool { return f.seq == seq })
+	if idx < 0 {
+		return nil
+	}
+
+	if err := os.Remove(s.path(seq)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("error during removing spool file: %w", err)
+	}
+	s.size -= s.files[idx].size
+	s.files = slices.Delete(s.files, idx, idx+1)
+	return nil
+}
+
+func (s *Spool) path(seq uint64) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%020d%s", seq, spoolFileExt))
+}
@@ -0,0 +1,169 @@
+package agent
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSpool(t *testing.T) {
+	logger := zerolog.Nop()
+	dir := t.TempDir()
+
+	batch := func(delta int) []models.Metrics {
+		return []models.Metrics{
+			{ID: "PollCount", MType: models.Counter, Delta: mDelta(delta)},
+			{ID: "PollCount", MType: models.Counter, Delta: mDelta(delta * 10), Labels: map[string]string{"n": "10"}},
+		}
+	}
+	batchSize := func() int64 {
+		data, err := json.Marshal(batch(1))
+		require.NoError(t, err)
+		return int64(len(data))
+	}()
+
+	// room for 3 batches
+	spool, err := NewSpool(dir, 3*batchSize, &logger)
+	require.NoError(t, err)
+	_, _, err = spool.Peek()
+	require.ErrorIs(t, err, ErrSpoolEmpty)
+
+	for delta := 1; delta <= 4; delta++ {
+		require.NoError(t, spool.Push(batch(delta)))
+	}
+	assert.Equal(t, 3, spool.Len(), "the oldest batch must be evicted")
+
+	// reopen spool: batches are kept on disk
+	spool, err = NewSpool(dir, 3*batchSize, &logger)
+	require.NoError(t, err)
+	require.Equal(t, 3, spool.Len())
+
+	// corrupted batch is dropped
+	require.NoError(t, spool.Push(batch(5)))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "00000000000000000001.json"), []byte("[{"), 0o644))
+
+	var deltas []int64
+	for {
+		seq, metrics, err := spool.Peek()
+		if err != nil {
+			require.ErrorIs(t, err, ErrSpoolEmpty)
+			break
+		}
+		deltas = append(deltas, *metrics[0].Delta)
+		require.NoError(t, spool.Remove(seq))
+	}
+	assert.Equal(t, []int64{3, 4, 5}, deltas)
+	assert.Equal(t, 0, spool.Len())
+}
+
+func TestSendOrSpool(t *testing.T) {
+	var available atomic.Bool
+	var received []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !available.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var metrics []models.Metrics
+		require.NoError(t, json.NewDecoder(gz).Decode(&metrics))
+		for _, metric := range metrics {
+			received = append(received, *metric.Delta)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.AgentConfig{
+		ServerAddress:  "0.0.0.0",
+		ReportInterval: 2,
+		PollInterval:   1,
+		SpoolDir:       t.TempDir(),
+	}
+	agent := NewMetricsAgent("updates", cfg, &zerolog.Logger{})
+	agent.serverAddress = server.URL
+
+	batch := func(delta int) []models.Metrics {
+		return []models.Metrics{
+			{ID: "PollCount", MType: models.Counter, Delta: mDelta(delta)},
+			{ID: "PollCount", MType: models.Counter, Delta: mDelta(delta * 10), Labels: map[string]string{"n": "10"}},
+		}
+	}
+
+	// server is down: batches are spooled
+	require.Error(t, agent.sendOrSpool(batch(1)))
+	require.Error(t, agent.sendOrSpool(batch(2)))
+	assert.Equal(t, 2, agent.spool.Len())
+	assert.Empty(t, received)
+
+	// server is back: spooled batches are sent before the new one
+	available.Store(true)
+	require.NoError(t, agent.sendOrSpool(batch(3)))
+	assert.Equal(t, 0, agent.spool.Len())
+	assert.Equal(t, []int64{1, 10, 2, 20, 3, 30}, received)
+}
+
+func TestSendOrSpool_Concurrent(t *testing.T) {
+	var requests atomic.Int32
+	var received []int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first request is slow and fails
+		if requests.Add(1) == 1 {
+			time.Sleep(200 * time.Millisecond)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		gz, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		var metrics []models.Metrics
+		require.NoError(t, json.NewDecoder(gz).Decode(&metrics))
+		for _, metric := range metrics {
+			received = append(received, *metric.Delta)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	cfg := &config.AgentConfig{
+		ServerAddress:  "0.0.0.0",
+		ReportInterval: 2,
+		PollInterval:   1,
+		SpoolDir:       t.TempDir(),
+	}
+	agent := NewMetricsAgent("updates", cfg, &zerolog.Logger{})
+	agent.serverAddress = server.URL
+
+	batch := func(delta int) []models.Metrics {
+		return []models.Metrics{
+			{ID: "PollCount", MType: models.Counter, Delta: mDelta(delta)},
+			{ID: "PollCount", MType: models.Counter, Delta: mDelta(delta * 10), Labels: map[string]string{"n": "10"}},
+		}
+	}
+
+	// the second batch, sent while the first one is failing, must not overtake it
+	firstErr := make(chan error)
+	go func() {
+		firstErr <- agent.sendOrSpool(batch(1))
+	}()
+	time.Sleep(50 * time.Millisecond)
+	require.NoError(t, agent.sendOrSpool(batch(2)))
+	require.Error(t, <-firstErr)
+
+	assert.Equal(t, 0, agent.spool.Len())
+	assert.Equal(t, []int64{1, 10, 2, 20}, received)
+}
@@ -2,8 +2,11 @@ package config
 
 import (
 	"errors"
+	"fmt"
 	"log"
+	"os"
 
+	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/caarlos0/env/v11"
 )
 
@@ -13,15 +16,34 @@ type AgentConfig struct {
 	PollInterval   int64  `env:"POLL_INTERVAL"`
 	HashKey        string `env:"KEY"`
 	RateLimit      int64  `env:"RATE_LIMIT"`
+	InstanceID     string `env:"INSTANCE_ID"`
+	Labels         string `env:"AGENT_LABELS"`
+	SpoolDir       string `env:"SPOOL_DIR"`
+	SpoolMaxSize   int64  `env:"SPOOL_MAX_SIZE"`
 }
 
 type ServerConfig struct {
-	ServerAddress          string `env:"ADDRESS"`
-	StoreInterval          int64  `env:"STORE_INTERVAL"`
-	FileStoragePath        string `env:"FILE_STORAGE_PATH"`
-	RestoreMetricsFromFile bool   `env:"RESTORE"`
-	DatabaseDSN            string `env:"DATABASE_DSN"`
-	HashKey                string `env:"KEY"`
+	ServerAddress           string `env:"ADDRESS"`
+	StoreInterval           int64  `env:"STORE_INTERVAL"`
+	FileStoragePath         string `env:"FILE_STORAGE_PATH"`
+	RestoreMetricsFromFile  bool   `env:"RESTORE"`
+	BoltPath                string `env:"BOLT_PATH"`
+	DatabaseDSN             string `env:"DATABASE_DSN"`
+	DatabaseMaxConns        int    `env:"DATABASE_MAX_CONNS"`
+	DatabaseMinConns        int    `env:"DATABASE_MIN_CONNS"`
+	DatabaseMaxConnLifetime int64  `env:"DATABASE_MAX_CONN_LIFETIME"`
+	DatabaseCache           bool   `env:"DATABASE_CACHE"`
+	HashKey                 string `env:"KEY"`
+	StoreHistory            bool   `env:"STORE_HISTORY"`
+	StatsDAddress           string `env:"STATSD_ADDRESS"`
+	StatsDFlushInterval     int64  `env:"STATSD_FLUSH_INTERVAL"`
+	GraphiteAddress         string `env:"GRAPHITE_ADDRESS"`
+	AgentReportInterval     int64  `env:"AGENT_REPORT_INTERVAL"`
+	AgentStaleIntervals     int64  `env:"AGENT_STALE_INTERVALS"`
+	RetentionTTL            int64  `env:"RETENTION_TTL"`
+	RetentionRules          string `env:"RETENTION_RULES"`
+	RetentionInterval       int64  `env:"RETENTION_INTERVAL"`
+	HistogramBuckets        string `env:"HISTOGRAM_BUCKETS"`
 }
 
 func GetAgentConfigs() *AgentConfig {
@@ -37,22 +59,34 @@ func GetAgentConfigs() *AgentConfig {
 	}
 
 	// else get command line args or default values
-	commandLineServerAddress, commandLinePollInterval, commandLineReportInterval, commandLineHashKey, commandLineRateLimit := ParseAgentFlags()
+	flags := ParseAgentFlags()
 
 	if cfg.ServerAddress == "" {
-		cfg.ServerAddress = commandLineServerAddress
+		cfg.ServerAddress = flags.ServerAddress
 	}
 	if cfg.PollInterval == 0 {
-		cfg.PollInterval = commandLinePollInterval
+		cfg.PollInterval = flags.PollInterval
 	}
 	if cfg.ReportInterval == 0 {
-		cfg.ReportInterval = commandLineReportInterval
+		cfg.ReportInterval = flags.ReportInterval
 	}
 	if cfg.HashKey == "" {
-		cfg.HashKey = commandLineHashKey
+		cfg.HashKey = flags.HashKey
 	}
 	if cfg.RateLimit == 0 {
-		cfg.RateLimit = commandLineRateLimit
+		cfg.RateLimit = flags.RateLimit
+	}
+	if cfg.InstanceID == "" {
+		cfg.InstanceID = flags.InstanceID
+	}
+	if cfg.Labels == "" {
+		cfg.Labels = flags.Labels
+	}
+	if cfg.SpoolDir == "" {
+		cfg.SpoolDir = flags.SpoolDir
+	}
+	if cfg.SpoolMaxSize == 0 {
+		cfg.SpoolMaxSize = flags.SpoolMaxSize
 	}
 
 	return cfg
@@ -65,31 +99,79 @@ func GetServerConfigs() (*ServerConfig, error) {
 		log.Fatal(err)
 	}
 
+	// STORE_INTERVAL=0 is a valid value (synchronous saving), so check if it is set
+	_, storeIntervalSet := os.LookupEnv("STORE_INTERVAL")
+
 	// if all values are not nil return cfg
-	if cfg.ServerAddress != "" && cfg.StoreInterval != 0 {
+	if cfg.ServerAddress != "" && storeIntervalSet {
 		return cfg, cfg.Validate()
 	}
 
 	// else get command line args or default values
-	commandLineServerAddress, commandLineStoreInterval, commandLineFileStoragePath, commandLineRestore, databaseDSN, commandLineHashKey := ParseServerFlags()
+	flags := ParseServerFlags()
 
 	if cfg.ServerAddress == "" {
-		cfg.ServerAddress = commandLineServerAddress
+		cfg.ServerAddress = flags.ServerAddress
 	}
-	if cfg.StoreInterval == 0 {
-		cfg.StoreInterval = commandLineStoreInterval
+	if !storeIntervalSet {
+		cfg.StoreInterval = flags.StoreInterval
 	}
 	if cfg.FileStoragePath == "" {
-		cfg.FileStoragePath = commandLineFileStoragePath
+		cfg.FileStoragePath = flags.FileStoragePath
 	}
 	if !cfg.RestoreMetricsFromFile {
-		cfg.RestoreMetricsFromFile = commandLineRestore
+		cfg.RestoreMetricsFromFile = flags.RestoreMetricsFromFile
+	}
+	if cfg.BoltPath == "" {
+		cfg.BoltPath = flags.BoltPath
 	}
 	if cfg.DatabaseDSN == "" {
-		cfg.DatabaseDSN = databaseDSN
+		cfg.DatabaseDSN = flags.DatabaseDSN
+	}
+	if cfg.DatabaseMaxConns == 0 {
+		cfg.DatabaseMaxConns = flags.DatabaseMaxConns
+	}
+	if cfg.DatabaseMinConns == 0 {
+		cfg.DatabaseMinConns = flags.DatabaseMinConns
+	}
+	if cfg.DatabaseMaxConnLifetime == 0 {
+		cfg.DatabaseMaxConnLifetime = flags.DatabaseMaxConnLifetime
+	}
+	if !cfg.DatabaseCache {
+		cfg.DatabaseCache = flags.DatabaseCache
 	}
 	if cfg.HashKey == "" {
-		cfg.HashKey = commandLineHashKey
+		cfg.HashKey = flags.HashKey
+	}
+	if !cfg.StoreHistory {
+		cfg.StoreHistory = flags.StoreHistory
+	}
+	if cfg.StatsDAddress == "" {
+		cfg.StatsDAddress = flags.StatsDAddress
+	}
+	if cfg.StatsDFlushInterval == 0 {
+		cfg.StatsDFlushInterval = flags.StatsDFlushInterval
+	}
+	if cfg.GraphiteAddress == "" {
+		cfg.GraphiteAddress = flags.GraphiteAddress
+	}
+	if cfg.AgentReportInterval == 0 {
+		cfg.AgentReportInterval = flags.AgentReportInterval
+	}
+	if cfg.AgentStaleIntervals == 0 {
+		cfg.AgentStaleIntervals = flags.AgentStaleIntervals
+	}
+	if cfg.RetentionTTL == 0 {
+		cfg.RetentionTTL = flags.RetentionTTL
+	}
+	if cfg.RetentionRules == "" {
+		cfg.RetentionRules = flags.RetentionRules
+	}
+	if cfg.RetentionInterval == 0 {
+		cfg.RetentionInterval = flags.RetentionInterval
+	}
+	if cfg.HistogramBuckets == "" {
+		cfg.HistogramBuckets = flags.HistogramBuckets
 	}
 
 	return cfg, cfg.Validate()
@@ -99,8 +181,13 @@ func (s *ServerConfig) Validate() error {
 	switch {
 	case s.ServerAddress == "":
 		return errors.New("invalid Server Address")
-	case s.StoreInterval == 0:
+	case s.StoreInterval < 0:
 		return errors.New("invalid Store Interval")
+	case s.RetentionTTL < 0:
+		return errors.New("invalid Retention TTL")
+	}
+	if _, err := models.ParseHistogramBounds(s.HistogramBuckets); err != nil {
+		return fmt.Errorf("invalid Histogram Buckets: %w", err)
 	}
 
 	return nil
@@ -15,9 +15,28 @@ const (
 	defaultStoreInterval   = int64(300)
 	defaultFileStoragePath = ""
 	defaultRestoreValue    = false
+	defaultBoltPath        = ""
 	defaultDatabaseDSN     = ""
+	defaultDBMaxConns      = 0
+	defaultDBMinConns      = 0
+	defaultDBConnLifetime  = int64(0)
+	defaultDBCache         = false
 	defaultHashKey         = ""
 	defaultRateLimit       = int64(1)
+	defaultStoreHistory    = false
+	defaultStatsDAddress   = ""
+	defaultStatsDFlush     = int64(10)
+	defaultGraphiteAddress = ""
+	defaultInstanceID      = ""
+	defaultAgentReport     = int64(5)
+	defaultAgentStale      = int64(3)
+	defaultAgentLabels     = ""
+	defaultSpoolDir        = ""
+	defaultSpoolMaxSize    = int64(10 << 20)
+	defaultRetentionTTL    = int64(0)
+	defaultRetentionRules  = ""
+	defaultRetentionPeriod = int64(60)
+	defaultHistogramBounds = ""
 )
 
 type NetAddress struct {
@@ -25,35 +44,58 @@ type NetAddress struct {
 	Port int
 }
 
-func ParseServerFlags() (netAddress string, storeInterval int64, fileStoragePath string, restore bool, databaseDSN string, hashKey string) {
+func ParseServerFlags() *ServerConfig {
 	serverAddress := NetAddress{}
 	_ = flag.Value(&serverAddress)
+	cfg := &ServerConfig{}
 
 	flag.Var(&serverAddress, "a", "Net address host:port")
-	flag.Int64Var(&storeInterval, "i", defaultStoreInterval, "Store interval in seconds")
-	flag.StringVar(&fileStoragePath, "f", defaultFileStoragePath, "Storage file path string")
-	flag.BoolVar(&restore, "r", defaultRestoreValue, "Boolean - restore previous metrics from file")
-	flag.StringVar(&databaseDSN, "d", defaultDatabaseDSN, "Postgres database connection string")
-	flag.StringVar(&hashKey, "k", defaultHashKey, "Hash key for hashing")
+	flag.Int64Var(&cfg.StoreInterval, "i", defaultStoreInterval, "Store interval in seconds (0 - save to file synchronously)")
+	flag.StringVar(&cfg.FileStoragePath, "f", defaultFileStoragePath, "Storage file path string")
+	flag.BoolVar(&cfg.RestoreMetricsFromFile, "r", defaultRestoreValue, "Boolean - restore previous metrics from file")
+	flag.StringVar(&cfg.BoltPath, "bolt", defaultBoltPath, "Embedded bolt database file path (used if no database DSN is set)")
+	flag.StringVar(&cfg.DatabaseDSN, "d", defaultDatabaseDSN, "Postgres database connection string or sqlite://path for SQLite")
+	flag.IntVar(&cfg.DatabaseMaxConns, "db-max-conns", defaultDBMaxConns, "Maximum size of database connection pool (pgxpool default if 0)")
+	flag.IntVar(&cfg.DatabaseMinConns, "db-min-conns", defaultDBMinConns, "Minimum size of database connection pool")
+	flag.Int64Var(&cfg.DatabaseMaxConnLifetime, "db-conn-lifetime", defaultDBConnLifetime, "Maximum lifetime of database connection in seconds (pgxpool default if 0)")
+	flag.BoolVar(&cfg.DatabaseCache, "db-cache", defaultDBCache, "Boolean - serve reads from in-memory cache, writes go through to database")
+	flag.StringVar(&cfg.HashKey, "k", defaultHashKey, "Hash key for hashing")
+	flag.BoolVar(&cfg.StoreHistory, "history", defaultStoreHistory, "Boolean - keep timestamped history of every saved metric")
+	flag.StringVar(&cfg.StatsDAddress, "statsd", defaultStatsDAddress, "StatsD UDP and TCP listener address host:port (disabled if empty)")
+	flag.Int64Var(&cfg.StatsDFlushInterval, "statsd-flush", defaultStatsDFlush, "StatsD flush interval in seconds")
+	flag.StringVar(&cfg.GraphiteAddress, "graphite", defaultGraphiteAddress, "Graphite plaintext TCP listener address host:port (disabled if empty)")
+	flag.Int64Var(&cfg.AgentReportInterval, "agent-report", defaultAgentReport, "Agent report interval in seconds, if agent does not send its own")
+	flag.Int64Var(&cfg.AgentStaleIntervals, "agent-stale", defaultAgentStale, "Number of missed report intervals after which agent is stale")
+	flag.Int64Var(&cfg.RetentionTTL, "retention", defaultRetentionTTL, "Delete metrics not updated for this number of seconds (disabled if 0)")
+	flag.StringVar(&cfg.RetentionRules, "retention-rules", defaultRetentionRules, "Retention TTL in seconds by metric name regex in a form `regex=ttl;regex2=ttl2`, first match wins, 0 - keep forever")
+	flag.Int64Var(&cfg.RetentionInterval, "retention-interval", defaultRetentionPeriod, "How often expired metrics are deleted, in seconds")
+	flag.StringVar(&cfg.HistogramBuckets, "histogram-buckets", defaultHistogramBounds, "Comma separated bucket bounds for histogram values sent via URL, e.g. `0.1,0.5,1` (Prometheus defaults if empty)")
 
 	flag.Parse()
 
-	return serverAddress.String(), storeInterval, fileStoragePath, restore, databaseDSN, hashKey
+	cfg.ServerAddress = serverAddress.String()
+	return cfg
 }
 
-func ParseAgentFlags() (netAddress string, pollInterval int64, reportInterval int64, hashKey string, rateLimit int64) {
+func ParseAgentFlags() *AgentConfig {
 	serverAddress := NetAddress{}
 	_ = flag.Value(&serverAddress)
+	cfg := &AgentConfig{}
 
 	flag.Var(&serverAddress, "a", "Net address host:port")
-	flag.Int64Var(&pollInterval, "p", defaultPollInterval, "Poll interval in seconds")
-	flag.Int64Var(&reportInterval, "r", defaultReportInterval, "Report interval in seconds")
-	flag.StringVar(&hashKey, "k", defaultHashKey, "Hash key for hashing")
-	flag.Int64Var(&rateLimit, "l", defaultRateLimit, "Concurrent request limit to the server")
+	flag.Int64Var(&cfg.PollInterval, "p", defaultPollInterval, "Poll interval in seconds")
+	flag.Int64Var(&cfg.ReportInterval, "r", defaultReportInterval, "Report interval in seconds")
+	flag.StringVar(&cfg.HashKey, "k", defaultHashKey, "Hash key for hashing")
+	flag.Int64Var(&cfg.RateLimit, "l", defaultRateLimit, "Concurrent request limit to the server")
+	flag.StringVar(&cfg.InstanceID, "id", defaultInstanceID, "Agent instance ID (hostname if empty)")
+	flag.StringVar(&cfg.Labels, "labels", defaultAgentLabels, "Static labels attached to every metric in a form `k=v,k2=v2`")
+	flag.StringVar(&cfg.SpoolDir, "spool", defaultSpoolDir, "Directory for unsent metric batches (disabled if empty)")
+	flag.Int64Var(&cfg.SpoolMaxSize, "spool-size", defaultSpoolMaxSize, "Spool size limit in bytes, the oldest batches are dropped first")
 
 	flag.Parse()
 
-	return serverAddress.String(), pollInterval, reportInterval, hashKey, rateLimit
+	cfg.ServerAddress = serverAddress.String()
+	return cfg
 }
 
 func (a *NetAddress) String() string {
@@ -0,0 +1,74 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+func (h *Handler) GetAgents(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	if h.agentRegistry == nil {
+		h.logger.Error().Caller().Str("func", "*Handler.GetAgents").Msg("agent registry is not configured")
+		http.Error(w, http.StatusText(http.StatusNotImplemented), http.StatusNotImplemented)
+		return
+	}
+
+	agents, err := h.agentRegistry.GetAll(ctx)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetAgents").Msg("error getting all agents from registry")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	agentsJSON, err := json.Marshal(agents)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetAgents").Msg("error occurred during marshalling agents to JSON")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(agentsJSON)
+}
+
+// registerAgent отмечает в реестре агента, приславшего метрики.
+// Агент определяется по заголовку X-Agent-ID, а если его нет - по метке instance метрик
+func (h *Handler) registerAgent(ctx context.Context, r *http.Request, metrics ...models.Metrics) {
+	if h.agentRegistry == nil {
+		return
+	}
+
+	agentID := r.Header.Get(models.AgentIDHeader)
+	for _, metric := range metrics {
+		if agentID != "" {
+			break
+		}
+		agentID = metric.Labels[models.InstanceLabel]
+	}
+	if agentID == "" {
+		return
+	}
+
+	address, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		address = r.RemoteAddr
+	}
+	reportInterval, _ := strconv.ParseInt(r.Header.Get(models.AgentReportIntervalHeader), 10, 64)
+
+	agent := models.AgentInfo{
+		ID:             agentID,
+		Address:        address,
+		Version:        r.Header.Get(models.AgentVersionHeader),
+		ReportInterval: reportInterval,
+		LastReport:     len(metrics),
+	}
+	if err := h.agentRegistry.Register(ctx, agent); err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.registerAgent").Str("agent", agentID).Msg("error during agent registration")
+	}
+}
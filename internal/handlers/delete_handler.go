@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/MKhiriev/stunning-adventure/internal/validators"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// DeleteMetric удаляет одну метрику: DELETE /value/{metricType}/{metricName}?label=value
+func (h *Handler) DeleteMetric(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	metric := models.Metrics{
+		ID:     chi.URLParam(r, "metricName"),
+		MType:  chi.URLParam(r, "metricType"),
+		Labels: labelsFromQuery(r),
+	}
+
+	deleted, err := h.metricsService.Delete(ctx, []models.Metrics{metric})
+	if err != nil {
+		h.writeDeleteError(w, "*Handler.DeleteMetric", err)
+		return
+	}
+	if deleted == 0 {
+		h.logger.Info().Str("func", "*Handler.DeleteMetric").Any("metric", metric).Msg("metric to delete is not found")
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+
+	h.logger.Info().Str("func", "*Handler.DeleteMetric").Any("metric", metric).Msg("metric is deleted")
+	w.WriteHeader(http.StatusOK)
+}
+
+// BatchDeleteMetricJSON удаляет метрики из JSON-массива вида [{"id":"Alloc","type":"gauge"}]
+func (h *Handler) BatchDeleteMetricJSON(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var metricsFromBody []models.Metrics
+
+	if err := json.NewDecoder(r.Body).Decode(&metricsFromBody); err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.BatchDeleteMetricJSON").Msg("Invalid JSON was passed")
+		http.Error(w, "Invalid JSON was passed", http.StatusBadRequest)
+		return
+	}
+
+	deleted, err := h.metricsService.Delete(ctx, metricsFromBody)
+	if err != nil {
+		h.writeDeleteError(w, "*Handler.BatchDeleteMetricJSON", err)
+		return
+	}
+
+	h.logger.Info().Str("func", "*Handler.BatchDeleteMetricJSON").Int("deleted", deleted).Msg("metrics are deleted")
+	h.writeJSON(w, "*Handler.BatchDeleteMetricJSON", models.DeleteResult{Deleted: deleted})
+}
+
+// PurgeMetrics удаляет все метрики, подходящие под фильтр, например {"prefix":"test_"} или {"labels":{"host":"old"}}
+func (h *Handler) PurgeMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	var filter models.MetricsFilter
+
+	if err := json.NewDecoder(r.Body).Decode(&filter); err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.PurgeMetrics").Msg("Invalid JSON was passed")
+		http.Error(w, "Invalid JSON was passed", http.StatusBadRequest)
+		return
+	}
+
+	purged, err := h.metricsService.Purge(ctx, filter)
+	if err != nil {
+		h.writeDeleteError(w, "*Handler.PurgeMetrics", err)
+		return
+	}
+
+	h.logger.Info().Str("func", "*Handler.PurgeMetrics").Any("filter", filter).Int("deleted", len(purged)).Msg("metrics are purged")
+	h.writeJSON(w, "*Handler.PurgeMetrics", models.DeleteResult{Deleted: len(purged), Metrics: purged})
+}
+
+func (h *Handler) writeDeleteError(w http.ResponseWriter, funcName string, err error) {
+	switch {
+	case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrInvalidType) || errors.Is(err, validators.ErrInvalidLabel) ||
+		errors.Is(err, validators.ErrEmptyFilter) || errors.Is(err, validators.ErrInvalidRegex):
+		h.logger.Err(err).Caller().Str("func", funcName).Msg("passed metrics are not valid")
+		http.Error(w, err.Error(), http.StatusBadRequest)
+	default:
+		h.logger.Err(err).Caller().Str("func", funcName).Msg("error occurred during deleting metrics")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+func (h *Handler) writeJSON(w http.ResponseWriter, funcName string, response any) {
+	responseJSON, err := json.Marshal(response)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", funcName).Msg("error occurred during marshalling response to JSON")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(responseJSON)
+}
@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeleteMetrics(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	for _, route := range []string{
+		"/update/gauge/Alloc/1",
+		"/update/gauge/Alloc/2?label.host=old",
+		"/update/counter/PollCount/5",
+		"/update/gauge/test_one/1",
+		"/update/gauge/test_two/2",
+		"/update/gauge/Sys/1?label.host=old",
+		"/update/gauge/Sys/1?label.host=new",
+	} {
+		res, _ := testRequest(t, ts, http.MethodPost, route)
+		require.Equal(t, http.StatusOK, res.StatusCode, route)
+	}
+
+	tests := []struct {
+		name        string
+		method      string
+		route       string
+		body        string
+		wantCode    int
+		wantDeleted int      // for JSON responses
+		wantLeft    []string // metrics left after request: `type/name?labels`
+	}{
+		{
+			name:     "delete metric with labels",
+			method:   http.MethodDelete,
+			route:    "/value/gauge/Alloc?label.host=old",
+			wantCode: http.StatusOK,
+			wantLeft: []string{"gauge/Alloc", "counter/PollCount", "gauge/test_one", "gauge/test_two", "gauge/Sys?host=old", "gauge/Sys?host=new"},
+		},
+		{
+			name:     "delete missing metric",
+			method:   http.MethodDelete,
+			route:    "/value/gauge/Alloc?label.host=old",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "delete metric of wrong type",
+			method:   http.MethodDelete,
+			route:    "/value/counter/Alloc",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "delete metric of unknown type",
+			method:   http.MethodDelete,
+			route:    "/value/unknown/Alloc",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "bulk delete",
+			method:      http.MethodPost,
+			route:       "/delete/",
+			body:        `[{"id":"Alloc","type":"gauge"},{"id":"PollCount","type":"counter"},{"id":"Missing","type":"gauge"}]`,
+			wantCode:    http.StatusOK,
+			wantDeleted: 2,
+			wantLeft:    []string{"gauge/test_one", "gauge/test_two", "gauge/Sys?host=old", "gauge/Sys?host=new"},
+		},
+		{
+			name:     "bulk delete without type",
+			method:   http.MethodPost,
+			route:    "/delete/",
+			body:     `[{"id":"Sys"}]`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:        "purge by labels",
+			method:      http.MethodPost,
+			route:       "/purge/",
+			body:        `{"labels":{"host":"old"}}`,
+			wantCode:    http.StatusOK,
+			wantDeleted: 1,
+			wantLeft:    []string{"gauge/test_one", "gauge/test_two", "gauge/Sys?host=new"},
+		},
+		{
+			name:        "purge by prefix and regex",
+			method:      http.MethodPost,
+			route:       "/purge/",
+			body:        `{"prefix":"test_","regex":"o$"}`,
+			wantCode:    http.StatusOK,
+			wantDeleted: 1,
+			wantLeft:    []string{"gauge/test_one", "gauge/Sys?host=new"},
+		},
+		{
+			name:     "purge with empty filter",
+			method:   http.MethodPost,
+			route:    "/purge/",
+			body:     `{}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "purge with invalid regex",
+			method:   http.MethodPost,
+			route:    "/purge/",
+			body:     `{"regex":"(test"}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(test.method, ts.URL+test.route, strings.NewReader(test.body))
+			require.NoError(t, err)
+			res, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.wantCode, res.StatusCode)
+			if test.wantDeleted > 0 {
+				var result models.DeleteResult
+				require.NoError(t, json.Unmarshal(body, &result))
+				assert.Equal(t, test.wantDeleted, result.Deleted)
+			}
+			if test.wantLeft == nil {
+				return
+			}
+
+			all, err := h.metricsService.GetAll(req.Context())
+			require.NoError(t, err)
+			left := make([]string, 0, len(all))
+			for _, metric := range all {
+				name := metric.MType + "/" + metric.ID
+				if len(metric.Labels) > 0 {
+					name += "?" + metric.LabelsString()
+				}
+				left = append(left, name)
+			}
+			assert.ElementsMatch(t, test.wantLeft, left)
+		})
+	}
+}
@@ -22,7 +22,8 @@ func GZip(next http.Handler) http.Handler {
 			}
 			defer gzipReader.Close()
 
-			req.Body = gzipReader
+			// limit decompressed size too: a small gzip bomb would pass LimitRequestBody
+			req.Body = http.MaxBytesReader(w, gzipReader, maxRequestBodySize)
 			req.Header.Del("Content-Encoding")
 		}
 
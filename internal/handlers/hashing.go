@@ -25,10 +25,8 @@ func (h *Handler) WithHashing(next http.Handler) http.Handler {
 		}
 		h.logger.Debug().Str("func", "*Handler.WithHashing").Msg("checking hash begins")
 
-		body, err := io.ReadAll(r.Body)
-		if err != nil {
-			h.logger.Err(err).Str("func", "*Handler.WithHashing").Msg("failed to read request body")
-			w.WriteHeader(http.StatusInternalServerError)
+		body, ok := h.readBody(w, r, "*Handler.WithHashing")
+		if !ok {
 			return
 		}
 		r.Body = io.NopCloser(bytes.NewReader(body))
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/service"
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/internal/validators"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/go-chi/chi/v5"
+)
+
+// defaultHistoryPeriod период запроса истории, если параметр `from` не передан
+const defaultHistoryPeriod = time.Hour
+
+func (h *Handler) GetMetricHistory(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	if h.historyService == nil {
+		h.logger.Error().Caller().Str("func", "*Handler.GetMetricHistory").Msg("metric history is not enabled")
+		http.Error(w, "metric history is not enabled", http.StatusNotImplemented)
+		return
+	}
+
+	metric := models.Metrics{
+		ID:     chi.URLParam(r, "metricName"),
+		MType:  chi.URLParam(r, "metricType"),
+		Labels: labelsFromQuery(r),
+	}
+	if err := h.metricValidator.Validate(ctx, metric, validators.ID, validators.MType, validators.Labels); err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricHistory").Any("metric", metric).Msg("passed metric is not valid")
+		http.Error(w, "passed metric is not valid", http.StatusBadRequest)
+		return
+	}
+
+	query, err := parseHistoryQuery(r)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricHistory").Msg("invalid history query parameters")
+		http.Error(w, "invalid query parameters: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+	query.Metric = metric
+
+	history, err := h.historyService.GetHistory(ctx, query)
+	if err != nil {
+		switch {
+		case errors.Is(err, store.ErrNotFound):
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricHistory").Any("metric", metric).Msg("metric history not found")
+			http.Error(w, "metric not found", http.StatusNotFound)
+			return
+		case errors.Is(err, service.ErrInvalidAggregation) || errors.Is(err, service.ErrInvalidTimeRange):
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricHistory").Any("query", query).Msg("history query is not valid")
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		default:
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricHistory").Msg("error occurred during getting metric history")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	historyJSON, err := json.Marshal(history)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricHistory").Msg("error occurred during marshalling metric history to JSON")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(historyJSON)
+}
+
+func parseHistoryQuery(r *http.Request) (models.HistoryQuery, error) {
+	var err error
+	params := r.URL.Query()
+	query := models.HistoryQuery{
+		To:          time.Now(),
+		Aggregation: params.Get("agg"),
+	}
+
+	if to := params.Get("to"); to != "" {
+		if query.To, err = parseTime(to); err != nil {
+			return models.HistoryQuery{}, errors.New("`to` must be RFC3339 time or unix seconds")
+		}
+	}
+
+	query.From = query.To.Add(-defaultHistoryPeriod)
+	if from := params.Get("from"); from != "" {
+		if query.From, err = parseTime(from); err != nil {
+			return models.HistoryQuery{}, errors.New("`from` must be RFC3339 time or unix seconds")
+		}
+	}
+
+	if step := params.Get("step"); step != "" {
+		if query.Step, err = parseStep(step); err != nil {
+			return models.HistoryQuery{}, errors.New("`step` must be a positive duration in whole seconds (e.g. 30s) or seconds")
+		}
+	}
+
+	return query, nil
+}
+
+func parseTime(value string) (time.Time, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+func parseStep(value string) (time.Duration, error) {
+	step, err := time.ParseDuration(value)
+	if err != nil {
+		seconds, convErr := strconv.ParseInt(value, 10, 64)
+		if convErr != nil {
+			return 0, convErr
+		}
+		step = time.Duration(seconds) * time.Second
+	}
+	if step <= 0 {
+		return 0, errors.New("step is not positive")
+	}
+	// step is reported back in whole seconds, so shorter or fractional steps would be misreported
+	if step%time.Second != 0 {
+		return 0, errors.New("step is not a whole number of seconds")
+	}
+	return step, nil
+}
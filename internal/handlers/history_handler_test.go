@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStep(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{value: "30s", want: 30 * time.Second},
+		{value: "1m", want: time.Minute},
+		{value: "15", want: 15 * time.Second},
+		{value: "0", wantErr: true},
+		{value: "-5s", wantErr: true},
+		{value: "500ms", wantErr: true},
+		{value: "1.5s", wantErr: true},
+		{value: "abc", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			step, err := parseStep(tt.value)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, step)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MKhiriev/stunning-adventure/internal/ingest"
+	"github.com/MKhiriev/stunning-adventure/internal/validators"
+)
+
+// InfluxWrite принимает метрики в формате InfluxDB line protocol
+func (h *Handler) InfluxWrite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, ok := h.readBody(w, r, "*Handler.InfluxWrite")
+	if !ok {
+		return
+	}
+
+	metrics, err := ingest.ParseLineProtocol(body)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.InfluxWrite").Msg("invalid line protocol was passed")
+		http.Error(w, "invalid line protocol: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.logger.Info().Str("func", "*Handler.InfluxWrite").Int("metrics", len(metrics)).Msg("InfluxWrite was called!")
+
+	if len(metrics) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err = h.metricsService.SaveAll(ctx, metrics); err != nil {
+		switch {
+		case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType) || errors.Is(err, validators.ErrInvalidLabel):
+			h.logger.Err(err).Caller().Str("func", "*Handler.InfluxWrite").Msg("passed metric is not valid")
+			http.Error(w, "passed metric is not valid", http.StatusBadRequest)
+			return
+		default:
+			h.logger.Err(err).Caller().Str("func", "*Handler.InfluxWrite").Msg("error occurred during metric update")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
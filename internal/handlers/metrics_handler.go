@@ -4,15 +4,21 @@ import (
 	"encoding/json"
 	"errors"
 	"html/template"
+	"maps"
 	"net/http"
 	"strconv"
+	"strings"
 
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
 	"github.com/MKhiriev/stunning-adventure/internal/store"
 	"github.com/MKhiriev/stunning-adventure/internal/validators"
 	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/go-chi/chi/v5"
 )
 
+// quantileParam параметр запроса значения summary с нужным квантилем: `/value/summary/latency?quantile=0.99`
+const quantileParam = "quantile"
+
 func (h *Handler) BatchUpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	var metricsFromBody []models.Metrics
@@ -24,12 +30,16 @@ func (h *Handler) BatchUpdateMetricJSON(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	h.logger.Info().Str("func", "*Handler.BatchUpdateMetricJSON").Interface("metrics from body", metricsFromBody).Msg("BatchUpdateMetricJSON was called!")
+	h.logger.Info().Str("func", "*Handler.BatchUpdateMetricJSON").Str("agent", r.Header.Get(models.AgentIDHeader)).Interface("metrics from body", metricsFromBody).Msg("BatchUpdateMetricJSON was called!")
+	for i := range metricsFromBody {
+		metricsFromBody[i] = withAgentInstance(r, metricsFromBody[i])
+	}
 
 	// update all values + validation
 	if err := h.metricsService.SaveAll(ctx, metricsFromBody); err != nil {
 		switch {
-		case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType):
+		case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType) || errors.Is(err, validators.ErrInvalidLabel) ||
+			errors.Is(err, validators.ErrInvalidHistogram) || isMergeConflict(err):
 			h.logger.Err(err).Caller().Str("func", "*Handler.BatchUpdateMetricJSON").Msg("passed metric is not valid")
 			http.Error(w, "passed metric is not valid", http.StatusBadRequest)
 			return
@@ -39,6 +49,7 @@ func (h *Handler) BatchUpdateMetricJSON(w http.ResponseWriter, r *http.Request)
 			return
 		}
 	}
+	h.registerAgent(ctx, r, metricsFromBody...)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -54,13 +65,15 @@ func (h *Handler) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	h.logger.Info().Str("func", "*Handler.UpdateMetricJSON").Interface("metric from body", metricFromBody).Msg("UpdateMetricJSON was called!")
+	h.logger.Info().Str("func", "*Handler.UpdateMetricJSON").Str("agent", r.Header.Get(models.AgentIDHeader)).Interface("metric from body", metricFromBody).Msg("UpdateMetricJSON was called!")
+	metricFromBody = withAgentInstance(r, metricFromBody)
 
 	var err error
 	// 3. Update metric's value based on it's type + validation
 	if metricFromBody, err = h.metricsService.Save(ctx, metricFromBody); err != nil {
 		switch {
-		case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType):
+		case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType) || errors.Is(err, validators.ErrInvalidLabel) ||
+			errors.Is(err, validators.ErrInvalidHistogram) || isMergeConflict(err):
 			h.logger.Err(err).Caller().Str("func", "*Handler.UpdateMetricJSON").Any("metric", metricFromBody).Msg("passed metric is not valid")
 			http.Error(w, "passed metric is not valid", http.StatusBadRequest)
 			return
@@ -71,6 +84,8 @@ func (h *Handler) UpdateMetricJSON(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	h.registerAgent(ctx, r, metricFromBody)
+
 	// 4. Set Content type to `application/json`
 	w.Header().Set("Content-Type", "application/json")
 	// 5. marshal in JSON saved metric
@@ -117,6 +132,28 @@ func (h *Handler) GetMetricJSON(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// summary is returned with requested quantiles
+	if foundMetric.MType == models.Summary {
+		qs := make([]float64, 0, len(metric.Quantiles))
+		for _, quantile := range metric.Quantiles {
+			qs = append(qs, quantile.Q)
+		}
+		if foundMetric, err = foundMetric.WithQuantiles(qs...); err != nil {
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricJSON").Any("metric to find", metric).Msg("requested quantiles are not valid")
+			http.Error(w, "requested quantiles are not valid", http.StatusBadRequest)
+			return
+		}
+	}
+
+	// set is returned with cardinality estimate
+	if foundMetric.MType == models.Set {
+		if foundMetric, err = foundMetric.WithCardinality(); err != nil {
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricJSON").Any("metric to find", metric).Msg("error estimating set cardinality")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
 	// 4. Marshal
 	foundMetricJSON, err := json.Marshal(foundMetric)
 	if err != nil {
@@ -135,8 +172,9 @@ func (h *Handler) MetricHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Add("Content-Type", "text/plain")
 
 	metric := models.Metrics{
-		ID:    chi.URLParam(r, "metricName"),
-		MType: chi.URLParam(r, "metricType"),
+		ID:     chi.URLParam(r, "metricName"),
+		MType:  chi.URLParam(r, "metricType"),
+		Labels: labelsFromQuery(r),
 	}
 	metricValue := chi.URLParam(r, "metricValue")
 
@@ -156,19 +194,34 @@ func (h *Handler) MetricHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// create new metric + validate validate metric value
-	metric, err := models.NewMetric(metric.ID, metric.MType, metricValue)
+	labels := metric.Labels
+	var err error
+	if metric.MType == models.Histogram {
+		// single observation is put into buckets from server config
+		metric, err = models.NewHistogramMetric(metric.ID, h.histogramBounds, metricValue)
+	} else {
+		metric, err = models.NewMetric(metric.ID, metric.MType, metricValue)
+	}
 	if err != nil {
 		h.logger.Err(err).Caller().Str("func", "*Handler.MetricHandler").Msg("error during metric creation")
 		w.WriteHeader(http.StatusBadRequest)
 		return
 	}
+	metric.Labels = labels
+	metric = withAgentInstance(r, metric)
 
 	_, err = h.metricsService.Save(ctx, metric)
+	if isMergeConflict(err) {
+		h.logger.Err(err).Caller().Str("func", "*Handler.MetricHandler").Msg("histogram buckets or sketch accuracy differ from stored ones")
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
 	if err != nil {
 		h.logger.Err(err).Caller().Str("func", "*Handler.MetricHandler").Msg("error during saving metric")
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
 		return
 	}
+	h.registerAgent(ctx, r, metric)
 
 	w.WriteHeader(http.StatusOK)
 }
@@ -180,7 +233,7 @@ func (h *Handler) GetMetricValue(w http.ResponseWriter, r *http.Request) {
 	mType := chi.URLParam(r, "metricType")
 
 	// business logic + validation
-	metric, err := h.metricsService.Get(ctx, models.Metrics{ID: id, MType: mType})
+	metric, err := h.metricsService.Get(ctx, models.Metrics{ID: id, MType: mType, Labels: labelsFromQuery(r)})
 
 	if err != nil {
 		switch {
@@ -197,14 +250,22 @@ func (h *Handler) GetMetricValue(w http.ResponseWriter, r *http.Request) {
 
 	// if metric is present
 	h.logger.Info().Caller().Str("func", "*Handler.GetMetricValue").Any("metric", metric).Msg("found metric")
+	value := h.getValueFromMetric(metric)
+	if q := r.URL.Query().Get(quantileParam); q != "" && metric.MType == models.Summary {
+		if value, err = quantileValue(metric, q); err != nil {
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetMetricValue").Str("quantile", q).Msg("requested quantile is not valid")
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+	}
 	w.WriteHeader(http.StatusOK)
-	w.Write([]byte(h.getValueFromMetric(metric)))
+	w.Write([]byte(value))
 }
 
 func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 	ctx := r.Context()
 	// TODO hide all HTML creation logic under new service
-	html, err := template.ParseFiles("web/template/all-metrics.html", "web/template/metrics-list.html")
+	html, err := template.ParseFiles("web/template/all-metrics.html", "web/template/metrics-list.html", "web/template/agents-list.html")
 	if err != nil || html == nil {
 		h.logger.Err(err).Caller().Str("func", "*Handler.GetAllMetrics").Msg("error during parsing html templates")
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -219,20 +280,34 @@ func (h *Handler) GetAllMetrics(w http.ResponseWriter, r *http.Request) {
 	}
 
 	type HTMLMetric struct {
-		ID    string
-		MType string
-		Value string
+		ID     string
+		MType  string
+		Labels string
+		Value  string
 	}
 
 	allHTMLMetrics := make([]HTMLMetric, len(allMetrics))
 	for idx, metric := range allMetrics {
-		allHTMLMetrics[idx] = HTMLMetric{ID: metric.ID, MType: metric.MType, Value: h.getValueFromMetric(metric)}
+		allHTMLMetrics[idx] = HTMLMetric{ID: metric.ID, MType: metric.MType, Labels: metric.LabelsString(), Value: h.getValueFromMetric(metric)}
+	}
+
+	var allAgents []models.AgentInfo
+	if h.agentRegistry != nil {
+		allAgents, err = h.agentRegistry.GetAll(ctx)
+		if err != nil {
+			h.logger.Err(err).Caller().Str("func", "*Handler.GetAllMetrics").Msg("error getting all agents from registry")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
 	w.WriteHeader(http.StatusOK)
 
-	err = html.Execute(w, allHTMLMetrics)
+	err = html.Execute(w, struct {
+		Metrics []HTMLMetric
+		Agents  []models.AgentInfo
+	}{Metrics: allHTMLMetrics, Agents: allAgents})
 	if err != nil {
 		h.logger.Err(err).Caller().Str("func", "*Handler.GetAllMetrics").Msg("error during executing html templates")
 		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
@@ -247,9 +322,74 @@ func (h *Handler) getValueFromMetric(metric models.Metrics) string {
 	if metric.MType == models.Gauge && metric.Value != nil {
 		return strconv.FormatFloat(*metric.Value, 'f', -1, 64)
 	}
+	if metric.MType == models.Histogram && metric.Histogram != nil {
+		return metric.Histogram.String()
+	}
+	if metric.MType == models.Summary && metric.Sketch != nil {
+		return metric.Sketch.String()
+	}
+	if metric.MType == models.Set && metric.Set != nil {
+		return strconv.FormatUint(metric.Set.Estimate(), 10)
+	}
 	return ""
 }
 
+// isMergeConflict новое значение нельзя объединить с сохраненным: отличаются тип, границы корзин или точность скетча.
+// Это ошибка клиента, а не сервера
+func isMergeConflict(err error) bool {
+	return errors.Is(err, models.ErrTypeMismatch) || errors.Is(err, models.ErrHistogramBounds) ||
+		errors.Is(err, sketch.ErrAccuracyMismatch) || errors.Is(err, sketch.ErrPrecisionMismatch)
+}
+
+// quantileValue значение квантиля q summary, пустая строка - если в скетче нет значений
+func quantileValue(metric models.Metrics, q string) (string, error) {
+	parsed, err := strconv.ParseFloat(q, 64)
+	if err != nil {
+		return "", err
+	}
+	if metric, err = metric.WithQuantiles(parsed); err != nil || len(metric.Quantiles) == 0 {
+		return "", err
+	}
+	return strconv.FormatFloat(metric.Quantiles[0].Value, 'f', -1, 64), nil
+}
+
+// withAgentInstance помечает метрику агента, приславшего заголовок X-Agent-ID, меткой instance,
+// чтобы метрики с одинаковыми именами от разных агентов не перезаписывали друг друга
+func withAgentInstance(r *http.Request, metric models.Metrics) models.Metrics {
+	agentID := r.Header.Get(models.AgentIDHeader)
+	if agentID == "" {
+		return metric
+	}
+	if _, ok := metric.Labels[models.InstanceLabel]; ok {
+		return metric
+	}
+
+	labels := maps.Clone(metric.Labels)
+	if labels == nil {
+		labels = make(map[string]string, 1)
+	}
+	labels[models.InstanceLabel] = agentID
+	metric.Labels = labels
+	return metric
+}
+
+// labelsFromQuery собирает метки метрики из параметров запроса с префиксом `label.` (`?label.host=web01&label.region=eu`).
+// Остальные параметры - служебные (quantile, from, ...) и метками не становятся
+func labelsFromQuery(r *http.Request) map[string]string {
+	var labels map[string]string
+	for param, values := range r.URL.Query() {
+		name, ok := strings.CutPrefix(param, models.LabelQueryPrefix)
+		if !ok || len(values) == 0 {
+			continue
+		}
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		labels[name] = values[0]
+	}
+	return labels
+}
+
 func (h *Handler) checkValue(cond bool, ifNotEmptyError error) error {
 	if !cond {
 		h.logger.Error().Str("func", "*Handler.checkValue").Msg("value is not valid")
@@ -1,15 +1,21 @@
 package handlers
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strconv"
+	"strings"
 	"testing"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
 	"github.com/MKhiriev/stunning-adventure/internal/service"
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
 	"github.com/MKhiriev/stunning-adventure/internal/store"
 	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/rs/zerolog"
@@ -80,7 +86,16 @@ func TestMetricHandler(t *testing.T) {
 			},
 		},
 		{
-			name:       "negative test #6 - wrong value type",
+			name:       "positive histogram test #6",
+			route:      "/update/histogram/latency/0.3",
+			httpMethod: http.MethodPost,
+			want: want{
+				code:        http.StatusOK,
+				contentType: "text/plain",
+			},
+		},
+		{
+			name:       "negative test #7 - wrong value type",
 			route:      "/update/otherType/Alloc/wrongValue",
 			httpMethod: http.MethodPost,
 			want: want{
@@ -101,6 +116,59 @@ func TestMetricHandler(t *testing.T) {
 	}
 }
 
+func TestLabelsFromQuery(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	for _, route := range []string{
+		"/update/gauge/Load/1?label.host=web01",
+		// parameters without prefix are not labels and don't create a new series
+		"/update/gauge/Load/2?host=web02&foo=bar",
+	} {
+		res, _ := testRequest(t, ts, http.MethodPost, route)
+		require.Equal(t, http.StatusOK, res.StatusCode, route)
+	}
+
+	res, value := testRequest(t, ts, http.MethodGet, "/value/gauge/Load?label.host=web01")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "1", value)
+	res, value = testRequest(t, ts, http.MethodGet, "/value/gauge/Load?foo=bar")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "2", value)
+}
+
+func TestMetricTypesWithSameName(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	for _, route := range []string{
+		"/update/histogram/x/0.3",
+		"/update/counter/x/2",
+		"/update/gauge/x/1.5",
+		"/update/set/x/alice",
+		"/update/counter/x/3",
+		"/update/histogram/x/0.3",
+	} {
+		res, _ := testRequest(t, ts, http.MethodPost, route)
+		require.Equal(t, http.StatusOK, res.StatusCode, route)
+	}
+
+	for route, want := range map[string]string{
+		"/value/counter/x": "5",
+		"/value/gauge/x":   "1.5",
+		"/value/set/x":     "1",
+	} {
+		res, value := testRequest(t, ts, http.MethodGet, route)
+		assert.Equal(t, http.StatusOK, res.StatusCode, route)
+		assert.Equal(t, want, value, route)
+	}
+	res, value := testRequest(t, ts, http.MethodGet, "/value/histogram/x")
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Contains(t, value, "count=2 ")
+}
+
 func TestGetValueFromMetric(t *testing.T) {
 	h := initHandler()
 	type want struct {
@@ -121,6 +189,18 @@ func TestGetValueFromMetric(t *testing.T) {
 			metric: models.Metrics{ID: "Alloc", MType: models.Gauge, Value: mValue(123.229)},
 			want:   want{result: "123.229"},
 		},
+		{
+			name: "positive histogram value test #3",
+			metric: models.Metrics{ID: "latency", MType: models.Histogram, Histogram: &models.HistogramValue{
+				Bounds: []float64{0.5, 1}, Counts: []uint64{1, 2, 0}, Sum: 1.75, Count: 3,
+			}},
+			want: want{result: "count=3 sum=1.75 buckets=[0.5:1 1:3 +Inf:3]"},
+		},
+		{
+			name:   "positive set value test #4",
+			metric: models.Metrics{ID: "users", MType: models.Set, Set: newSet("alice", "bob", "alice")},
+			want:   want{result: "2"},
+		},
 	}
 
 	for _, test := range tests {
@@ -132,6 +212,329 @@ func TestGetValueFromMetric(t *testing.T) {
 	}
 }
 
+func TestMetricValueFieldByType(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+	}{
+		{name: "counter with delta", body: `{"id":"x","type":"counter","delta":1}`, wantCode: http.StatusOK},
+		{name: "counter with value", body: `{"id":"x","type":"counter","value":1}`, wantCode: http.StatusBadRequest},
+		{name: "gauge with value", body: `{"id":"y","type":"gauge","value":1}`, wantCode: http.StatusOK},
+		{name: "gauge with delta", body: `{"id":"y","type":"gauge","delta":1}`, wantCode: http.StatusBadRequest},
+		{name: "counter is still updated", body: `{"id":"x","type":"counter","delta":2}`, wantCode: http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ts.Client().Post(ts.URL+"/update/", "application/json", strings.NewReader(test.body))
+			require.NoError(t, err)
+			res.Body.Close()
+			assert.Equal(t, test.wantCode, res.StatusCode)
+		})
+	}
+
+	_, value := testRequest(t, ts, http.MethodGet, "/value/counter/x")
+	assert.Equal(t, "3", value)
+}
+
+func TestHistogramMetricJSON(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		body     string
+		wantCode int
+		wantBody string
+	}{
+		{
+			name:     "new histogram",
+			body:     `{"id":"latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,0,1],"sum":2.05,"count":2}}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,0,1],"sum":2.05,"count":2}}`,
+		},
+		{
+			name:     "histogram is merged",
+			body:     `{"id":"latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[0,3,0],"sum":1.5,"count":3}}`,
+			wantCode: http.StatusOK,
+			wantBody: `{"id":"latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,3,1],"sum":3.55,"count":5}}`,
+		},
+		{
+			name:     "other buckets",
+			body:     `{"id":"latency","type":"histogram","histogram":{"bounds":[0.5],"counts":[1,0],"sum":0.2,"count":1}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "count differs from bucket counts",
+			body:     `{"id":"latency","type":"histogram","histogram":{"bounds":[0.1,1],"counts":[1,0,1],"sum":2.05,"count":3}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "unsorted buckets",
+			body:     `{"id":"other","type":"histogram","histogram":{"bounds":[1,0.1],"counts":[1,0,0],"sum":1,"count":1}}`,
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "no histogram",
+			body:     `{"id":"other","type":"histogram","value":1}`,
+			wantCode: http.StatusBadRequest,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			res, err := ts.Client().Post(ts.URL+"/update/", "application/json", strings.NewReader(test.body))
+			require.NoError(t, err)
+			body, err := io.ReadAll(res.Body)
+			res.Body.Close()
+			require.NoError(t, err)
+
+			assert.Equal(t, test.wantCode, res.StatusCode)
+			if test.wantBody != "" {
+				assert.JSONEq(t, test.wantBody, string(body))
+			}
+		})
+	}
+
+	// single values sent via URL are put into default buckets
+	res, _ := testRequest(t, ts, http.MethodPost, "/update/histogram/request_duration/0.3")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	res, _ = testRequest(t, ts, http.MethodPost, "/update/histogram/request_duration/12")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	_, body := testRequest(t, ts, http.MethodGet, "/value/histogram/request_duration")
+	assert.Equal(t, "count=2 sum=12.3 buckets=[0.005:0 0.01:0 0.025:0 0.05:0 0.1:0 0.25:0 0.5:1 1:1 2.5:1 5:1 10:1 +Inf:2]", body)
+}
+
+func TestSummaryMetric(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	// values 1..100 are sent one by one and as a pre-aggregated sketch
+	for i := 1; i <= 50; i++ {
+		res, _ := testRequest(t, ts, http.MethodPost, "/update/summary/latency/"+strconv.Itoa(i))
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	agentSketch := sketch.NewDefaultDDSketch()
+	for i := 51; i <= 100; i++ {
+		require.NoError(t, agentSketch.Add(float64(i)))
+	}
+	body, err := json.Marshal(models.Metrics{ID: "latency", MType: models.Summary, Sketch: agentSketch})
+	require.NoError(t, err)
+	res, err := ts.Client().Post(ts.URL+"/update/", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, value := testRequest(t, ts, http.MethodGet, "/value/summary/latency?quantile=0.5")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	median, err := strconv.ParseFloat(value, 64)
+	require.NoError(t, err)
+	assert.InDelta(t, 50, median, 50*sketch.DefaultRelativeAccuracy)
+
+	res, _ = testRequest(t, ts, http.MethodGet, "/value/summary/latency?quantile=2")
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = ts.Client().Post(ts.URL+"/value/", "application/json", strings.NewReader(`{"id":"latency","type":"summary","quantiles":[{"q":0.99},{"q":1}]}`))
+	require.NoError(t, err)
+	var found models.Metrics
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&found))
+	res.Body.Close()
+	require.Len(t, found.Quantiles, 2)
+	assert.InDelta(t, 99, found.Quantiles[0].Value, 99*sketch.DefaultRelativeAccuracy)
+	assert.Equal(t, models.Quantile{Q: 1, Value: 100}, found.Quantiles[1])
+	assert.Equal(t, uint64(100), found.Sketch.Count())
+
+	// sketches with other accuracy can't be merged
+	otherSketch, err := sketch.NewDDSketch(0.05)
+	require.NoError(t, err)
+	require.NoError(t, otherSketch.Add(1))
+	body, err = json.Marshal(models.Metrics{ID: "latency", MType: models.Summary, Sketch: otherSketch})
+	require.NoError(t, err)
+	res, err = ts.Client().Post(ts.URL+"/update/", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = ts.Client().Post(ts.URL+"/update/", "application/json", strings.NewReader(`{"id":"latency","type":"summary","sketch":"AQ=="}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func TestSetMetric(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	for _, user := range []string{"alice", "bob", "alice"} {
+		res, _ := testRequest(t, ts, http.MethodPost, "/update/set/daily_users/"+user)
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+	// agent sends already aggregated users, bob is counted once
+	body, err := json.Marshal(models.Metrics{ID: "daily_users", MType: models.Set, Set: newSet("bob", "carol")})
+	require.NoError(t, err)
+	res, err := ts.Client().Post(ts.URL+"/update/", "application/json", bytes.NewReader(body))
+	require.NoError(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusOK, res.StatusCode)
+
+	res, value := testRequest(t, ts, http.MethodGet, "/value/set/daily_users")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	assert.Equal(t, "3", value)
+
+	res, err = ts.Client().Post(ts.URL+"/value/", "application/json", strings.NewReader(`{"id":"daily_users","type":"set"}`))
+	require.NoError(t, err)
+	var found models.Metrics
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&found))
+	res.Body.Close()
+	require.NotNil(t, found.Cardinality)
+	assert.Equal(t, uint64(3), *found.Cardinality)
+	assert.Equal(t, newSet("alice", "bob", "carol"), found.Set)
+
+	// sets with other precision can't be merged
+	otherSet, err := sketch.NewHyperLogLog(10)
+	require.NoError(t, err)
+	otherSet.Add("dave")
+	body, err = json.Marshal(models.Metrics{ID: "daily_users", MType: models.Set, Set: otherSet})
+	require.NoError(t, err)
+	res, err = ts.Client().Post(ts.URL+"/updates/", "application/json", bytes.NewReader(append(append([]byte("["), body...), ']')))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	res, err = ts.Client().Post(ts.URL+"/update/", "application/json", strings.NewReader(`{"id":"daily_users","type":"set"}`))
+	require.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, res.StatusCode)
+}
+
+func newSet(values ...string) *sketch.HyperLogLog {
+	s := sketch.NewDefaultHyperLogLog()
+	for _, value := range values {
+		s.Add(value)
+	}
+	return s
+}
+
+func TestWithAgentInstance(t *testing.T) {
+	tests := []struct {
+		name    string
+		agentID string
+		metric  models.Metrics
+		want    map[string]string
+	}{
+		{
+			name:   "no agent header",
+			metric: models.Metrics{ID: "Alloc", MType: models.Gauge, Value: mValue(1)},
+			want:   nil,
+		},
+		{
+			name:    "instance label added",
+			agentID: "web-01",
+			metric:  models.Metrics{ID: "Alloc", MType: models.Gauge, Value: mValue(1), Labels: map[string]string{"dc": "eu-1"}},
+			want:    map[string]string{"dc": "eu-1", models.InstanceLabel: "web-01"},
+		},
+		{
+			name:    "instance label kept",
+			agentID: "web-01",
+			metric:  models.Metrics{ID: "Alloc", MType: models.Gauge, Value: mValue(1), Labels: map[string]string{models.InstanceLabel: "web-02"}},
+			want:    map[string]string{models.InstanceLabel: "web-02"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/updates/", nil)
+			if test.agentID != "" {
+				r.Header.Set(models.AgentIDHeader, test.agentID)
+			}
+			assert.Equal(t, test.want, withAgentInstance(r, test.metric).Labels)
+		})
+	}
+}
+
+func TestMetricHandler_AgentInstance(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	for _, agentID := range []string{"web-01", "web-02"} {
+		req, err := http.NewRequest(http.MethodPost, ts.URL+"/update/gauge/Alloc/"+strings.TrimPrefix(agentID, "web-0"), nil)
+		require.NoError(t, err)
+		req.Header.Set(models.AgentIDHeader, agentID)
+		res, err := ts.Client().Do(req)
+		require.NoError(t, err)
+		res.Body.Close()
+		require.Equal(t, http.StatusOK, res.StatusCode)
+	}
+
+	// series of different agents don't overwrite each other
+	for route, want := range map[string]string{
+		"/value/gauge/Alloc?label.instance=web-01": "1",
+		"/value/gauge/Alloc?label.instance=web-02": "2",
+	} {
+		res, value := testRequest(t, ts, http.MethodGet, route)
+		assert.Equal(t, http.StatusOK, res.StatusCode, route)
+		assert.Equal(t, want, value, route)
+	}
+}
+
+func TestRequestBodyLimit(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	var bomb bytes.Buffer
+	gz := gzip.NewWriter(&bomb)
+	_, err := gz.Write(make([]byte, maxRequestBodySize+1))
+	require.NoError(t, err)
+	require.NoError(t, gz.Close())
+
+	tests := []struct {
+		name    string
+		route   string
+		headers map[string]string
+		body    []byte
+	}{
+		{
+			name:  "large body",
+			route: "/write",
+			body:  bytes.Repeat([]byte("x"), maxRequestBodySize+1),
+		},
+		{
+			name:    "gzip bomb",
+			route:   "/write",
+			headers: map[string]string{"Content-Encoding": "gzip"},
+			body:    bomb.Bytes(),
+		},
+		{
+			name:    "snappy header claims 4 GiB",
+			route:   "/api/v1/write",
+			headers: map[string]string{"Content-Encoding": "snappy"},
+			body:    []byte{0xff, 0xff, 0xff, 0xff, 0x0f},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req, err := http.NewRequest(http.MethodPost, ts.URL+test.route, bytes.NewReader(test.body))
+			require.NoError(t, err)
+			for name, value := range test.headers {
+				req.Header.Set(name, value)
+			}
+
+			resp, err := ts.Client().Do(req)
+			require.NoError(t, err)
+			resp.Body.Close()
+			assert.Equal(t, http.StatusRequestEntityTooLarge, resp.StatusCode)
+		})
+	}
+}
+
 func initHandler() *Handler {
 	logger := zerolog.New(os.Stdout).With().Logger()
 	cfg := &config.ServerConfig{
@@ -145,7 +548,7 @@ func initHandler() *Handler {
 	db := store.DB{}
 
 	validationService := service.NewValidatingMetricsService(&logger)
-	metricsService, _ := service.NewMetricsServiceBuilder(cfg, &logger).
+	metricsService, _ := service.NewMetricsServiceBuilder(context.Background(), cfg, &logger).
 		WithCache(memStorage).
 		WithFile(fileStorage).
 		WithDB(&db).
@@ -153,7 +556,7 @@ func initHandler() *Handler {
 		Build() //, &db, memStorage, cfg, &logger
 	dbPingService, _ := service.NewPingDBService(&db, &logger)
 
-	return NewHandler(metricsService, dbPingService, cfg, &logger)
+	return NewHandler(metricsService, nil, nil, dbPingService, cfg, &logger)
 }
 
 func mDelta(v int) *int64 {
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"net/http"
 	"time"
@@ -12,6 +13,9 @@ import (
 
 const timeout = 10 * time.Second
 
+// maxRequestBodySize ограничение размера тела запроса, в том числе после распаковки gzip
+const maxRequestBodySize = 32 << 20
+
 func (h *Handler) WithLogging(handler http.Handler) http.Handler {
 	logFn := func(w http.ResponseWriter, r *http.Request) {
 		h.logger.Info().
@@ -81,6 +85,35 @@ func CheckHTTPMethod(router *chi.Mux) func(w http.ResponseWriter, r *http.Reques
 	}
 }
 
+// LimitRequestBody ограничивает размер тела запроса maxRequestBodySize
+func LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body != nil {
+			r.Body = http.MaxBytesReader(w, r.Body, maxRequestBodySize)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// readBody читает тело запроса. Если тело больше maxRequestBodySize, отвечает 413, иначе при ошибке - 500
+func (h *Handler) readBody(w http.ResponseWriter, r *http.Request, funcName string) ([]byte, bool) {
+	body, err := io.ReadAll(r.Body)
+	if err == nil {
+		return body, true
+	}
+
+	var maxBytesErr *http.MaxBytesError
+	if errors.As(err, &maxBytesErr) {
+		h.logger.Err(err).Caller().Str("func", funcName).Msg("request body is too large")
+		http.Error(w, http.StatusText(http.StatusRequestEntityTooLarge), http.StatusRequestEntityTooLarge)
+		return nil, false
+	}
+
+	h.logger.Err(err).Caller().Str("func", funcName).Msg("failed to read request body")
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	return nil, false
+}
+
 func WithContext(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx, cancelFunc := context.WithTimeout(r.Context(), timeout)
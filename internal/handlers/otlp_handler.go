@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"errors"
+	"mime"
+	"net/http"
+
+	"github.com/MKhiriev/stunning-adventure/internal/ingest"
+	"github.com/MKhiriev/stunning-adventure/internal/validators"
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+const (
+	otlpProtobufContentType = "application/x-protobuf"
+	otlpJSONContentType     = "application/json"
+)
+
+// OTLPMetrics принимает метрики OpenTelemetry (OTLP/HTTP) в кодировках protobuf и JSON
+func (h *Handler) OTLPMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	contentType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if contentType != otlpProtobufContentType && contentType != otlpJSONContentType {
+		h.logger.Error().Caller().Str("func", "*Handler.OTLPMetrics").Str("content type", contentType).Msg("unsupported content type")
+		http.Error(w, "unsupported content type", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	body, ok := h.readBody(w, r, "*Handler.OTLPMetrics")
+	if !ok {
+		return
+	}
+
+	var metrics []models.Metrics
+	var reservation ingest.OTLPReservation
+	var err error
+	if contentType == otlpProtobufContentType {
+		metrics, reservation, err = h.otlpDecoder.DecodeProtobuf(body)
+	} else {
+		metrics, reservation, err = h.otlpDecoder.DecodeJSON(body)
+	}
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.OTLPMetrics").Msg("invalid OTLP request was passed")
+		http.Error(w, "invalid OTLP request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.logger.Info().Str("func", "*Handler.OTLPMetrics").Int("metrics", len(metrics)).Msg("OTLPMetrics was called!")
+
+	if len(metrics) > 0 {
+		if err = h.metricsService.SaveAll(ctx, metrics); err != nil {
+			// cumulative sums are returned to the previous values, so the increase is resent with the next export
+			h.otlpDecoder.Rollback(reservation)
+			switch {
+			case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType) || errors.Is(err, validators.ErrInvalidLabel):
+				h.logger.Err(err).Caller().Str("func", "*Handler.OTLPMetrics").Msg("passed metric is not valid")
+				http.Error(w, "passed metric is not valid", http.StatusBadRequest)
+				return
+			default:
+				h.logger.Err(err).Caller().Str("func", "*Handler.OTLPMetrics").Msg("error occurred during metric update")
+				http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				return
+			}
+		}
+	}
+
+	// empty ExportMetricsServiceResponse means full success
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(http.StatusOK)
+	if contentType == otlpJSONContentType {
+		w.Write([]byte("{}"))
+	}
+}
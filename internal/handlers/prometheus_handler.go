@@ -0,0 +1,255 @@
+package handlers
+
+import (
+	"bufio"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+const (
+	prometheusContentType  = "text/plain; version=0.0.4; charset=utf-8"
+	openMetricsContentType = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// GetPrometheusMetrics отдаёт все метрики в текстовом формате Prometheus
+// или в формате OpenMetrics, если клиент запросил его в заголовке Accept
+func (h *Handler) GetPrometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	allMetrics, err := h.metricsService.GetAll(ctx)
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetPrometheusMetrics").Msg("error getting all metrics from storage")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	openMetrics := strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text")
+	if openMetrics {
+		w.Header().Set("Content-Type", openMetricsContentType)
+	} else {
+		w.Header().Set("Content-Type", prometheusContentType)
+	}
+	w.WriteHeader(http.StatusOK)
+
+	if err = writePrometheusMetrics(w, allMetrics, openMetrics); err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.GetPrometheusMetrics").Msg("error during writing metrics in exposition format")
+	}
+}
+
+// writePrometheusMetrics пишет метрики в формате Prometheus text exposition (или OpenMetrics).
+// Метрики группируются по имени, для каждой группы пишутся строки HELP и TYPE, метки - в фигурных скобках
+func writePrometheusMetrics(w io.Writer, metrics []models.Metrics, openMetrics bool) error {
+	sorted := make([]models.Metrics, len(metrics))
+	copy(sorted, metrics)
+	sort.Slice(sorted, func(i, j int) bool {
+		left, right := prometheusFamily(sorted[i], openMetrics), prometheusFamily(sorted[j], openMetrics)
+		if left != right {
+			return left < right
+		}
+		if sorted[i].ID != sorted[j].ID {
+			return sorted[i].ID < sorted[j].ID
+		}
+		return sorted[i].LabelsString() < sorted[j].LabelsString()
+	})
+
+	buf := bufio.NewWriter(w)
+	familyTypes := make(map[string]string)
+	for _, metric := range sorted {
+		family := prometheusFamily(metric, openMetrics)
+		sampleName := family
+		if metric.MType == models.Counter && openMetrics {
+			// OpenMetrics: counter family has no `_total` suffix, but its sample has
+			sampleName = family + "_total"
+		}
+
+		var samples []string
+		switch metric.MType {
+		case models.Counter:
+			if metric.Delta == nil {
+				continue
+			}
+			samples = []string{sampleName + formatPrometheusLabels(metric.Labels) + " " + strconv.FormatInt(*metric.Delta, 10)}
+		case models.Gauge:
+			if metric.Value == nil {
+				continue
+			}
+			samples = []string{sampleName + formatPrometheusLabels(metric.Labels) + " " + formatPrometheusFloat(*metric.Value)}
+		case models.Histogram:
+			if metric.Histogram == nil {
+				continue
+			}
+			samples = histogramSamples(family, metric)
+		case models.Summary:
+			if metric.Sketch == nil {
+				continue
+			}
+			samples = summarySamples(family, metric)
+		case models.Set:
+			if metric.Set == nil {
+				continue
+			}
+			samples = []string{sampleName + formatPrometheusLabels(metric.Labels) + " " + strconv.FormatUint(metric.Set.Estimate(), 10)}
+		default:
+			continue
+		}
+
+		familyType, ok := familyTypes[family]
+		// names of different types may collide after sanitizing - keep the first type only
+		if ok && familyType != metric.MType {
+			continue
+		}
+		if !ok {
+			familyTypes[family] = metric.MType
+			buf.WriteString("# HELP " + family + " " + escapeHelp(metric.MType+" metric "+metric.ID) + "\n")
+			buf.WriteString("# TYPE " + family + " " + prometheusType(metric.MType) + "\n")
+		}
+		for _, sample := range samples {
+			buf.WriteString(sample + "\n")
+		}
+	}
+
+	if openMetrics {
+		buf.WriteString("# EOF\n")
+	}
+
+	return buf.Flush()
+}
+
+// histogramSamples строки гистограммы: накопительные корзины `_bucket` с меткой le, `_sum` и `_count`
+func histogramSamples(family string, metric models.Metrics) []string {
+	histogram := metric.Histogram
+	samples := make([]string, 0, len(histogram.Counts)+2)
+
+	labels := make(map[string]string, len(metric.Labels)+1)
+	maps.Copy(labels, metric.Labels)
+	for i, count := range histogram.Cumulative() {
+		labels["le"] = "+Inf"
+		if i < len(histogram.Bounds) {
+			labels["le"] = formatPrometheusFloat(histogram.Bounds[i])
+		}
+		samples = append(samples, family+"_bucket"+formatPrometheusLabels(labels)+" "+strconv.FormatUint(count, 10))
+	}
+
+	samples = append(samples,
+		family+"_sum"+formatPrometheusLabels(metric.Labels)+" "+formatPrometheusFloat(histogram.Sum),
+		family+"_count"+formatPrometheusLabels(metric.Labels)+" "+strconv.FormatUint(histogram.Count, 10),
+	)
+	return samples
+}
+
+// summarySamples строки summary: квантили по умолчанию с меткой quantile, `_sum` и `_count`.
+// У пустого скетча квантили - NaN, как у клиента Prometheus
+func summarySamples(family string, metric models.Metrics) []string {
+	samples := make([]string, 0, len(sketch.DefaultQuantiles)+2)
+
+	labels := make(map[string]string, len(metric.Labels)+1)
+	maps.Copy(labels, metric.Labels)
+	for _, q := range sketch.DefaultQuantiles {
+		value, err := metric.Sketch.Quantile(q)
+		if err != nil {
+			value = math.NaN()
+		}
+		labels["quantile"] = formatPrometheusFloat(q)
+		samples = append(samples, family+formatPrometheusLabels(labels)+" "+formatPrometheusFloat(value))
+	}
+
+	samples = append(samples,
+		family+"_sum"+formatPrometheusLabels(metric.Labels)+" "+formatPrometheusFloat(metric.Sketch.Sum()),
+		family+"_count"+formatPrometheusLabels(metric.Labels)+" "+strconv.FormatUint(metric.Sketch.Count(), 10),
+	)
+	return samples
+}
+
+// prometheusType тип семейства в exposition format. У Prometheus нет типа set,
+// поэтому оценка количества различных значений отдается как gauge
+func prometheusType(MType string) string {
+	if MType == models.Set {
+		return models.Gauge
+	}
+	return MType
+}
+
+func prometheusFamily(metric models.Metrics, openMetrics bool) string {
+	name := sanitizeMetricName(metric.ID)
+	if metric.MType == models.Counter && openMetrics {
+		return strings.TrimSuffix(name, "_total")
+	}
+	return name
+}
+
+// formatPrometheusLabels пишет метки в виде {name="value",...}, отсортированные по имени
+func formatPrometheusLabels(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+
+	sanitized := make(map[string]string, len(labels))
+	for name, value := range labels {
+		sanitized[sanitizeLabelName(name)] = value
+	}
+
+	pairs := make([]string, 0, len(sanitized))
+	for _, name := range slices.Sorted(maps.Keys(sanitized)) {
+		pairs = append(pairs, name+`="`+escapeLabelValue(sanitized[name])+`"`)
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// sanitizeMetricName приводит имя метрики к виду [a-zA-Z_:][a-zA-Z0-9_:]*
+func sanitizeMetricName(name string) string {
+	if name == "" {
+		return "_"
+	}
+
+	var builder strings.Builder
+	for i, char := range name {
+		switch {
+		case char >= 'a' && char <= 'z', char >= 'A' && char <= 'Z', char == '_', char == ':':
+			builder.WriteRune(char)
+		case char >= '0' && char <= '9':
+			if i == 0 {
+				builder.WriteRune('_')
+			}
+			builder.WriteRune(char)
+		default:
+			builder.WriteRune('_')
+		}
+	}
+
+	return builder.String()
+}
+
+// sanitizeLabelName приводит имя метки к виду [a-zA-Z_][a-zA-Z0-9_]*
+func sanitizeLabelName(name string) string {
+	return strings.ReplaceAll(sanitizeMetricName(name), ":", "_")
+}
+
+func escapeLabelValue(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func formatPrometheusFloat(value float64) string {
+	switch {
+	case math.IsInf(value, 1):
+		return "+Inf"
+	case math.IsInf(value, -1):
+		return "-Inf"
+	case math.IsNaN(value):
+		return "NaN"
+	}
+	return strconv.FormatFloat(value, 'g', -1, 64)
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWritePrometheusMetrics(t *testing.T) {
+	metrics := []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+		{ID: "Alloc", MType: models.Gauge, Value: mValue(123.5)},
+		{ID: "1cpu.usage", MType: models.Gauge, Value: mValue(0.25)},
+	}
+
+	tests := []struct {
+		name        string
+		openMetrics bool
+		want        string
+	}{
+		{
+			name: "prometheus text format",
+			want: `# HELP Alloc gauge metric Alloc
+# TYPE Alloc gauge
+Alloc 123.5
+# HELP PollCount counter metric PollCount
+# TYPE PollCount counter
+PollCount 5
+# HELP _1cpu_usage gauge metric 1cpu.usage
+# TYPE _1cpu_usage gauge
+_1cpu_usage 0.25
+`,
+		},
+		{
+			name:        "openmetrics format",
+			openMetrics: true,
+			want: `# HELP Alloc gauge metric Alloc
+# TYPE Alloc gauge
+Alloc 123.5
+# HELP PollCount counter metric PollCount
+# TYPE PollCount counter
+PollCount_total 5
+# HELP _1cpu_usage gauge metric 1cpu.usage
+# TYPE _1cpu_usage gauge
+_1cpu_usage 0.25
+# EOF
+`,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			require.NoError(t, writePrometheusMetrics(&buf, metrics, test.openMetrics))
+			assert.Equal(t, test.want, buf.String())
+		})
+	}
+}
+
+func TestGetPrometheusMetrics(t *testing.T) {
+	h := initHandler()
+	ts := httptest.NewServer(h.Init())
+	defer ts.Close()
+
+	res, _ := testRequest(t, ts, http.MethodPost, "/update/gauge/Alloc/42")
+	res.Body.Close()
+
+	res, body := testRequest(t, ts, http.MethodGet, "/metrics")
+	defer res.Body.Close()
+
+	assert.Equal(t, prometheusContentType, res.Header.Get("Content-Type"))
+	assert.Contains(t, body, "# TYPE Alloc gauge\nAlloc 42\n")
+}
+
+func TestWritePrometheusMetrics_Labels(t *testing.T) {
+	metrics := []models.Metrics{
+		{ID: "Alloc", MType: models.Gauge, Value: mValue(2), Labels: map[string]string{"host": "b"}},
+		{ID: "Alloc", MType: models.Gauge, Value: mValue(1), Labels: map[string]string{"host": "a", "service.name": `say "hi"`}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePrometheusMetrics(&buf, metrics, false))
+	assert.Equal(t, `# HELP Alloc gauge metric Alloc
+# TYPE Alloc gauge
+Alloc{host="a",service_name="say \"hi\""} 1
+Alloc{host="b"} 2
+`, buf.String())
+}
+
+func TestWritePrometheusMetrics_Histogram(t *testing.T) {
+	histogram := models.NewHistogramValue([]float64{0.1, 1})
+	for _, value := range []float64{0.05, 0.5, 0.7, 3} {
+		histogram.Observe(value)
+	}
+	metrics := []models.Metrics{
+		{ID: "request_duration", MType: models.Histogram, Histogram: histogram, Labels: map[string]string{"path": "/update/"}},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePrometheusMetrics(&buf, metrics, false))
+	assert.Equal(t, `# HELP request_duration histogram metric request_duration
+# TYPE request_duration histogram
+request_duration_bucket{le="0.1",path="/update/"} 1
+request_duration_bucket{le="1",path="/update/"} 3
+request_duration_bucket{le="+Inf",path="/update/"} 4
+request_duration_sum{path="/update/"} 4.25
+request_duration_count{path="/update/"} 4
+`, buf.String())
+}
+
+func TestWritePrometheusMetrics_Summary(t *testing.T) {
+	latency := sketch.NewDefaultDDSketch()
+	for _, value := range []float64{1, 2, 3, 4} {
+		require.NoError(t, latency.Add(value))
+	}
+	metrics := []models.Metrics{
+		{ID: "request_duration", MType: models.Summary, Sketch: latency},
+		{ID: "empty", MType: models.Summary, Sketch: sketch.NewDefaultDDSketch()},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePrometheusMetrics(&buf, metrics, false))
+	assert.Equal(t, `# HELP empty summary metric empty
+# TYPE empty summary
+empty{quantile="0.5"} NaN
+empty{quantile="0.9"} NaN
+empty{quantile="0.99"} NaN
+empty_sum 0
+empty_count 0
+# HELP request_duration summary metric request_duration
+# TYPE request_duration summary
+request_duration{quantile="0.5"} 1.993661701417341
+request_duration{quantile="0.9"} 2.9742334234766927
+request_duration{quantile="0.99"} 2.9742334234766927
+request_duration_sum 10
+request_duration_count 4
+`, buf.String())
+}
+
+func TestWritePrometheusMetrics_Set(t *testing.T) {
+	users := sketch.NewDefaultHyperLogLog()
+	for _, user := range []string{"alice", "bob", "alice"} {
+		users.Add(user)
+	}
+	metrics := []models.Metrics{{ID: "daily_users", MType: models.Set, Set: users, Labels: map[string]string{"app": "web"}}}
+
+	var buf bytes.Buffer
+	require.NoError(t, writePrometheusMetrics(&buf, metrics, false))
+	assert.Equal(t, `# HELP daily_users set metric daily_users
+# TYPE daily_users gauge
+daily_users{app="web"} 2
+`, buf.String())
+}
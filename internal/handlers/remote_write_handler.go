@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/MKhiriev/stunning-adventure/internal/ingest"
+	"github.com/MKhiriev/stunning-adventure/internal/validators"
+)
+
+// PrometheusRemoteWrite принимает запросы Prometheus remote_write и сохраняет все серии через MetricsService
+func (h *Handler) PrometheusRemoteWrite(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+
+	body, ok := h.readBody(w, r, "*Handler.PrometheusRemoteWrite")
+	if !ok {
+		return
+	}
+
+	metrics, err := ingest.ParseRemoteWrite(body)
+	if errors.Is(err, ingest.ErrPayloadTooLarge) {
+		h.logger.Err(err).Caller().Str("func", "*Handler.PrometheusRemoteWrite").Msg("remote write request is too large")
+		http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+		return
+	}
+	if err != nil {
+		h.logger.Err(err).Caller().Str("func", "*Handler.PrometheusRemoteWrite").Msg("invalid remote write request was passed")
+		http.Error(w, "invalid remote write request: "+err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	h.logger.Info().Str("func", "*Handler.PrometheusRemoteWrite").Int("series", len(metrics)).Msg("PrometheusRemoteWrite was called!")
+
+	if len(metrics) == 0 {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	if err = h.metricsService.SaveAll(ctx, metrics); err != nil {
+		switch {
+		case errors.Is(err, validators.ErrEmptyID) || errors.Is(err, validators.ErrEmptyType) || errors.Is(err, validators.ErrNoValue) || errors.Is(err, validators.ErrInvalidType) || errors.Is(err, validators.ErrInvalidLabel):
+			h.logger.Err(err).Caller().Str("func", "*Handler.PrometheusRemoteWrite").Msg("passed metric is not valid")
+			http.Error(w, "passed metric is not valid", http.StatusBadRequest)
+			return
+		default:
+			h.logger.Err(err).Caller().Str("func", "*Handler.PrometheusRemoteWrite").Msg("error occurred during metric update")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
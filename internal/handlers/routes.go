@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/ingest"
 	"github.com/MKhiriev/stunning-adventure/internal/service"
 	"github.com/MKhiriev/stunning-adventure/internal/validators"
+	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/rs/zerolog"
@@ -12,35 +14,59 @@ import (
 type Handler struct {
 	logger          *zerolog.Logger
 	metricsService  service.MetricsService
+	historyService  service.MetricsHistoryService
+	agentRegistry   service.AgentRegistryService
 	dbPingService   service.PingService
 	metricValidator validators.Validator
+	otlpDecoder     *ingest.OTLPDecoder
 	hashKey         string
+	histogramBounds []float64 // buckets for histogram values sent one by one via URL
 }
 
-func NewHandler(metricsService service.MetricsService, dbPingService service.PingService, cfg *config.ServerConfig, logger *zerolog.Logger) *Handler {
+func NewHandler(metricsService service.MetricsService, historyService service.MetricsHistoryService, agentRegistry service.AgentRegistryService, dbPingService service.PingService, cfg *config.ServerConfig, logger *zerolog.Logger) *Handler {
+	// bounds are checked in cfg.Validate, fall back to defaults just in case
+	histogramBounds, err := models.ParseHistogramBounds(cfg.HistogramBuckets)
+	if err != nil {
+		logger.Err(err).Str("func", "NewHandler").Msg("invalid histogram buckets, default ones are used")
+		histogramBounds = models.DefaultHistogramBounds
+	}
+
 	return &Handler{
 		logger:          logger,
 		metricsService:  metricsService,
+		historyService:  historyService,
+		agentRegistry:   agentRegistry,
 		dbPingService:   dbPingService,
 		metricValidator: validators.NewMetricsValidator(),
+		otlpDecoder:     ingest.NewOTLPDecoder(),
 		hashKey:         cfg.HashKey,
+		histogramBounds: histogramBounds,
 	}
 }
 
 func (h *Handler) Init() *chi.Mux {
 	router := chi.NewRouter()
-	router.Use(middleware.Recoverer, h.WithLogging, WithContext)
+	router.Use(middleware.Recoverer, LimitRequestBody, h.WithLogging, WithContext)
 	router.Group(func(r chi.Router) {
 		r.Use(GZip, h.WithHashing)
 		r.Post("/updates/", h.BatchUpdateMetricJSON)
 		r.Post("/update/", h.UpdateMetricJSON)
+		r.Post("/write", h.InfluxWrite)
+		r.Post("/v1/metrics", h.OTLPMetrics)
 		r.Post("/value/", h.GetMetricJSON)
+		r.Post("/delete/", h.BatchDeleteMetricJSON)
+		r.Post("/purge/", h.PurgeMetrics)
+		r.Get("/history/{metricType}/{metricName}", h.GetMetricHistory)
 		r.Get("/", h.GetAllMetrics)
 	})
 
 	router.Group(func(r chi.Router) {
 		r.Post("/update/{metricType}/{metricName}/{metricValue}", h.MetricHandler)
 		r.Get("/value/{metricType}/{metricName}", h.GetMetricValue)
+		r.Delete("/value/{metricType}/{metricName}", h.DeleteMetric)
+		r.Get("/metrics", h.GetPrometheusMetrics)
+		r.Get("/agents", h.GetAgents)
+		r.Post("/api/v1/write", h.PrometheusRemoteWrite)
 	})
 
 	router.Group(func(r chi.Router) {
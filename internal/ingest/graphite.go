@@ -0,0 +1,41 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+// ParseGraphiteLine разбирает строку Graphite plaintext протокола `path value [timestamp]` в gauge.
+// Теги Graphite (`path;tag=value`) становятся метками, временная метка проверяется, но не используется
+func ParseGraphiteLine(line string) (models.Metrics, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 2 || len(fields) > 3 {
+		return models.Metrics{}, fmt.Errorf("%w: expected `path value [timestamp]`", ErrMalformedPayload)
+	}
+
+	value, err := strconv.ParseFloat(fields[1], 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return models.Metrics{}, fmt.Errorf("%w: invalid value %q", ErrMalformedPayload, fields[1])
+	}
+	if len(fields) == 3 {
+		if _, err = strconv.ParseFloat(fields[2], 64); err != nil {
+			return models.Metrics{}, fmt.Errorf("%w: invalid timestamp %q", ErrMalformedPayload, fields[2])
+		}
+	}
+
+	pathAndTags := strings.Split(fields[0], ";")
+	tags := make(map[string]string, len(pathAndTags)-1)
+	for _, tag := range pathAndTags[1:] {
+		key, tagValue, ok := strings.Cut(tag, "=")
+		if !ok || key == "" || tagValue == "" {
+			return models.Metrics{}, fmt.Errorf("%w: invalid tag %q", ErrMalformedPayload, tag)
+		}
+		tags[key] = tagValue
+	}
+
+	return gauge(pathAndTags[0], tags, value), nil
+}
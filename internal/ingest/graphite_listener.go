@@ -0,0 +1,222 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/service"
+	"github.com/MKhiriev/stunning-adventure/internal/validators"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// graphiteFlushInterval как часто принятые значения сохраняются в MetricsService
+	graphiteFlushInterval = time.Second
+
+	// счётчик отклонённых строк, причина - в метке reason
+	graphiteRejectedLines   = "graphite_rejected_lines"
+	graphiteRejectMalformed = "malformed"
+	graphiteRejectInvalid   = "invalid"
+)
+
+var ErrGraphiteWithHashKey = errors.New("graphite plaintext protocol can't be signed: listener is disabled when hash key is configured")
+
+// GraphiteListener принимает метрики по TCP в формате Graphite plaintext и сохраняет их как gauge.
+// Протокол не поддерживает подпись, поэтому слушатель работает только без ключа хэширования (как и HTTP API без ключа).
+// Количество отклонённых строк сохраняется в counter `graphite_rejected_lines` с меткой reason
+type GraphiteListener struct {
+	address        string
+	hashKey        string
+	validator      validators.Validator
+	metricsService service.MetricsService
+	pending        map[string]models.Metrics
+	rejected       map[string]int64
+	mu             *sync.Mutex
+	log            *zerolog.Logger
+
+	conns   map[net.Conn]struct{} // open TCP connections, closed on shutdown
+	closed  bool                  // connections accepted after shutdown are closed at once
+	connsMu *sync.Mutex
+}
+
+func NewGraphiteListener(metricsService service.MetricsService, cfg *config.ServerConfig, log *zerolog.Logger) *GraphiteListener {
+	return &GraphiteListener{
+		address:        cfg.GraphiteAddress,
+		hashKey:        cfg.HashKey,
+		validator:      validators.NewMetricsValidator(),
+		metricsService: metricsService,
+		pending:        make(map[string]models.Metrics),
+		rejected:       make(map[string]int64),
+		mu:             &sync.Mutex{},
+		log:            log,
+		conns:          make(map[net.Conn]struct{}),
+		connsMu:        &sync.Mutex{},
+	}
+}
+
+// ListenAndServe слушает адрес до отмены контекста. После отмены соединения закрываются,
+// и принятые значения сохраняются последний раз
+func (l *GraphiteListener) ListenAndServe(ctx context.Context) error {
+	if l.hashKey != "" {
+		l.log.Error().Str("func", "*GraphiteListener.ListenAndServe").Msg("graphite listener is not started: hash key is configured")
+		return ErrGraphiteWithHashKey
+	}
+
+	listener, err := net.Listen("tcp", l.address)
+	if err != nil {
+		l.log.Err(err).Str("func", "*GraphiteListener.ListenAndServe").Str("address", l.address).Msg("error listening TCP")
+		return err
+	}
+	defer listener.Close()
+
+	l.log.Info().Str("func", "*GraphiteListener.ListenAndServe").Str("address", l.address).Msg("Graphite listener started")
+	serving := &sync.WaitGroup{}
+	serving.Add(1)
+	go func() {
+		defer serving.Done()
+		l.serve(ctx, listener, serving)
+	}()
+
+	ticker := time.NewTicker(graphiteFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.log.Info().Str("func", "*GraphiteListener.ListenAndServe").Msg("Graphite listener is stopping")
+			// lines read before closing are saved with the last flush
+			listener.Close()
+			l.closeConnections()
+			serving.Wait()
+			l.flush(context.WithoutCancel(ctx))
+			return nil
+		case <-ticker.C:
+			l.flush(ctx)
+		}
+	}
+}
+
+func (l *GraphiteListener) serve(ctx context.Context, listener net.Listener, serving *sync.WaitGroup) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				l.log.Err(err).Str("func", "*GraphiteListener.serve").Msg("error accepting TCP connection")
+			}
+			return
+		}
+
+		l.connsMu.Lock()
+		if l.closed {
+			l.connsMu.Unlock()
+			conn.Close()
+			continue
+		}
+		l.conns[conn] = struct{}{}
+		l.connsMu.Unlock()
+
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			defer l.closeConnection(conn)
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				l.handleLine(ctx, scanner.Text())
+			}
+		}()
+	}
+}
+
+func (l *GraphiteListener) closeConnection(conn net.Conn) {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+
+	conn.Close()
+	delete(l.conns, conn)
+}
+
+// closeConnections закрывает открытые TCP-соединения, их горутины завершаются на ошибке чтения
+func (l *GraphiteListener) closeConnections() {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+// handleLine разбирает и запоминает одну строку. Паника на строке не должна останавливать прием остальных
+func (l *GraphiteListener) handleLine(ctx context.Context, line string) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.log.Error().Str("func", "*GraphiteListener.handleLine").Str("line", line).Any("panic", r).Msg("panic during handling Graphite line")
+		}
+	}()
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	metric, err := ParseGraphiteLine(line)
+	if err != nil {
+		l.log.Err(err).Str("func", "*GraphiteListener.handleLine").Str("line", line).Msg("malformed Graphite line was rejected")
+		l.reject(graphiteRejectMalformed)
+		return
+	}
+
+	if err = l.validator.Validate(ctx, metric); err != nil {
+		l.log.Err(err).Str("func", "*GraphiteListener.handleLine").Str("line", line).Msg("invalid Graphite metric was rejected")
+		l.reject(graphiteRejectInvalid)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	// gauge: only the last value in flush interval matters
+	l.pending[metric.Key()] = metric
+}
+
+func (l *GraphiteListener) reject(reason string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.rejected[reason]++
+}
+
+func (l *GraphiteListener) flush(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.log.Error().Str("func", "*GraphiteListener.flush").Any("panic", r).Msg("panic during saving Graphite metrics")
+		}
+	}()
+
+	l.mu.Lock()
+	metrics := make([]models.Metrics, 0, len(l.pending)+len(l.rejected))
+	for _, metric := range l.pending {
+		metrics = append(metrics, metric)
+	}
+	for reason, count := range l.rejected {
+		metrics = append(metrics, counter(graphiteRejectedLines, map[string]string{"reason": reason}, count))
+	}
+	l.pending = make(map[string]models.Metrics)
+	l.rejected = make(map[string]int64)
+	l.mu.Unlock()
+
+	if len(metrics) == 0 {
+		return
+	}
+
+	if err := l.metricsService.SaveAll(ctx, metrics); err != nil {
+		l.log.Err(err).Str("func", "*GraphiteListener.flush").Int("metrics", len(metrics)).Msg("error during saving Graphite metrics")
+		return
+	}
+
+	l.log.Debug().Str("func", "*GraphiteListener.flush").Int("metrics", len(metrics)).Msg("Graphite metrics are saved")
+}
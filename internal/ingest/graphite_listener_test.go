@@ -0,0 +1,67 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGraphiteListener_Shutdown(t *testing.T) {
+	logger := zerolog.Nop()
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := probe.Addr().String()
+	require.NoError(t, probe.Close())
+
+	metricsService := &savingService{}
+	listener := NewGraphiteListener(metricsService, &config.ServerConfig{GraphiteAddress: address}, &logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- listener.ListenAndServe(ctx) }()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", address)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer conn.Close()
+
+	// the first flush panics and must not stop the listener
+	_, err = conn.Write([]byte("lost 1\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		metricsService.mu.Lock()
+		defer metricsService.mu.Unlock()
+		return metricsService.panicked
+	}, 3*time.Second, 10*time.Millisecond)
+
+	_, err = conn.Write([]byte("servers.web01.cpu 42.5\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		listener.mu.Lock()
+		defer listener.mu.Unlock()
+		return len(listener.pending) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// open connection doesn't block shutdown
+	cancel()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("listener didn't stop")
+	}
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	metricsService.mu.Lock()
+	defer metricsService.mu.Unlock()
+	require.Len(t, metricsService.saved, 1)
+	assert.Equal(t, 42.5, *metricsService.saved[0].Value)
+}
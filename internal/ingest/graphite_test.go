@@ -0,0 +1,43 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseGraphiteLine(t *testing.T) {
+	tests := []struct {
+		name       string
+		line       string
+		wantID     string
+		wantLabels map[string]string
+		want       float64
+		wantErr    bool
+	}{
+		{name: "with timestamp", line: "servers.web01.cpu 42.5 1700000000", wantID: "servers.web01.cpu", want: 42.5},
+		{name: "without timestamp", line: "servers.web01.load 3", wantID: "servers.web01.load", want: 3},
+		{name: "with tags", line: "disk.used;mount=/;host=web01 80 1700000000", wantID: "disk.used", wantLabels: map[string]string{"host": "web01", "mount": "/"}, want: 80},
+		{name: "no value", line: "servers.web01.cpu", wantErr: true},
+		{name: "invalid value", line: "servers.web01.cpu abc 1700000000", wantErr: true},
+		{name: "nan value", line: "servers.web01.cpu nan 1700000000", wantErr: true},
+		{name: "invalid timestamp", line: "servers.web01.cpu 1 now", wantErr: true},
+		{name: "invalid tag", line: "disk.used;mount 80", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metric, err := ParseGraphiteLine(test.line)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrMalformedPayload)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.wantID, metric.ID)
+			assert.Equal(t, test.wantLabels, metric.Labels)
+			assert.Equal(t, models.Gauge, metric.MType)
+			assert.Equal(t, test.want, *metric.Value)
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+// influxDefaultField поле, которое не добавляется к имени метрики
+const influxDefaultField = "value"
+
+// ParseLineProtocol разбирает данные в формате InfluxDB line protocol:
+// `measurement[,tag=value...] field=value[,field=value...] [timestamp]`.
+// Целочисленные поля (`10i`, `10u`) становятся counter, дробные - gauge, строковые и логические поля пропускаются.
+// Имя метрики - `measurement_field` (или просто `measurement` для поля `value`), теги становятся метками.
+// Временная метка не используется: сервер хранит текущие значения
+func ParseLineProtocol(data []byte) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+	for number, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		lineMetrics, err := parseInfluxLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", number+1, err)
+		}
+		metrics = append(metrics, lineMetrics...)
+	}
+
+	return metrics, nil
+}
+
+func parseInfluxLine(line string) ([]models.Metrics, error) {
+	sections := splitUnescaped(line, ' ')
+	if len(sections) < 2 || len(sections) > 3 {
+		return nil, fmt.Errorf("%w: expected `measurement[,tags] fields [timestamp]`", ErrMalformedPayload)
+	}
+	if len(sections) == 3 {
+		if _, err := strconv.ParseInt(sections[2], 10, 64); err != nil {
+			return nil, fmt.Errorf("%w: invalid timestamp %q", ErrMalformedPayload, sections[2])
+		}
+	}
+
+	// measurement and tags
+	seriesParts := splitUnescaped(sections[0], ',')
+	measurement := unescapeInflux(seriesParts[0])
+	if measurement == "" {
+		return nil, ErrNoMetricName
+	}
+	tags := make(map[string]string, len(seriesParts)-1)
+	for _, tag := range seriesParts[1:] {
+		keyAndValue := splitUnescaped(tag, '=')
+		if len(keyAndValue) != 2 || keyAndValue[0] == "" || keyAndValue[1] == "" {
+			return nil, fmt.Errorf("%w: invalid tag %q", ErrMalformedPayload, tag)
+		}
+		tags[unescapeInflux(keyAndValue[0])] = unescapeInflux(keyAndValue[1])
+	}
+
+	// fields
+	var metrics []models.Metrics
+	for _, field := range splitUnescaped(sections[1], ',') {
+		key, value, ok := cutUnescaped(field, '=')
+		if !ok || key == "" || value == "" {
+			return nil, fmt.Errorf("%w: invalid field %q", ErrMalformedPayload, field)
+		}
+
+		name := measurement
+		if key = unescapeInflux(key); key != influxDefaultField {
+			name += "_" + key
+		}
+
+		switch {
+		case strings.HasPrefix(value, `"`):
+			// string field
+			if len(value) < 2 || !strings.HasSuffix(value, `"`) {
+				return nil, fmt.Errorf("%w: unterminated string field %q", ErrMalformedPayload, field)
+			}
+		case isInfluxBool(value):
+		case strings.HasSuffix(value, "i"), strings.HasSuffix(value, "u"):
+			delta, err := strconv.ParseInt(value[:len(value)-1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%w: invalid integer field %q", ErrMalformedPayload, field)
+			}
+			metrics = append(metrics, counter(name, tags, delta))
+		default:
+			gaugeValue, err := strconv.ParseFloat(value, 64)
+			if err != nil || math.IsNaN(gaugeValue) || math.IsInf(gaugeValue, 0) {
+				return nil, fmt.Errorf("%w: invalid float field %q", ErrMalformedPayload, field)
+			}
+			metrics = append(metrics, gauge(name, tags, gaugeValue))
+		}
+	}
+
+	return metrics, nil
+}
+
+// splitUnescaped делит строку по разделителю, пропуская экранированные `\` символы и строки в кавычках
+func splitUnescaped(s string, separator byte) []string {
+	var parts []string
+	start := 0
+	quoted := false
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\\':
+			i++
+		case s[i] == '"':
+			quoted = !quoted
+		case s[i] == separator && !quoted:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// cutUnescaped делит строку по первому неэкранированному разделителю
+func cutUnescaped(s string, separator byte) (string, string, bool) {
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case separator:
+			return s[:i], s[i+1:], true
+		}
+	}
+	return s, "", false
+}
+
+func unescapeInflux(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	return strings.NewReplacer(`\,`, ",", `\ `, " ", `\=`, "=", `\\`, `\`).Replace(s)
+}
+
+func isInfluxBool(value string) bool {
+	switch value {
+	case "t", "T", "true", "True", "TRUE", "f", "F", "false", "False", "FALSE":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,61 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLineProtocol(t *testing.T) {
+	data := []byte(`# comment
+cpu,host=server01,region=us-west usage_idle=92.5,requests=15i 1434055562000000000
+mem value=1024.5
+disk\ io,path=/var\,log reads=3u,status="ok, fine",healthy=true
+
+`)
+
+	metrics, err := ParseLineProtocol(data)
+	require.NoError(t, err)
+	require.Len(t, metrics, 4)
+
+	assert.Equal(t, "cpu_usage_idle", metrics[0].ID)
+	assert.Equal(t, map[string]string{"host": "server01", "region": "us-west"}, metrics[0].Labels)
+	assert.Equal(t, models.Gauge, metrics[0].MType)
+	assert.Equal(t, 92.5, *metrics[0].Value)
+
+	assert.Equal(t, "cpu_requests", metrics[1].ID)
+	assert.Equal(t, map[string]string{"host": "server01", "region": "us-west"}, metrics[1].Labels)
+	assert.Equal(t, models.Counter, metrics[1].MType)
+	assert.Equal(t, int64(15), *metrics[1].Delta)
+
+	assert.Equal(t, "mem", metrics[2].ID)
+	assert.Nil(t, metrics[2].Labels)
+	assert.Equal(t, 1024.5, *metrics[2].Value)
+
+	assert.Equal(t, "disk io_reads", metrics[3].ID)
+	assert.Equal(t, map[string]string{"path": "/var,log"}, metrics[3].Labels)
+	assert.Equal(t, int64(3), *metrics[3].Delta)
+}
+
+func TestParseLineProtocol_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		line string
+	}{
+		{name: "no fields", line: "cpu"},
+		{name: "invalid tag", line: "cpu,host usage=1"},
+		{name: "invalid field", line: "cpu usage"},
+		{name: "invalid integer", line: "cpu usage=1.5i"},
+		{name: "invalid float", line: "cpu usage=abc"},
+		{name: "invalid timestamp", line: "cpu usage=1 yesterday"},
+		{name: "unterminated string", line: `cpu status="ok`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := ParseLineProtocol([]byte(test.line))
+			assert.ErrorIs(t, err, ErrMalformedPayload)
+		})
+	}
+}
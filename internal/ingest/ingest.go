@@ -0,0 +1,41 @@
+// Package ingest переводит метрики из сторонних протоколов (Prometheus remote write, StatsD,
+// InfluxDB line protocol, Graphite, OTLP) в models.Metrics
+package ingest
+
+import (
+	"errors"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+var (
+	ErrMalformedPayload = errors.New("malformed payload")
+	ErrNoMetricName     = errors.New("metric name is empty")
+	ErrPayloadTooLarge  = errors.New("payload is too large")
+)
+
+func gauge(name string, labels map[string]string, value float64) models.Metrics {
+	return models.Metrics{
+		ID:     name,
+		MType:  models.Gauge,
+		Value:  &value,
+		Labels: nonEmptyLabels(labels),
+	}
+}
+
+func counter(name string, labels map[string]string, delta int64) models.Metrics {
+	return models.Metrics{
+		ID:     name,
+		MType:  models.Counter,
+		Delta:  &delta,
+		Labels: nonEmptyLabels(labels),
+	}
+}
+
+// nonEmptyLabels возвращает nil вместо пустых меток, чтобы метрика без меток выглядела как раньше
+func nonEmptyLabels(labels map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return nil
+	}
+	return labels
+}
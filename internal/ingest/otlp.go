@@ -0,0 +1,586 @@
+package ingest
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+const (
+	otlpTemporalityDelta      = 1
+	otlpTemporalityCumulative = 2
+)
+
+const (
+	// otlpSeriesTTL серия накопительного Sum забывается, если не приходила дольше этого времени
+	otlpSeriesTTL = time.Hour
+	// maxOTLPSeries при превышении забываются серии, приходившие давнее остальных
+	maxOTLPSeries = 100_000
+	// otlpEvictInterval как часто искать устаревшие серии
+	otlpEvictInterval = time.Minute
+)
+
+// номера полей из opentelemetry/proto/metrics/v1/metrics.proto и common/v1/common.proto
+const (
+	exportRequestResourceMetrics = 1
+
+	resourceMetricsResource     = 1
+	resourceMetricsScopeMetrics = 2
+	resourceAttributes          = 1
+	scopeMetricsMetrics         = 2
+
+	metricName  = 1
+	metricGauge = 5
+	metricSum   = 7
+
+	gaugeDataPoints        = 1
+	sumDataPoints          = 1
+	sumAggregationTemporal = 2
+	sumIsMonotonic         = 3
+
+	dataPointStartTime  = 2
+	dataPointAsDouble   = 4
+	dataPointAsInt      = 6
+	dataPointAttributes = 7
+
+	keyValueKey   = 1
+	keyValueValue = 2
+
+	anyValueString = 1
+	anyValueBool   = 2
+	anyValueInt    = 3
+	anyValueDouble = 4
+)
+
+// otlpPoint значение NumberDataPoint вместе с описанием метрики, к которой оно относится
+type otlpPoint struct {
+	name        string
+	labels      map[string]string
+	monotonic   bool // monotonic Sum - counter, everything else - gauge
+	temporality int
+	start       uint64 // StartTimeUnixNano, 0 if unknown
+	value       float64
+	isInt       bool
+	intValue    int64
+}
+
+// OTLPDecoder переводит OTLP ExportMetricsServiceRequest (JSON или protobuf) в метрики.
+// Монотонные Sum становятся counter, Gauge и немонотонные Sum - gauge.
+// Атрибуты ресурса и точки становятся метками метрики.
+// Для накопительных (cumulative) Sum декодер помнит последнее значение серии и отправляет разницу.
+// Первая точка неизвестной серии только запоминается, если по времени начала (StartTimeUnixNano) нельзя
+// понять, что серия началась после того, как декодер перестал о ней помнить: иначе после перезапуска
+// сервера накопленная сумма сложилась бы со счетчиком повторно
+type OTLPDecoder struct {
+	previous map[string]otlpSeries
+	// forgotten серии, начавшиеся не позже этого момента, могли быть учтены раньше
+	forgotten time.Time
+	evicted   time.Time
+	mu        *sync.Mutex
+	now       func() time.Time
+}
+
+// otlpSeries последнее значение накопительного Sum
+type otlpSeries struct {
+	value float64
+	start uint64 // StartTimeUnixNano, 0 if unknown
+	seen  time.Time
+}
+
+// OTLPReservation значения накопительных Sum, которые декодер запомнил при разборе запроса.
+// Если метрики запроса не удалось сохранить, их нужно вернуть через Rollback
+type OTLPReservation struct {
+	previous map[string]*otlpSeries // nil - series had no value before
+	current  map[string]otlpSeries
+}
+
+func NewOTLPDecoder() *OTLPDecoder {
+	return &OTLPDecoder{
+		previous:  make(map[string]otlpSeries),
+		forgotten: time.Now(),
+		mu:        &sync.Mutex{},
+		now:       time.Now,
+	}
+}
+
+// Rollback возвращает значения накопительных Sum, которые были до запроса, чтобы прирост не потерялся
+// и попал в разницу следующего экспорта. Серии, уже обновленные другим запросом, не меняются
+func (d *OTLPDecoder) Rollback(reservation OTLPReservation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for key, previous := range reservation.previous {
+		if current, ok := d.previous[key]; !ok || current != reservation.current[key] {
+			continue
+		}
+		if previous == nil {
+			delete(d.previous, key)
+			continue
+		}
+		d.previous[key] = *previous
+	}
+}
+
+// evict забывает серии, которые не приходили дольше otlpSeriesTTL, и самые старые сверх maxOTLPSeries
+func (d *OTLPDecoder) evict(now time.Time) {
+	if now.Sub(d.evicted) < otlpEvictInterval && len(d.previous) <= maxOTLPSeries {
+		return
+	}
+	d.evicted = now
+
+	for key, series := range d.previous {
+		if now.Sub(series.seen) > otlpSeriesTTL {
+			d.forget(key)
+		}
+	}
+	if len(d.previous) <= maxOTLPSeries {
+		return
+	}
+
+	keys := make([]string, 0, len(d.previous))
+	for key := range d.previous {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b string) int {
+		return d.previous[a].seen.Compare(d.previous[b].seen)
+	})
+	for _, key := range keys[:len(keys)-maxOTLPSeries] {
+		d.forget(key)
+	}
+}
+
+func (d *OTLPDecoder) forget(key string) {
+	if seen := d.previous[key].seen; seen.After(d.forgotten) {
+		d.forgotten = seen
+	}
+	delete(d.previous, key)
+}
+
+func (d *OTLPDecoder) DecodeJSON(body []byte) ([]models.Metrics, OTLPReservation, error) {
+	var request otlpJSONRequest
+	if err := json.Unmarshal(body, &request); err != nil {
+		return nil, OTLPReservation{}, fmt.Errorf("%w: %w", ErrMalformedPayload, err)
+	}
+
+	var points []otlpPoint
+	for _, resourceMetrics := range request.ResourceMetrics {
+		resourceLabels := resourceMetrics.Resource.Attributes.labels()
+		for _, scopeMetrics := range resourceMetrics.ScopeMetrics {
+			for _, metric := range scopeMetrics.Metrics {
+				if metric.Name == "" {
+					return nil, OTLPReservation{}, ErrNoMetricName
+				}
+
+				var dataPoints []otlpJSONDataPoint
+				point := otlpPoint{name: metric.Name}
+				switch {
+				case metric.Sum != nil:
+					dataPoints = metric.Sum.DataPoints
+					point.monotonic = metric.Sum.IsMonotonic
+					point.temporality = int(metric.Sum.AggregationTemporality)
+				case metric.Gauge != nil:
+					dataPoints = metric.Gauge.DataPoints
+				default:
+					// histograms and summaries are not supported
+					continue
+				}
+
+				for _, dataPoint := range dataPoints {
+					point.labels = mergeLabels(resourceLabels, dataPoint.Attributes.labels())
+					point.start = uint64(dataPoint.StartTimeUnixNano)
+					switch {
+					case dataPoint.AsInt != nil:
+						point.isInt, point.intValue, point.value = true, int64(*dataPoint.AsInt), float64(*dataPoint.AsInt)
+					case dataPoint.AsDouble != nil:
+						point.isInt, point.value = false, float64(*dataPoint.AsDouble)
+					default:
+						continue
+					}
+					points = append(points, point)
+				}
+			}
+		}
+	}
+
+	metrics, reservation := d.convert(points)
+	return metrics, reservation, nil
+}
+
+func (d *OTLPDecoder) DecodeProtobuf(body []byte) ([]models.Metrics, OTLPReservation, error) {
+	var points []otlpPoint
+	err := forEachProtoField(body, func(field protoField) error {
+		if field.num != exportRequestResourceMetrics {
+			return nil
+		}
+		resourcePoints, err := parseResourceMetrics(field.bytes)
+		points = append(points, resourcePoints...)
+		return err
+	})
+	if err != nil {
+		return nil, OTLPReservation{}, err
+	}
+
+	metrics, reservation := d.convert(points)
+	return metrics, reservation, nil
+}
+
+func (d *OTLPDecoder) convert(points []otlpPoint) ([]models.Metrics, OTLPReservation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	now := d.now()
+	metrics := make([]models.Metrics, 0, len(points))
+	reservation := OTLPReservation{
+		previous: make(map[string]*otlpSeries),
+		current:  make(map[string]otlpSeries),
+	}
+	for _, point := range points {
+		if !point.isInt && (math.IsNaN(point.value) || math.IsInf(point.value, 0)) {
+			continue
+		}
+
+		if !point.monotonic {
+			metrics = append(metrics, gauge(point.name, point.labels, point.value))
+			continue
+		}
+
+		if point.temporality != otlpTemporalityCumulative {
+			metrics = append(metrics, counter(point.name, point.labels, roundCounter(point)))
+			continue
+		}
+
+		// cumulative sum: send only increase since the previous export.
+		// The new value is remembered right away, so overlapping exports don't count the same increase twice
+		key := models.MetricKey(point.name, point.labels)
+		previous, ok := d.previous[key]
+		if _, reserved := reservation.previous[key]; !reserved {
+			if ok {
+				saved := previous
+				reservation.previous[key] = &saved
+			} else {
+				reservation.previous[key] = nil
+			}
+		}
+		current := otlpSeries{value: point.value, start: point.start, seen: now}
+		d.previous[key] = current
+		reservation.current[key] = current
+
+		delta := cumulativeDelta(point, previous, ok, d.forgotten)
+		if delta == 0 {
+			continue
+		}
+		metrics = append(metrics, counter(point.name, point.labels, delta))
+	}
+	d.evict(now)
+
+	return metrics, reservation
+}
+
+// cumulativeDelta прирост накопительного Sum относительно предыдущего значения серии
+func cumulativeDelta(point otlpPoint, previous otlpSeries, ok bool, forgotten time.Time) int64 {
+	if !ok {
+		// unknown series is counted only if it started after the decoder forgot about it
+		if point.start > 0 && point.start > uint64(forgotten.UnixNano()) {
+			return roundCounter(point)
+		}
+		return 0
+	}
+
+	restarted := point.start > 0 && previous.start > 0 && point.start != previous.start
+	if restarted || point.value < previous.value {
+		return roundCounter(point)
+	}
+	return roundCounter(point) - int64(math.Round(previous.value))
+}
+
+func roundCounter(point otlpPoint) int64 {
+	if point.isInt {
+		return point.intValue
+	}
+	return int64(math.Round(point.value))
+}
+
+func mergeLabels(resource, point map[string]string) map[string]string {
+	labels := make(map[string]string, len(resource)+len(point))
+	for key, value := range resource {
+		labels[key] = value
+	}
+	for key, value := range point {
+		labels[key] = value
+	}
+	return labels
+}
+
+// JSON encoding (protojson): 64-bit integers are strings, enums may be numbers or names
+
+type otlpJSONRequest struct {
+	ResourceMetrics []struct {
+		Resource struct {
+			Attributes otlpJSONAttributes `json:"attributes"`
+		} `json:"resource"`
+		ScopeMetrics []struct {
+			Metrics []struct {
+				Name  string `json:"name"`
+				Gauge *struct {
+					DataPoints []otlpJSONDataPoint `json:"dataPoints"`
+				} `json:"gauge"`
+				Sum *struct {
+					DataPoints             []otlpJSONDataPoint `json:"dataPoints"`
+					AggregationTemporality otlpTemporality     `json:"aggregationTemporality"`
+					IsMonotonic            bool                `json:"isMonotonic"`
+				} `json:"sum"`
+			} `json:"metrics"`
+		} `json:"scopeMetrics"`
+	} `json:"resourceMetrics"`
+}
+
+type otlpJSONDataPoint struct {
+	Attributes        otlpJSONAttributes `json:"attributes"`
+	StartTimeUnixNano otlpUint           `json:"startTimeUnixNano"`
+	AsInt             *otlpInt           `json:"asInt"`
+	AsDouble          *otlpDouble        `json:"asDouble"`
+}
+
+type otlpJSONAttributes []struct {
+	Key   string `json:"key"`
+	Value struct {
+		StringValue *string     `json:"stringValue"`
+		BoolValue   *bool       `json:"boolValue"`
+		IntValue    *otlpInt    `json:"intValue"`
+		DoubleValue *otlpDouble `json:"doubleValue"`
+	} `json:"value"`
+}
+
+func (a otlpJSONAttributes) labels() map[string]string {
+	labels := make(map[string]string, len(a))
+	for _, attribute := range a {
+		switch {
+		case attribute.Value.StringValue != nil:
+			labels[attribute.Key] = *attribute.Value.StringValue
+		case attribute.Value.BoolValue != nil:
+			labels[attribute.Key] = strconv.FormatBool(*attribute.Value.BoolValue)
+		case attribute.Value.IntValue != nil:
+			labels[attribute.Key] = strconv.FormatInt(int64(*attribute.Value.IntValue), 10)
+		case attribute.Value.DoubleValue != nil:
+			labels[attribute.Key] = strconv.FormatFloat(float64(*attribute.Value.DoubleValue), 'f', -1, 64)
+		}
+	}
+	return labels
+}
+
+type otlpInt int64
+
+func (i *otlpInt) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseInt(string(bytes.Trim(data, `"`)), 10, 64)
+	*i = otlpInt(value)
+	return err
+}
+
+type otlpUint uint64
+
+func (i *otlpUint) UnmarshalJSON(data []byte) error {
+	value, err := strconv.ParseUint(string(bytes.Trim(data, `"`)), 10, 64)
+	*i = otlpUint(value)
+	return err
+}
+
+type otlpDouble float64
+
+func (f *otlpDouble) UnmarshalJSON(data []byte) error {
+	// protojson encodes special values as strings: "NaN", "Infinity", "-Infinity"
+	switch text := string(bytes.Trim(data, `"`)); text {
+	case "NaN":
+		*f = otlpDouble(math.NaN())
+	case "Infinity":
+		*f = otlpDouble(math.Inf(1))
+	case "-Infinity":
+		*f = otlpDouble(math.Inf(-1))
+	default:
+		value, err := strconv.ParseFloat(text, 64)
+		*f = otlpDouble(value)
+		return err
+	}
+	return nil
+}
+
+type otlpTemporality int
+
+func (t *otlpTemporality) UnmarshalJSON(data []byte) error {
+	switch string(bytes.Trim(data, `"`)) {
+	case "AGGREGATION_TEMPORALITY_DELTA", "1":
+		*t = otlpTemporalityDelta
+	case "AGGREGATION_TEMPORALITY_CUMULATIVE", "2":
+		*t = otlpTemporalityCumulative
+	default:
+		*t = 0
+	}
+	return nil
+}
+
+// protobuf encoding
+
+func parseResourceMetrics(data []byte) ([]otlpPoint, error) {
+	resourceLabels := make(map[string]string)
+	var scopes [][]byte
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case resourceMetricsResource:
+			return forEachProtoField(field.bytes, func(field protoField) error {
+				if field.num != resourceAttributes {
+					return nil
+				}
+				return parseKeyValue(field.bytes, resourceLabels)
+			})
+		case resourceMetricsScopeMetrics:
+			// resource may be encoded after scope metrics, so parse scopes later
+			scopes = append(scopes, field.bytes)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var points []otlpPoint
+	for _, scope := range scopes {
+		err = forEachProtoField(scope, func(field protoField) error {
+			if field.num != scopeMetricsMetrics {
+				return nil
+			}
+			metricPoints, err := parseOTLPMetric(field.bytes, resourceLabels)
+			points = append(points, metricPoints...)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return points, nil
+}
+
+func parseOTLPMetric(data []byte, resourceLabels map[string]string) ([]otlpPoint, error) {
+	var name string
+	var gaugeData, sumData []byte
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case metricName:
+			name = string(field.bytes)
+		case metricGauge:
+			gaugeData = field.bytes
+		case metricSum:
+			sumData = field.bytes
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if name == "" {
+		return nil, ErrNoMetricName
+	}
+
+	template := otlpPoint{name: name}
+	var dataPoints [][]byte
+	switch {
+	case sumData != nil:
+		err = forEachProtoField(sumData, func(field protoField) error {
+			switch field.num {
+			case sumDataPoints:
+				dataPoints = append(dataPoints, field.bytes)
+			case sumAggregationTemporal:
+				template.temporality = int(field.number)
+			case sumIsMonotonic:
+				template.monotonic = field.number != 0
+			}
+			return nil
+		})
+	case gaugeData != nil:
+		err = forEachProtoField(gaugeData, func(field protoField) error {
+			if field.num == gaugeDataPoints {
+				dataPoints = append(dataPoints, field.bytes)
+			}
+			return nil
+		})
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	points := make([]otlpPoint, 0, len(dataPoints))
+	for _, dataPoint := range dataPoints {
+		point := template
+		attributes := make(map[string]string)
+		hasValue := false
+		err = forEachProtoField(dataPoint, func(field protoField) error {
+			switch field.num {
+			case dataPointAttributes:
+				return parseKeyValue(field.bytes, attributes)
+			case dataPointStartTime:
+				point.start = field.number
+			case dataPointAsDouble:
+				point.isInt, point.value, hasValue = false, math.Float64frombits(field.number), true
+			case dataPointAsInt:
+				point.isInt, point.intValue, hasValue = true, int64(field.number), true
+				point.value = float64(point.intValue)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		if !hasValue {
+			continue
+		}
+		point.labels = mergeLabels(resourceLabels, attributes)
+		points = append(points, point)
+	}
+
+	return points, nil
+}
+
+func parseKeyValue(data []byte, labels map[string]string) error {
+	var key string
+	var value *string
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case keyValueKey:
+			key = string(field.bytes)
+		case keyValueValue:
+			return forEachProtoField(field.bytes, func(field protoField) error {
+				var text string
+				switch field.num {
+				case anyValueString:
+					text = string(field.bytes)
+				case anyValueBool:
+					text = strconv.FormatBool(field.number != 0)
+				case anyValueInt:
+					text = strconv.FormatInt(int64(field.number), 10)
+				case anyValueDouble:
+					text = strconv.FormatFloat(math.Float64frombits(field.number), 'f', -1, 64)
+				default:
+					// arrays, maps and bytes are not used as labels
+					return nil
+				}
+				value = &text
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if key != "" && value != nil {
+		labels[key] = *value
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package ingest
+
+import (
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestOTLPDecoder_DecodeJSON(t *testing.T) {
+	request := func(requests string, start int64) []byte {
+		return []byte(`{"resourceMetrics":[{
+			"resource":{"attributes":[{"key":"service.name","value":{"stringValue":"checkout"}}]},
+			"scopeMetrics":[{"metrics":[
+				{"name":"http.requests","sum":{"aggregationTemporality":2,"isMonotonic":true,
+					"dataPoints":[{"asInt":"` + requests + `","startTimeUnixNano":"` + strconv.FormatInt(start, 10) + `",
+						"attributes":[{"key":"code","value":{"intValue":"200"}}]}]}},
+				{"name":"queue.size","gauge":{"dataPoints":[{"asDouble":7.5}]}},
+				{"name":"errors","sum":{"aggregationTemporality":"AGGREGATION_TEMPORALITY_DELTA","isMonotonic":true,
+					"dataPoints":[{"asInt":"2"}]}}
+			]}]
+		}]}`)
+	}
+	const requests = "http.requests;code=200;service.name=checkout"
+
+	decoder := NewOTLPDecoder()
+	// stream started after the decoder was created: the whole sum is new
+	start := time.Now().Add(time.Second).UnixNano()
+	metrics, _, err := decoder.DecodeJSON(request("10", start))
+	require.NoError(t, err)
+	byID := metricsByID(metrics)
+	require.Len(t, byID, 3)
+	assert.Equal(t, int64(10), *byID[requests].Delta)
+	assert.Equal(t, 7.5, *byID["queue.size;service.name=checkout"].Value)
+	assert.Equal(t, int64(2), *byID["errors;service.name=checkout"].Delta)
+
+	// cumulative sum is converted to increase since previous export
+	metrics, _, err = decoder.DecodeJSON(request("25", start))
+	require.NoError(t, err)
+	assert.Equal(t, int64(15), *metricsByID(metrics)[requests].Delta)
+
+	// export that wasn't saved is rolled back and its increase goes to the next one
+	_, reservation, err := decoder.DecodeJSON(request("30", start))
+	require.NoError(t, err)
+	decoder.Rollback(reservation)
+	metrics, _, err = decoder.DecodeJSON(request("32", start))
+	require.NoError(t, err)
+	assert.Equal(t, int64(7), *metricsByID(metrics)[requests].Delta)
+
+	// overlapping export of the same value (retry) doesn't count the increase twice
+	metrics, first, err := decoder.DecodeJSON(request("40", start))
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), *metricsByID(metrics)[requests].Delta)
+	metrics, _, err = decoder.DecodeJSON(request("40", start))
+	require.NoError(t, err)
+	assert.NotContains(t, metricsByID(metrics), requests)
+	// rollback of the first export doesn't touch the value remembered by the later one
+	decoder.Rollback(first)
+	metrics, _, err = decoder.DecodeJSON(request("41", start))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *metricsByID(metrics)[requests].Delta)
+
+	// new start time means the stream was restarted
+	metrics, _, err = decoder.DecodeJSON(request("45", start+1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(45), *metricsByID(metrics)[requests].Delta)
+
+	// counter reset without start time
+	metrics, _, err = decoder.DecodeJSON(request("4", 0))
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *metricsByID(metrics)[requests].Delta)
+
+	_, _, err = decoder.DecodeJSON([]byte(`{"resourceMetrics":`))
+	assert.ErrorIs(t, err, ErrMalformedPayload)
+}
+
+func TestOTLPDecoder_UnknownSeries(t *testing.T) {
+	point := func(value float64, start uint64) []otlpPoint {
+		return []otlpPoint{{name: "requests", monotonic: true, temporality: otlpTemporalityCumulative, value: value, start: start}}
+	}
+
+	now := time.Now()
+	decoder := NewOTLPDecoder()
+	decoder.now = func() time.Time { return now }
+
+	// stream started before the decoder (e.g. server restart): its sum may be already counted
+	started := uint64(now.Add(-time.Minute).UnixNano())
+	metrics, _ := decoder.convert(point(100, started))
+	assert.Empty(t, metrics)
+	metrics, _ = decoder.convert(point(130, started))
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(30), *metrics[0].Delta)
+
+	// unknown start time: the first point is a baseline only
+	metrics, _ = decoder.convert([]otlpPoint{{name: "other", monotonic: true, temporality: otlpTemporalityCumulative, value: 5}})
+	assert.Empty(t, metrics)
+
+	// evicted series is a baseline again, even if it started after the decoder was created
+	restarted := uint64(now.Add(time.Second).UnixNano())
+	now = now.Add(2 * time.Second)
+	metrics, _ = decoder.convert(point(10, restarted))
+	require.Len(t, metrics, 1)
+	assert.Equal(t, int64(10), *metrics[0].Delta)
+	now = now.Add(otlpSeriesTTL + time.Minute)
+	metrics, _ = decoder.convert(nil)
+	assert.Empty(t, metrics)
+	assert.Empty(t, decoder.previous)
+	metrics, _ = decoder.convert(point(20, restarted))
+	assert.Empty(t, metrics)
+}
+
+func TestOTLPDecoder_DecodeProtobuf(t *testing.T) {
+	attribute := func(key, value string) []byte {
+		var anyValue, keyValue []byte
+		anyValue = protowire.AppendTag(anyValue, anyValueString, protowire.BytesType)
+		anyValue = protowire.AppendString(anyValue, value)
+		keyValue = protowire.AppendTag(keyValue, keyValueKey, protowire.BytesType)
+		keyValue = protowire.AppendString(keyValue, key)
+		return appendMessage(keyValue, keyValueValue, anyValue)
+	}
+
+	var dataPoint []byte
+	dataPoint = protowire.AppendTag(dataPoint, dataPointAsDouble, protowire.Fixed64Type)
+	dataPoint = protowire.AppendFixed64(dataPoint, math.Float64bits(0.75))
+	dataPoint = appendMessage(dataPoint, dataPointAttributes, attribute("cpu", "0"))
+
+	var gaugeMessage, metric, scope, resource, resourceMetrics, request []byte
+	gaugeMessage = appendMessage(gaugeMessage, gaugeDataPoints, dataPoint)
+	metric = protowire.AppendTag(metric, metricName, protowire.BytesType)
+	metric = protowire.AppendString(metric, "system.cpu.utilization")
+	metric = appendMessage(metric, metricGauge, gaugeMessage)
+	scope = appendMessage(scope, scopeMetricsMetrics, metric)
+	resource = appendMessage(resource, resourceAttributes, attribute("host.name", "web01"))
+	resourceMetrics = appendMessage(resourceMetrics, resourceMetricsScopeMetrics, scope)
+	resourceMetrics = appendMessage(resourceMetrics, resourceMetricsResource, resource)
+	request = appendMessage(request, exportRequestResourceMetrics, resourceMetrics)
+
+	metrics, _, err := NewOTLPDecoder().DecodeProtobuf(request)
+	require.NoError(t, err)
+	require.Len(t, metrics, 1)
+	assert.Equal(t, "system.cpu.utilization", metrics[0].ID)
+	assert.Equal(t, map[string]string{"cpu": "0", "host.name": "web01"}, metrics[0].Labels)
+	assert.Equal(t, models.Gauge, metrics[0].MType)
+	assert.Equal(t, 0.75, *metrics[0].Value)
+}
+
+func TestOTLPDecoder_Evict(t *testing.T) {
+	point := func(name string) otlpPoint {
+		return otlpPoint{name: name, monotonic: true, temporality: otlpTemporalityCumulative, value: 1}
+	}
+
+	now := time.Now()
+	decoder := NewOTLPDecoder()
+	decoder.now = func() time.Time { return now }
+	decoder.forgotten = now.Add(-time.Hour)
+
+	decoder.convert([]otlpPoint{point("stale"), point("alive")})
+	now = now.Add(otlpSeriesTTL / 2)
+	decoder.convert([]otlpPoint{point("alive")})
+	now = now.Add(otlpSeriesTTL/2 + time.Second)
+	decoder.convert([]otlpPoint{point("fresh")})
+	assert.ElementsMatch(t, []string{"alive", "fresh"}, slices.Collect(maps.Keys(decoder.previous)))
+	assert.Equal(t, now.Add(-otlpSeriesTTL-time.Second), decoder.forgotten)
+
+	// above the limit the oldest series are dropped
+	points := make([]otlpPoint, maxOTLPSeries)
+	for i := range maxOTLPSeries {
+		points[i] = point(strconv.Itoa(i))
+	}
+	now = now.Add(time.Second)
+	decoder.convert(points)
+	assert.Len(t, decoder.previous, maxOTLPSeries)
+	assert.NotContains(t, decoder.previous, "alive")
+	assert.NotContains(t, decoder.previous, "fresh")
+}
@@ -0,0 +1,54 @@
+package ingest
+
+import (
+	"fmt"
+
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+// protoField одно поле protobuf-сообщения.
+// Для varint и fixed полей значение лежит в number, для length-delimited - в bytes
+type protoField struct {
+	num    protowire.Number
+	typ    protowire.Type
+	number uint64
+	bytes  []byte
+}
+
+// forEachProtoField вызывает fn для каждого поля protobuf-сообщения.
+// Используется вместо сгенерированного кода: нам нужны лишь несколько полей из сообщений
+func forEachProtoField(data []byte, fn func(field protoField) error) error {
+	for len(data) > 0 {
+		num, typ, n := protowire.ConsumeTag(data)
+		if n < 0 {
+			return fmt.Errorf("%w: %w", ErrMalformedPayload, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		field := protoField{num: num, typ: typ}
+		switch typ {
+		case protowire.VarintType:
+			field.number, n = protowire.ConsumeVarint(data)
+		case protowire.Fixed64Type:
+			field.number, n = protowire.ConsumeFixed64(data)
+		case protowire.Fixed32Type:
+			var value uint32
+			value, n = protowire.ConsumeFixed32(data)
+			field.number = uint64(value)
+		case protowire.BytesType:
+			field.bytes, n = protowire.ConsumeBytes(data)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, data)
+		}
+		if n < 0 {
+			return fmt.Errorf("%w: %w", ErrMalformedPayload, protowire.ParseError(n))
+		}
+		data = data[n:]
+
+		if err := fn(field); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,146 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/golang/snappy"
+)
+
+// номера полей из prometheus/prompb/remote.proto и types.proto
+const (
+	writeRequestTimeseries = 1
+
+	timeSeriesLabels  = 1
+	timeSeriesSamples = 2
+
+	labelName  = 1
+	labelValue = 2
+
+	sampleValue     = 1
+	sampleTimestamp = 2
+
+	prometheusNameLabel = "__name__"
+
+	// MaxRemoteWriteDecodedSize предел размера WriteRequest после распаковки snappy, как у Prometheus
+	MaxRemoteWriteDecodedSize = 32 << 20
+)
+
+type remoteWriteSample struct {
+	value     float64
+	timestamp int64
+}
+
+// ParseRemoteWrite разбирает тело запроса Prometheus remote write (сжатый snappy protobuf WriteRequest).
+// Каждая серия превращается в gauge с последним по времени значением.
+// Счётчики Prometheus накопительные, поэтому тоже сохраняются как gauge - иначе сервер сложил бы их повторно.
+// Метки серии, кроме __name__, становятся метками метрики
+func ParseRemoteWrite(body []byte) ([]models.Metrics, error) {
+	// snappy.Decode allocates the length from the header, so it is checked before decoding
+	size, err := snappy.DecodedLen(body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error during snappy decoding: %w", ErrMalformedPayload, err)
+	}
+	if size > MaxRemoteWriteDecodedSize {
+		return nil, fmt.Errorf("%w: decoded size %d is greater than %d", ErrPayloadTooLarge, size, MaxRemoteWriteDecodedSize)
+	}
+
+	data, err := snappy.Decode(nil, body)
+	if err != nil {
+		return nil, fmt.Errorf("%w: error during snappy decoding: %w", ErrMalformedPayload, err)
+	}
+
+	var metrics []models.Metrics
+	err = forEachProtoField(data, func(field protoField) error {
+		if field.num != writeRequestTimeseries {
+			return nil
+		}
+
+		metric, ok, parseErr := parseTimeSeries(field.bytes)
+		if parseErr != nil {
+			return parseErr
+		}
+		if ok {
+			metrics = append(metrics, metric)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// parseTimeSeries возвращает false, если в серии нет ни одного значения (или есть только stale-маркеры)
+func parseTimeSeries(data []byte) (models.Metrics, bool, error) {
+	labels := make(map[string]string)
+	var last *remoteWriteSample
+
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case timeSeriesLabels:
+			name, value, err := parseLabel(field.bytes)
+			if err != nil {
+				return err
+			}
+			labels[name] = value
+		case timeSeriesSamples:
+			sample, err := parseSample(field.bytes)
+			if err != nil {
+				return err
+			}
+			// NaN is used by Prometheus as a staleness marker
+			if math.IsNaN(sample.value) {
+				return nil
+			}
+			if last == nil || sample.timestamp >= last.timestamp {
+				last = &sample
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return models.Metrics{}, false, err
+	}
+
+	name := labels[prometheusNameLabel]
+	if name == "" {
+		return models.Metrics{}, false, ErrNoMetricName
+	}
+	if last == nil {
+		return models.Metrics{}, false, nil
+	}
+	delete(labels, prometheusNameLabel)
+
+	return gauge(name, labels, last.value), true, nil
+}
+
+func parseLabel(data []byte) (string, string, error) {
+	var name, value string
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case labelName:
+			name = string(field.bytes)
+		case labelValue:
+			value = string(field.bytes)
+		}
+		return nil
+	})
+	return name, value, err
+}
+
+func parseSample(data []byte) (remoteWriteSample, error) {
+	var sample remoteWriteSample
+	err := forEachProtoField(data, func(field protoField) error {
+		switch field.num {
+		case sampleValue:
+			sample.value = math.Float64frombits(field.number)
+		case sampleTimestamp:
+			sample.timestamp = int64(field.number)
+		}
+		return nil
+	})
+	return sample, err
+}
@@ -0,0 +1,82 @@
+package ingest
+
+import (
+	"math"
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/golang/snappy"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/protobuf/encoding/protowire"
+)
+
+func TestParseRemoteWrite(t *testing.T) {
+	request := appendMessage(nil, writeRequestTimeseries, timeSeries(
+		map[string]string{"__name__": "go_memstats_alloc_bytes", "instance": "host1"},
+		[]remoteWriteSample{{value: 10, timestamp: 1000}, {value: 30, timestamp: 3000}, {value: 20, timestamp: 2000}},
+	))
+	request = appendMessage(request, writeRequestTimeseries, timeSeries(
+		map[string]string{"__name__": "up"},
+		[]remoteWriteSample{{value: 1, timestamp: 1000}},
+	))
+	// series with stale marker only is skipped
+	request = appendMessage(request, writeRequestTimeseries, timeSeries(
+		map[string]string{"__name__": "stale"},
+		[]remoteWriteSample{{value: math.NaN(), timestamp: 1000}},
+	))
+
+	metrics, err := ParseRemoteWrite(snappy.Encode(nil, request))
+	require.NoError(t, err)
+	require.Len(t, metrics, 2)
+
+	assert.Equal(t, "go_memstats_alloc_bytes", metrics[0].ID)
+	assert.Equal(t, map[string]string{"instance": "host1"}, metrics[0].Labels)
+	assert.Equal(t, models.Gauge, metrics[0].MType)
+	assert.Equal(t, 30.0, *metrics[0].Value)
+	assert.Equal(t, "up", metrics[1].ID)
+	assert.Nil(t, metrics[1].Labels)
+	assert.Equal(t, 1.0, *metrics[1].Value)
+}
+
+func TestParseRemoteWrite_Invalid(t *testing.T) {
+	_, err := ParseRemoteWrite([]byte("not snappy"))
+	assert.ErrorIs(t, err, ErrMalformedPayload)
+
+	noName := appendMessage(nil, writeRequestTimeseries, timeSeries(
+		map[string]string{"job": "node"},
+		[]remoteWriteSample{{value: 1, timestamp: 1000}},
+	))
+	_, err = ParseRemoteWrite(snappy.Encode(nil, noName))
+	assert.ErrorIs(t, err, ErrNoMetricName)
+
+	// header claims 4 GiB of decoded data
+	_, err = ParseRemoteWrite([]byte{0xff, 0xff, 0xff, 0xff, 0x0f})
+	assert.ErrorIs(t, err, ErrPayloadTooLarge)
+}
+
+func timeSeries(labels map[string]string, samples []remoteWriteSample) []byte {
+	var series []byte
+	for name, value := range labels {
+		var label []byte
+		label = protowire.AppendTag(label, labelName, protowire.BytesType)
+		label = protowire.AppendString(label, name)
+		label = protowire.AppendTag(label, labelValue, protowire.BytesType)
+		label = protowire.AppendString(label, value)
+		series = appendMessage(series, timeSeriesLabels, label)
+	}
+	for _, s := range samples {
+		var sample []byte
+		sample = protowire.AppendTag(sample, sampleValue, protowire.Fixed64Type)
+		sample = protowire.AppendFixed64(sample, math.Float64bits(s.value))
+		sample = protowire.AppendTag(sample, sampleTimestamp, protowire.VarintType)
+		sample = protowire.AppendVarint(sample, uint64(s.timestamp))
+		series = appendMessage(series, timeSeriesSamples, sample)
+	}
+	return series
+}
+
+func appendMessage(data []byte, num protowire.Number, message []byte) []byte {
+	data = protowire.AppendTag(data, num, protowire.BytesType)
+	return protowire.AppendBytes(data, message)
+}
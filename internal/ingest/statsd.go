@@ -0,0 +1,220 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+const (
+	StatsDCounter = "c"
+	StatsDGauge   = "g"
+	StatsDTimer   = "ms"
+	StatsDSet     = "s"
+)
+
+// StatsDMetric одна строка StatsD вида `name:value|type|@sample_rate|#tag:value`
+type StatsDMetric struct {
+	Name       string
+	Tags       map[string]string
+	Type       string
+	Value      float64
+	SetValue   string  // значение для типа `s`
+	SampleRate float64 // 1 если не задан
+	Relative   bool    // gauge со знаком: `+5` или `-5` меняет текущее значение
+}
+
+// ParseStatsDLine разбирает одну строку StatsD. Теги DogStatsD (`#tag:value,...`) становятся метками метрики
+func ParseStatsDLine(line string) (StatsDMetric, error) {
+	nameAndRest := strings.SplitN(line, ":", 2)
+	if len(nameAndRest) != 2 || nameAndRest[0] == "" {
+		return StatsDMetric{}, fmt.Errorf("%w: line %q has no metric name", ErrMalformedPayload, line)
+	}
+
+	parts := strings.Split(nameAndRest[1], "|")
+	if len(parts) < 2 {
+		return StatsDMetric{}, fmt.Errorf("%w: line %q has no metric type", ErrMalformedPayload, line)
+	}
+
+	metric := StatsDMetric{
+		Type:       parts[1],
+		SampleRate: 1,
+	}
+	tags := make(map[string]string)
+	for _, part := range parts[2:] {
+		switch {
+		case strings.HasPrefix(part, "@"):
+			rate, err := strconv.ParseFloat(part[1:], 64)
+			if err != nil || rate <= 0 || rate > 1 {
+				return StatsDMetric{}, fmt.Errorf("%w: line %q has invalid sample rate", ErrMalformedPayload, line)
+			}
+			metric.SampleRate = rate
+		case strings.HasPrefix(part, "#"):
+			for _, tag := range strings.Split(part[1:], ",") {
+				key, value, _ := strings.Cut(tag, ":")
+				if key != "" {
+					tags[key] = value
+				}
+			}
+		}
+	}
+	metric.Name = nameAndRest[0]
+	metric.Tags = nonEmptyLabels(tags)
+
+	rawValue := parts[0]
+	switch metric.Type {
+	case StatsDSet:
+		metric.SetValue = rawValue
+		return metric, nil
+	case StatsDGauge:
+		metric.Relative = strings.HasPrefix(rawValue, "+") || strings.HasPrefix(rawValue, "-")
+	case StatsDCounter, StatsDTimer:
+	default:
+		return StatsDMetric{}, fmt.Errorf("%w: line %q has unsupported metric type", ErrMalformedPayload, line)
+	}
+
+	value, err := strconv.ParseFloat(rawValue, 64)
+	if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+		return StatsDMetric{}, fmt.Errorf("%w: line %q has invalid value", ErrMalformedPayload, line)
+	}
+	metric.Value = value
+
+	return metric, nil
+}
+
+// maxIdleGaugeFlushes через столько сбросов без обновлений gauge забывается (час при интервале 10 секунд),
+// иначе значения gauge, переставших приходить, копились бы бесконечно
+const maxIdleGaugeFlushes = 360
+
+// StatsDAggregator накапливает значения StatsD за интервал сброса.
+// Счётчики суммируются с учётом sample rate, для gauge берётся последнее значение,
+// таймеры превращаются в gauge `<name>.p50`, `<name>.p95`, `<name>.max`, `<name>.count`,
+// множества - в gauge с количеством уникальных значений
+type StatsDAggregator struct {
+	series   map[string]StatsDMetric // name and tags by series key
+	counters map[string]float64
+	gauges   map[string]float64
+	idle     map[string]int      // flushes since the last gauge update
+	updated  map[string]struct{} // gauges updated in current interval
+	timers   map[string][]float64
+	samples  map[string]float64 // timer samples count with sample rate applied
+	sets     map[string]map[string]struct{}
+	mu       *sync.Mutex
+}
+
+func NewStatsDAggregator() *StatsDAggregator {
+	aggregator := &StatsDAggregator{
+		series: make(map[string]StatsDMetric),
+		gauges: make(map[string]float64),
+		idle:   make(map[string]int),
+		mu:     &sync.Mutex{},
+	}
+	aggregator.reset()
+	return aggregator
+}
+
+func (a *StatsDAggregator) Add(metric StatsDMetric) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := metric.Type + ":" + models.MetricKey(metric.Name, metric.Tags)
+	a.series[key] = StatsDMetric{Name: metric.Name, Tags: metric.Tags}
+
+	switch metric.Type {
+	case StatsDCounter:
+		a.counters[key] += metric.Value / metric.SampleRate
+	case StatsDGauge:
+		// gauges keep their value between intervals, so relative changes can be applied
+		if metric.Relative {
+			a.gauges[key] += metric.Value
+		} else {
+			a.gauges[key] = metric.Value
+		}
+		a.updated[key] = struct{}{}
+	case StatsDTimer:
+		a.timers[key] = append(a.timers[key], metric.Value)
+		a.samples[key] += 1 / metric.SampleRate
+	case StatsDSet:
+		if _, ok := a.sets[key]; !ok {
+			a.sets[key] = make(map[string]struct{})
+		}
+		a.sets[key][metric.SetValue] = struct{}{}
+	}
+}
+
+// Flush возвращает метрики, накопленные за интервал, и начинает новый интервал
+func (a *StatsDAggregator) Flush() []models.Metrics {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var metrics []models.Metrics
+	for key, value := range a.counters {
+		series := a.series[key]
+		metrics = append(metrics, counter(series.Name, series.Tags, int64(math.Round(value))))
+	}
+	for key := range a.updated {
+		series := a.series[key]
+		metrics = append(metrics, gauge(series.Name, series.Tags, a.gauges[key]))
+	}
+	for key, values := range a.timers {
+		series := a.series[key]
+		slices.Sort(values)
+		metrics = append(metrics,
+			gauge(series.Name+".p50", series.Tags, percentile(values, 50)),
+			gauge(series.Name+".p95", series.Tags, percentile(values, 95)),
+			gauge(series.Name+".max", series.Tags, values[len(values)-1]),
+			gauge(series.Name+".count", series.Tags, a.samples[key]),
+		)
+	}
+	for key, values := range a.sets {
+		series := a.series[key]
+		metrics = append(metrics, gauge(series.Name, series.Tags, float64(len(values))))
+	}
+
+	a.pruneGauges()
+	a.reset()
+	return metrics
+}
+
+// pruneGauges забывает gauge, не обновлявшиеся maxIdleGaugeFlushes сбросов,
+// и имена серий, которые больше не нужны: остальные значения сбрасываются каждый интервал
+func (a *StatsDAggregator) pruneGauges() {
+	for key := range a.gauges {
+		if _, ok := a.updated[key]; ok {
+			a.idle[key] = 0
+			continue
+		}
+		a.idle[key]++
+		if a.idle[key] >= maxIdleGaugeFlushes {
+			delete(a.gauges, key)
+			delete(a.idle, key)
+		}
+	}
+	for key := range a.series {
+		if _, ok := a.gauges[key]; !ok {
+			delete(a.series, key)
+		}
+	}
+}
+
+func (a *StatsDAggregator) reset() {
+	a.counters = make(map[string]float64)
+	a.updated = make(map[string]struct{})
+	a.timers = make(map[string][]float64)
+	a.samples = make(map[string]float64)
+	a.sets = make(map[string]map[string]struct{})
+}
+
+// percentile считает перцентиль методом ближайшего ранга по отсортированным значениям
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if rank < 0 {
+		rank = 0
+	}
+	return sorted[rank]
+}
@@ -0,0 +1,210 @@
+package ingest
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/service"
+	"github.com/rs/zerolog"
+)
+
+const (
+	// maxUDPPacketSize максимальный размер UDP-пакета StatsD
+	maxUDPPacketSize = 65535
+	// defaultStatsDFlushInterval используется, если интервал сброса не задан в конфигурации
+	defaultStatsDFlushInterval = 10 * time.Second
+)
+
+// StatsDListener принимает строки StatsD по UDP и TCP на одном адресе
+// и раз в flushInterval сохраняет накопленные метрики через MetricsService
+type StatsDListener struct {
+	address        string
+	flushInterval  time.Duration
+	aggregator     *StatsDAggregator
+	metricsService service.MetricsService
+	log            *zerolog.Logger
+
+	conns   map[net.Conn]struct{} // open TCP connections, closed on shutdown
+	closed  bool                  // connections accepted after shutdown are closed at once
+	connsMu *sync.Mutex
+}
+
+func NewStatsDListener(metricsService service.MetricsService, cfg *config.ServerConfig, log *zerolog.Logger) *StatsDListener {
+	flushInterval := time.Duration(cfg.StatsDFlushInterval) * time.Second
+	if flushInterval <= 0 {
+		flushInterval = defaultStatsDFlushInterval
+	}
+
+	return &StatsDListener{
+		address:        cfg.StatsDAddress,
+		flushInterval:  flushInterval,
+		aggregator:     NewStatsDAggregator(),
+		metricsService: metricsService,
+		log:            log,
+		conns:          make(map[net.Conn]struct{}),
+		connsMu:        &sync.Mutex{},
+	}
+}
+
+// ListenAndServe слушает адрес до отмены контекста. После отмены соединения закрываются,
+// и накопленные метрики сохраняются последний раз
+func (l *StatsDListener) ListenAndServe(ctx context.Context) error {
+	udpConn, err := net.ListenPacket("udp", l.address)
+	if err != nil {
+		l.log.Err(err).Str("func", "*StatsDListener.ListenAndServe").Str("address", l.address).Msg("error listening UDP")
+		return err
+	}
+	defer udpConn.Close()
+
+	tcpListener, err := net.Listen("tcp", l.address)
+	if err != nil {
+		l.log.Err(err).Str("func", "*StatsDListener.ListenAndServe").Str("address", l.address).Msg("error listening TCP")
+		return err
+	}
+	defer tcpListener.Close()
+
+	l.log.Info().Str("func", "*StatsDListener.ListenAndServe").Str("address", l.address).Msg("StatsD listener started")
+	serving := &sync.WaitGroup{}
+	serving.Add(2)
+	go func() {
+		defer serving.Done()
+		l.serveUDP(udpConn)
+	}()
+	go func() {
+		defer serving.Done()
+		l.serveTCP(tcpListener, serving)
+	}()
+
+	ticker := time.NewTicker(l.flushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			l.log.Info().Str("func", "*StatsDListener.ListenAndServe").Msg("StatsD listener is stopping")
+			// lines read before closing are aggregated and saved with the last flush
+			udpConn.Close()
+			tcpListener.Close()
+			l.closeConnections()
+			serving.Wait()
+			l.flush(context.WithoutCancel(ctx))
+			return nil
+		case <-ticker.C:
+			l.flush(ctx)
+		}
+	}
+}
+
+func (l *StatsDListener) serveUDP(conn net.PacketConn) {
+	buf := make([]byte, maxUDPPacketSize)
+	for {
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				l.log.Err(err).Str("func", "*StatsDListener.serveUDP").Msg("error reading UDP packet")
+			}
+			return
+		}
+
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			l.handleLine(line)
+		}
+	}
+}
+
+func (l *StatsDListener) serveTCP(listener net.Listener, serving *sync.WaitGroup) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				l.log.Err(err).Str("func", "*StatsDListener.serveTCP").Msg("error accepting TCP connection")
+			}
+			return
+		}
+
+		l.connsMu.Lock()
+		if l.closed {
+			l.connsMu.Unlock()
+			conn.Close()
+			continue
+		}
+		l.conns[conn] = struct{}{}
+		l.connsMu.Unlock()
+
+		serving.Add(1)
+		go func() {
+			defer serving.Done()
+			defer l.closeConnection(conn)
+			scanner := bufio.NewScanner(conn)
+			for scanner.Scan() {
+				l.handleLine(scanner.Text())
+			}
+		}()
+	}
+}
+
+func (l *StatsDListener) closeConnection(conn net.Conn) {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+
+	conn.Close()
+	delete(l.conns, conn)
+}
+
+// closeConnections закрывает открытые TCP-соединения, их горутины завершаются на ошибке чтения
+func (l *StatsDListener) closeConnections() {
+	l.connsMu.Lock()
+	defer l.connsMu.Unlock()
+
+	l.closed = true
+	for conn := range l.conns {
+		conn.Close()
+	}
+}
+
+// handleLine разбирает и накапливает одну строку. Паника на строке не должна останавливать прием остальных
+func (l *StatsDListener) handleLine(line string) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.log.Error().Str("func", "*StatsDListener.handleLine").Str("line", line).Any("panic", r).Msg("panic during handling StatsD line")
+		}
+	}()
+
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return
+	}
+
+	metric, err := ParseStatsDLine(line)
+	if err != nil {
+		l.log.Err(err).Str("func", "*StatsDListener.handleLine").Msg("malformed StatsD line was skipped")
+		return
+	}
+
+	l.aggregator.Add(metric)
+}
+
+func (l *StatsDListener) flush(ctx context.Context) {
+	defer func() {
+		if r := recover(); r != nil {
+			l.log.Error().Str("func", "*StatsDListener.flush").Any("panic", r).Msg("panic during saving StatsD metrics")
+		}
+	}()
+
+	metrics := l.aggregator.Flush()
+	if len(metrics) == 0 {
+		return
+	}
+
+	if err := l.metricsService.SaveAll(ctx, metrics); err != nil {
+		l.log.Err(err).Str("func", "*StatsDListener.flush").Int("metrics", len(metrics)).Msg("error during saving StatsD metrics")
+		return
+	}
+
+	l.log.Debug().Str("func", "*StatsDListener.flush").Int("metrics", len(metrics)).Msg("StatsD metrics are saved")
+}
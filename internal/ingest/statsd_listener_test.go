@@ -0,0 +1,90 @@
+package ingest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/service"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// savingService запоминает сохраненные метрики, первое сохранение паникует
+type savingService struct {
+	service.MetricsService
+	mu       sync.Mutex
+	panicked bool
+	saved    []models.Metrics
+}
+
+func (s *savingService) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.panicked {
+		s.panicked = true
+		panic("storage failure")
+	}
+	s.saved = append(s.saved, metrics...)
+	return nil
+}
+
+func TestStatsDListener_Shutdown(t *testing.T) {
+	logger := zerolog.Nop()
+	probe, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	address := probe.Addr().String()
+	require.NoError(t, probe.Close())
+
+	metricsService := &savingService{}
+	listener := NewStatsDListener(metricsService, &config.ServerConfig{StatsDAddress: address, StatsDFlushInterval: 1}, &logger)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- listener.ListenAndServe(ctx) }()
+
+	var conn net.Conn
+	require.Eventually(t, func() bool {
+		conn, err = net.Dial("tcp", address)
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+	defer conn.Close()
+
+	// the first flush panics and must not stop the listener
+	_, err = conn.Write([]byte("lost:1|c\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		metricsService.mu.Lock()
+		defer metricsService.mu.Unlock()
+		return metricsService.panicked
+	}, 3*time.Second, 10*time.Millisecond)
+
+	_, err = conn.Write([]byte("requests:1|c\n"))
+	require.NoError(t, err)
+	require.Eventually(t, func() bool {
+		listener.aggregator.mu.Lock()
+		defer listener.aggregator.mu.Unlock()
+		return len(listener.aggregator.counters) > 0
+	}, time.Second, 10*time.Millisecond)
+
+	// open connection doesn't block shutdown
+	cancel()
+	select {
+	case err = <-done:
+		require.NoError(t, err)
+	case <-time.After(time.Second):
+		t.Fatal("listener didn't stop")
+	}
+	_, err = conn.Read(make([]byte, 1))
+	assert.Error(t, err)
+
+	metricsService.mu.Lock()
+	defer metricsService.mu.Unlock()
+	require.Len(t, metricsService.saved, 1)
+	assert.Equal(t, int64(1), *metricsService.saved[0].Delta)
+}
@@ -0,0 +1,89 @@
+package ingest
+
+import (
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseStatsDLine(t *testing.T) {
+	tests := []struct {
+		name    string
+		line    string
+		want    StatsDMetric
+		wantErr bool
+	}{
+		{name: "counter", line: "requests:1|c", want: StatsDMetric{Name: "requests", Type: StatsDCounter, Value: 1, SampleRate: 1}},
+		{name: "counter with sample rate", line: "requests:2|c|@0.5", want: StatsDMetric{Name: "requests", Type: StatsDCounter, Value: 2, SampleRate: 0.5}},
+		{name: "relative gauge", line: "queue:-3|g", want: StatsDMetric{Name: "queue", Type: StatsDGauge, Value: -3, SampleRate: 1, Relative: true}},
+		{name: "timer with tags", line: "latency:320|ms|#region:eu,host:a", want: StatsDMetric{Name: "latency", Tags: map[string]string{"host": "a", "region": "eu"}, Type: StatsDTimer, Value: 320, SampleRate: 1}},
+		{name: "set", line: "users:alice|s", want: StatsDMetric{Name: "users", Type: StatsDSet, SetValue: "alice", SampleRate: 1}},
+		{name: "no type", line: "requests:1", wantErr: true},
+		{name: "no name", line: ":1|c", wantErr: true},
+		{name: "unknown type", line: "requests:1|x", wantErr: true},
+		{name: "bad value", line: "requests:abc|c", wantErr: true},
+		{name: "bad sample rate", line: "requests:1|c|@2", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			metric, err := ParseStatsDLine(test.line)
+			if test.wantErr {
+				assert.ErrorIs(t, err, ErrMalformedPayload)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, metric)
+		})
+	}
+}
+
+func TestStatsDAggregator_Flush(t *testing.T) {
+	aggregator := NewStatsDAggregator()
+	for _, line := range []string{
+		"requests:1|c", "requests:1|c|@0.5",
+		"queue:10|g", "queue:+5|g",
+		"users:alice|s", "users:bob|s", "users:alice|s",
+	} {
+		metric, err := ParseStatsDLine(line)
+		require.NoError(t, err)
+		aggregator.Add(metric)
+	}
+	for i := 1; i <= 100; i++ {
+		aggregator.Add(StatsDMetric{Name: "latency", Type: StatsDTimer, Value: float64(i), SampleRate: 1})
+	}
+
+	flushed := metricsByID(aggregator.Flush())
+	assert.Equal(t, int64(3), *flushed["requests"].Delta)
+	assert.Equal(t, 15.0, *flushed["queue"].Value)
+	assert.Equal(t, 2.0, *flushed["users"].Value)
+	assert.Equal(t, 50.0, *flushed["latency.p50"].Value)
+	assert.Equal(t, 95.0, *flushed["latency.p95"].Value)
+	assert.Equal(t, 100.0, *flushed["latency.max"].Value)
+	assert.Equal(t, 100.0, *flushed["latency.count"].Value)
+
+	// next interval starts empty, gauges keep their value for relative updates
+	assert.Empty(t, aggregator.Flush())
+	aggregator.Add(StatsDMetric{Name: "queue", Type: StatsDGauge, Value: -1, SampleRate: 1, Relative: true})
+	flushed = metricsByID(aggregator.Flush())
+	assert.Equal(t, 14.0, *flushed["queue"].Value)
+
+	// gauge that stopped reporting is forgotten
+	for range maxIdleGaugeFlushes {
+		assert.Empty(t, aggregator.Flush())
+	}
+	assert.Empty(t, aggregator.gauges)
+	assert.Empty(t, aggregator.series)
+	aggregator.Add(StatsDMetric{Name: "queue", Type: StatsDGauge, Value: -1, SampleRate: 1, Relative: true})
+	flushed = metricsByID(aggregator.Flush())
+	assert.Equal(t, -1.0, *flushed["queue"].Value)
+}
+
+func metricsByID(metrics []models.Metrics) map[string]models.Metrics {
+	result := make(map[string]models.Metrics, len(metrics))
+	for _, metric := range metrics {
+		result[metric.Key()] = metric
+	}
+	return result
+}
@@ -1,6 +1,7 @@
 package server
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
@@ -10,11 +11,22 @@ type Server struct {
 	server *http.Server
 }
 
-func (s *Server) ServerRun(handler http.Handler, cfg *config.ServerConfig) error {
-	s.server = &http.Server{
-		Addr:    cfg.ServerAddress,
-		Handler: handler,
+// NewServer создает http.Server заранее, чтобы Shutdown можно было вызвать из другой горутины в любой момент,
+// в том числе до запуска ServerRun: тогда сервер не начнет принимать запросы
+func NewServer(handler http.Handler, cfg *config.ServerConfig) *Server {
+	return &Server{
+		server: &http.Server{
+			Addr:    cfg.ServerAddress,
+			Handler: handler,
+		},
 	}
+}
 
+func (s *Server) ServerRun() error {
 	return s.server.ListenAndServe()
 }
+
+// Shutdown перестает принимать новые запросы и ждет завершения обрабатываемых
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.server.Shutdown(ctx)
+}
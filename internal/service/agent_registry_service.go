@@ -0,0 +1,142 @@
+package service
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+const (
+	defaultAgentReportInterval = 5 * time.Second
+	defaultAgentStaleIntervals = int64(3)
+
+	// agentForgetAfter агент, не присылавший метрики дольше этого времени, удаляется из реестра
+	agentForgetAfter = 24 * time.Hour
+	// agentEvictInterval как часто искать агентов для удаления
+	agentEvictInterval = time.Minute
+	// maxAgents при заполнении реестра новый агент вытесняет самого давно не присылавшего метрики.
+	// ID агента передается в заголовке и не подписывается, поэтому реестр ограничен
+	maxAgents = 10_000
+)
+
+// AgentRegistry хранит в памяти сведения об агентах, присылающих метрики.
+// Агент считается устаревшим (stale), если пропустил staleIntervals интервалов отправки,
+// и забывается, если не присылал метрики дольше agentForgetAfter
+type AgentRegistry struct {
+	agents         map[string]models.AgentInfo
+	evicted        time.Time
+	reportInterval time.Duration
+	staleIntervals int64
+	now            func() time.Time
+	mu             *sync.RWMutex
+	log            *zerolog.Logger
+}
+
+func NewAgentRegistry(cfg *config.ServerConfig, log *zerolog.Logger) *AgentRegistry {
+	reportInterval := time.Duration(cfg.AgentReportInterval) * time.Second
+	if reportInterval <= 0 {
+		reportInterval = defaultAgentReportInterval
+	}
+	staleIntervals := cfg.AgentStaleIntervals
+	if staleIntervals <= 0 {
+		staleIntervals = defaultAgentStaleIntervals
+	}
+
+	log.Info().Str("func", "service.NewAgentRegistry").Msg("AgentRegistry successfully created")
+	return &AgentRegistry{
+		agents:         make(map[string]models.AgentInfo),
+		reportInterval: reportInterval,
+		staleIntervals: staleIntervals,
+		now:            time.Now,
+		mu:             &sync.RWMutex{},
+		log:            log,
+	}
+}
+
+// Register отмечает отправку метрик агентом. В agent.LastReport передается количество метрик в отправке
+func (a *AgentRegistry) Register(ctx context.Context, agent models.AgentInfo) error {
+	now := a.now()
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	known, ok := a.agents[agent.ID]
+	if !ok {
+		a.evict(now)
+		a.log.Info().Str("func", "*AgentRegistry.Register").Str("agent", agent.ID).Str("address", agent.Address).Msg("new agent registered")
+		known = models.AgentInfo{ID: agent.ID, FirstSeen: now}
+	} else if a.isStale(known, now) {
+		a.log.Info().Str("func", "*AgentRegistry.Register").Str("agent", agent.ID).Time("last seen", known.LastSeen).Msg("stale agent is reporting again")
+	}
+
+	known.Address = agent.Address
+	known.LastSeen = now
+	known.LastReport = agent.LastReport
+	known.MetricsCount += int64(agent.LastReport)
+	if agent.Version != "" {
+		known.Version = agent.Version
+	}
+	if agent.ReportInterval > 0 {
+		known.ReportInterval = agent.ReportInterval
+	}
+	a.agents[agent.ID] = known
+
+	return nil
+}
+
+// GetAll возвращает всех известных агентов, отсортированных по ID, с признаком Stale на текущий момент
+func (a *AgentRegistry) GetAll(ctx context.Context) ([]models.AgentInfo, error) {
+	now := a.now()
+
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+
+	agents := make([]models.AgentInfo, 0, len(a.agents))
+	for _, agent := range a.agents {
+		agent.Stale = a.isStale(agent, now)
+		agents = append(agents, agent)
+	}
+	slices.SortFunc(agents, func(x, y models.AgentInfo) int {
+		return strings.Compare(x.ID, y.ID)
+	})
+
+	return agents, nil
+}
+
+// evict удаляет давно не присылавших метрики агентов и освобождает место для нового, если реестр заполнен
+func (a *AgentRegistry) evict(now time.Time) {
+	if now.Sub(a.evicted) >= agentEvictInterval {
+		a.evicted = now
+		for id, agent := range a.agents {
+			if now.Sub(agent.LastSeen) > agentForgetAfter {
+				delete(a.agents, id)
+			}
+		}
+	}
+
+	for len(a.agents) >= maxAgents {
+		var oldest models.AgentInfo
+		for _, agent := range a.agents {
+			if oldest.ID == "" || agent.LastSeen.Before(oldest.LastSeen) {
+				oldest = agent
+			}
+		}
+		a.log.Warn().Str("func", "*AgentRegistry.evict").Str("agent", oldest.ID).Msg("agent registry is full: the least recently seen agent is dropped")
+		delete(a.agents, oldest.ID)
+	}
+}
+
+func (a *AgentRegistry) isStale(agent models.AgentInfo, now time.Time) bool {
+	interval := a.reportInterval
+	if agent.ReportInterval > 0 {
+		interval = time.Duration(agent.ReportInterval) * time.Second
+	}
+
+	return now.Sub(agent.LastSeen) > time.Duration(a.staleIntervals)*interval
+}
@@ -0,0 +1,107 @@
+package service
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAgentRegistry(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Logger()
+	ctx := context.Background()
+	registry := NewAgentRegistry(&config.ServerConfig{AgentReportInterval: 10, AgentStaleIntervals: 3}, &logger)
+
+	start := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	now := start
+	registry.now = func() time.Time { return now }
+
+	// web-01 reports every 2 seconds, web-02 uses server default of 10 seconds
+	require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: "web-01", Address: "10.0.0.1", Version: "1.1.0", ReportInterval: 2, LastReport: 30}))
+	require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: "web-02", Address: "10.0.0.2", LastReport: 5}))
+	now = start.Add(5 * time.Second)
+	require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: "web-01", Address: "10.0.0.3", LastReport: 31}))
+
+	tests := []struct {
+		name  string
+		after time.Duration
+		want  []models.AgentInfo
+	}{
+		{
+			name:  "all agents are alive",
+			after: 5 * time.Second,
+			want: []models.AgentInfo{
+				{ID: "web-01", Address: "10.0.0.3", Version: "1.1.0", FirstSeen: start, LastSeen: start.Add(5 * time.Second), ReportInterval: 2, LastReport: 31, MetricsCount: 61},
+				{ID: "web-02", Address: "10.0.0.2", FirstSeen: start, LastSeen: start, LastReport: 5, MetricsCount: 5},
+			},
+		},
+		{
+			name:  "agent missed 3 own report intervals",
+			after: 12 * time.Second,
+			want: []models.AgentInfo{
+				{ID: "web-01", Address: "10.0.0.3", Version: "1.1.0", FirstSeen: start, LastSeen: start.Add(5 * time.Second), ReportInterval: 2, LastReport: 31, MetricsCount: 61, Stale: true},
+				{ID: "web-02", Address: "10.0.0.2", FirstSeen: start, LastSeen: start, LastReport: 5, MetricsCount: 5},
+			},
+		},
+		{
+			name:  "agent missed 3 default report intervals",
+			after: 31 * time.Second,
+			want: []models.AgentInfo{
+				{ID: "web-01", Address: "10.0.0.3", Version: "1.1.0", FirstSeen: start, LastSeen: start.Add(5 * time.Second), ReportInterval: 2, LastReport: 31, MetricsCount: 61, Stale: true},
+				{ID: "web-02", Address: "10.0.0.2", FirstSeen: start, LastSeen: start, LastReport: 5, MetricsCount: 5, Stale: true},
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now = start.Add(test.after)
+			agents, err := registry.GetAll(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, test.want, agents)
+		})
+	}
+}
+
+func TestAgentRegistry_Evict(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+	registry := NewAgentRegistry(&config.ServerConfig{}, &logger)
+
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	registry.now = func() time.Time { return now }
+
+	require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: "gone"}))
+	now = now.Add(agentForgetAfter)
+	require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: "alive"}))
+	now = now.Add(time.Minute)
+
+	// agent that didn't report for too long is forgotten when a new one registers
+	require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: "new"}))
+	agents, err := registry.GetAll(ctx)
+	require.NoError(t, err)
+	require.Len(t, agents, 2)
+	assert.Equal(t, "alive", agents[0].ID)
+	assert.Equal(t, "new", agents[1].ID)
+
+	// registry size is limited: the least recently seen agent is dropped
+	for i := range maxAgents {
+		now = now.Add(time.Millisecond)
+		require.NoError(t, registry.Register(ctx, models.AgentInfo{ID: strconv.Itoa(i)}))
+	}
+	agents, err = registry.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, agents, maxAgents)
+	ids := make([]string, 0, len(agents))
+	for _, agent := range agents {
+		ids = append(ids, agent.ID)
+	}
+	assert.NotContains(t, ids, "alive")
+	assert.NotContains(t, ids, "new")
+}
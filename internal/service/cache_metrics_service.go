@@ -3,20 +3,44 @@ package service
 import (
 	"context"
 	"fmt"
+	"sync"
+	"time"
 
 	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/internal/utils"
 	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/rs/zerolog"
 )
 
+// maxLogSize размер журнала файлового хранилища, после которого он сжимается в снимок без ожидания storeInterval
+const maxLogSize = int64(4 << 20)
+
 type CacheMetricsService struct {
-	file          store.Storage // secondary chosen store.Storage provider - file
-	cache         store.Storage // primary chosen store.Storage provider - cache
-	storeInterval int64         // for file-storage. Only needed if file storage is provided
+	file          store.MetricsFileStorage // secondary chosen storage provider - file
+	cache         store.Storage            // primary chosen store.Storage provider - cache
+	storeInterval int64                    // for file-storage. Only needed if file storage is provided. 0 - compact file only by journal size
+	mu            *sync.Mutex              // orders cache updates with file journal and snapshots
 	log           *zerolog.Logger
 }
 
+// runSnapshotter раз в storeInterval секунд сжимает файловое хранилище в снимок кэша, пока ctx не завершен
+func (c *CacheMetricsService) runSnapshotter(ctx context.Context) {
+	if c.file == nil || c.storeInterval <= 0 {
+		return
+	}
+
+	c.log.Info().Str("func", "*CacheMetricsService.runSnapshotter").Int64("store interval", c.storeInterval).Msg("background snapshots to file are enabled")
+	utils.RunWithTicker(ctx, func() {
+		_ = c.Flush(ctx)
+	}, time.Duration(c.storeInterval)*time.Second)
+}
+
 func (c *CacheMetricsService) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	if c.file != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
 	// save metric in cache memory
 	result, err := c.cache.Save(ctx, metric)
 	if err != nil {
@@ -25,20 +49,9 @@ func (c *CacheMetricsService) Save(ctx context.Context, metric models.Metrics) (
 	}
 
 	// if file storage was provided
-	c.log.Debug().Str("func", "*CacheMetricsService.Save").Bool("file exists?", c.file != nil).Msg("checking if file exists")
 	if c.file != nil {
-		// get all metrics from Cache storage
-		allMetrics, err := c.cache.GetAll(ctx)
-		if err != nil {
-			c.log.Err(err).Str("func", "*CacheMetricsService.Save").Msg("error during getting all metrics from cache")
-			return models.Metrics{}, fmt.Errorf("error during getting all metrics from cache: %w", err)
-		}
-
-		// save all metrics to file
-		err = c.file.SaveAll(ctx, allMetrics)
-		if err != nil {
-			c.log.Err(err).Str("func", "*CacheMetricsService.Save").Msg("error during saving all metrics to file")
-			return models.Metrics{}, fmt.Errorf("error during saving all metrics to file: %w", err)
+		if err = c.appendToFile(ctx, result); err != nil {
+			return models.Metrics{}, err
 		}
 	}
 
@@ -46,29 +59,21 @@ func (c *CacheMetricsService) Save(ctx context.Context, metric models.Metrics) (
 }
 
 func (c *CacheMetricsService) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	if c.file != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
 	// save all metrics in cache memory
-	err := c.cache.SaveAll(ctx, metrics)
+	saved, err := store.SaveAllReturning(ctx, c.cache, metrics)
 	if err != nil {
 		c.log.Err(err).Str("func", "*CacheMetricsService.SaveAll").Msg("error during saving all metrics in cache")
 		return fmt.Errorf("error during saving all metrics in cache: %w", err)
 	}
 
-	// if file storage was provided
-	c.log.Debug().Str("func", "*CacheMetricsService.SaveAll").Bool("file exists?", c.file != nil).Msg("checking if file exists")
+	// if file storage was provided journal keeps resulting values of metrics
 	if c.file != nil {
-		// get all metrics from Cache storage
-		allMetrics, err := c.cache.GetAll(ctx)
-		if err != nil {
-			c.log.Err(err).Str("func", "*CacheMetricsService.SaveAll").Msg("error during getting all metrics from cache")
-			return fmt.Errorf("error during getting all metrics from cache: %w", err)
-		}
-
-		// save all provided metrics
-		err = c.file.SaveAll(ctx, allMetrics)
-		if err != nil {
-			c.log.Err(err).Str("func", "*CacheMetricsService.SaveAll").Msg("error during saving all metrics to file")
-			return fmt.Errorf("error during saving all metrics to file: %w", err)
-		}
+		return c.appendToFile(ctx, store.LastBySeries(saved)...)
 	}
 
 	return nil
@@ -81,3 +86,110 @@ func (c *CacheMetricsService) Get(ctx context.Context, metric models.Metrics) (m
 func (c *CacheMetricsService) GetAll(ctx context.Context) ([]models.Metrics, error) {
 	return c.cache.GetAll(ctx)
 }
+
+// Delete удаляет метрики из кэша. Файловое хранилище сразу сжимается в снимок без удаленных метрик
+func (c *CacheMetricsService) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	if c.file != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
+	deleted, err := c.cache.Delete(ctx, metrics)
+	if err != nil {
+		c.log.Err(err).Str("func", "*CacheMetricsService.Delete").Msg("error during deleting metrics from cache")
+		return 0, fmt.Errorf("error during deleting metrics from cache: %w", err)
+	}
+
+	if c.file != nil && deleted > 0 {
+		if err = c.compact(ctx); err != nil {
+			return 0, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// Purge удаляет из кэша все метрики, подходящие под фильтр. Файловое хранилище сразу сжимается в снимок без них
+func (c *CacheMetricsService) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	if c.file != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
+	purged, err := purgeMetrics(ctx, c.cache, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.file != nil && len(purged) > 0 {
+		if err = c.compact(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return purged, nil
+}
+
+// Expire удаляет из кэша метрики, которые давно не обновлялись. Файловое хранилище сразу сжимается в снимок без них
+func (c *CacheMetricsService) Expire(ctx context.Context, expired store.ExpiredFunc) ([]models.Metrics, error) {
+	if c.file != nil {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+	}
+
+	deleted, err := expireMetrics(ctx, c.cache, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.file != nil && len(deleted) > 0 {
+		if err = c.compact(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	return deleted, nil
+}
+
+// Flush сжимает файловое хранилище в снимок всех метрик из кэша (если файловое хранилище задано)
+func (c *CacheMetricsService) Flush(ctx context.Context) error {
+	if c.file == nil {
+		return nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.compact(ctx)
+}
+
+// appendToFile дописывает метрики в журнал файлового хранилища и сжимает его, если журнал слишком вырос.
+// Вызывается под c.mu
+func (c *CacheMetricsService) appendToFile(ctx context.Context, metrics ...models.Metrics) error {
+	if err := c.file.AppendMetricsToLog(ctx, metrics...); err != nil {
+		c.log.Err(err).Str("func", "*CacheMetricsService.appendToFile").Msg("error during appending metrics to file")
+		return fmt.Errorf("error during appending metrics to file: %w", err)
+	}
+
+	if c.file.LogSize() > maxLogSize {
+		return c.compact(ctx)
+	}
+	return nil
+}
+
+// compact записывает снимок кэша в файл. Вызывается под c.mu
+func (c *CacheMetricsService) compact(ctx context.Context) error {
+	allMetrics, err := c.cache.GetAll(ctx)
+	if err != nil {
+		c.log.Err(err).Str("func", "*CacheMetricsService.compact").Msg("error during getting all metrics from cache")
+		return fmt.Errorf("error during getting all metrics from cache: %w", err)
+	}
+
+	if err = c.file.SaveMetricsToFile(ctx, allMetrics); err != nil {
+		c.log.Err(err).Str("func", "*CacheMetricsService.compact").Msg("error during saving all metrics to file")
+		return fmt.Errorf("error during saving all metrics to file: %w", err)
+	}
+
+	c.log.Debug().Str("func", "*CacheMetricsService.compact").Int("metrics", len(allMetrics)).Msg("cache is saved to file")
+	return nil
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCacheMetricsService_StoreInterval(t *testing.T) {
+	logger := zerolog.Nop()
+	delta := int64(5)
+	value := 1.5
+	metrics := []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: &delta},
+		{ID: "PollCount", MType: models.Counter, Delta: &delta},
+		{ID: "Alloc", MType: models.Gauge, Value: &value},
+	}
+
+	tests := []struct {
+		name          string
+		storeInterval int64
+		wantCompacted bool // journal is compacted into snapshot in background
+	}{
+		{name: "synchronous saving", storeInterval: 0, wantCompacted: false},
+		{name: "background snapshots", storeInterval: 1, wantCompacted: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			cfg := &config.ServerConfig{
+				ServerAddress:          "localhost:8080",
+				StoreInterval:          test.storeInterval,
+				FileStoragePath:        t.TempDir(),
+				RestoreMetricsFromFile: true,
+			}
+			memStorage := store.NewMemStorage(&logger)
+			fileStorage, err := store.NewFileStorage(ctx, memStorage, cfg, &logger)
+			require.NoError(t, err)
+			metricsService, err := NewMetricsServiceBuilder(ctx, cfg, &logger).
+				WithCache(memStorage).
+				WithFile(fileStorage).
+				Build()
+			require.NoError(t, err)
+
+			_, err = metricsService.Save(ctx, metrics[0])
+			require.NoError(t, err)
+			require.NoError(t, metricsService.SaveAll(ctx, metrics[1:]))
+
+			// every update is in journal right away
+			require.Positive(t, fileStorage.LogSize())
+			saved, err := fileStorage.GetAll(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []models.Metrics{
+				{ID: "PollCount", MType: models.Counter, Delta: mDelta(10)},
+				{ID: "Alloc", MType: models.Gauge, Value: &value},
+			}, saved)
+
+			if test.wantCompacted {
+				assert.Eventually(t, func() bool {
+					return fileStorage.LogSize() == 0
+				}, 3*time.Second, 100*time.Millisecond)
+			}
+
+			// final flush compacts journal, restart restores metrics
+			require.NoError(t, metricsService.Flush(ctx))
+			assert.Zero(t, fileStorage.LogSize())
+			require.NoError(t, fileStorage.Close())
+
+			restored := store.NewMemStorage(&logger)
+			reopened, err := store.NewFileStorage(ctx, restored, cfg, &logger)
+			require.NoError(t, err)
+			defer reopened.Close()
+			assert.ElementsMatch(t, saved, restored.GetAllMetrics(ctx))
+		})
+	}
+}
+
+func TestCacheMetricsService_Delete(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+	value := 1.5
+
+	cfg := &config.ServerConfig{
+		ServerAddress:          "localhost:8080",
+		FileStoragePath:        t.TempDir(),
+		RestoreMetricsFromFile: true,
+	}
+	fileStorage, err := store.NewFileStorage(ctx, store.NewMemStorage(&logger), cfg, &logger)
+	require.NoError(t, err)
+	defer fileStorage.Close()
+	metricsService, err := NewMetricsServiceBuilder(ctx, cfg, &logger).
+		WithCache(store.NewMemStorage(&logger)).
+		WithFile(fileStorage).
+		Build()
+	require.NoError(t, err)
+
+	require.NoError(t, metricsService.SaveAll(ctx, []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+		{ID: "Alloc", MType: models.Gauge, Value: &value},
+		{ID: "test_gauge", MType: models.Gauge, Value: &value},
+	}))
+
+	deleted, err := metricsService.Delete(ctx, []models.Metrics{{ID: "PollCount", MType: models.Counter}})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	purged, err := metricsService.Purge(ctx, models.MetricsFilter{Prefix: "test_"})
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metrics{{ID: "test_gauge", MType: models.Gauge, Value: &value}}, purged)
+
+	// deleted metrics are not restored from file
+	saved, err := fileStorage.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metrics{{ID: "Alloc", MType: models.Gauge, Value: &value}}, saved)
+}
+
+func mDelta(v int64) *int64 {
+	return &v
+}
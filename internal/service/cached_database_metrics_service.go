@@ -0,0 +1,137 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+// CachedDatabaseMetricsService читает метрики из кэша в памяти, а записывает сразу в БД (write-through).
+// В кэш попадают значения, которые вернула БД, поэтому counter в кэше всегда равен сумме в БД.
+// Кэш, как и БД, различает метрики по типу: (X, counter) и (X, gauge) хранятся рядом
+type CachedDatabaseMetricsService struct {
+	db    store.Storage     // primary chosen store.Storage provider - db
+	cache *store.MemStorage // copy of db metrics for reading
+	mu    *sync.Mutex       // orders db writes with cache updates
+	log   *zerolog.Logger
+}
+
+// warmUp загружает в кэш все метрики из БД
+func (c *CachedDatabaseMetricsService) warmUp(ctx context.Context) error {
+	metrics, err := c.db.GetAll(ctx)
+	if err != nil {
+		c.log.Err(err).Str("func", "*CachedDatabaseMetricsService.warmUp").Msg("error loading metrics from db to cache")
+		return fmt.Errorf("error loading metrics from db to cache: %w", err)
+	}
+
+	c.cache.Reset(ctx, metrics)
+	c.log.Info().Str("func", "*CachedDatabaseMetricsService.warmUp").Int("metrics", len(metrics)).Msg("cache is warmed up")
+	return nil
+}
+
+func (c *CachedDatabaseMetricsService) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, err := c.db.Save(ctx, metric)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	c.cache.Set(ctx, result)
+	return result, nil
+}
+
+func (c *CachedDatabaseMetricsService) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// db returns resulting values of the batch (RETURNING), the last one of every series goes to cache
+	saved, err := store.SaveAllReturning(ctx, c.db, metrics)
+	if err != nil {
+		return err
+	}
+
+	c.cache.Set(ctx, store.LastBySeries(saved)...)
+	return nil
+}
+
+// Get возвращает метрику из кэша. Если в кэше ее нет (например, ее записал другой сервер), метрика читается из БД
+func (c *CachedDatabaseMetricsService) Get(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	found, err := c.cache.Get(ctx, metric)
+	if err == nil {
+		return found, nil
+	}
+
+	// under lock, so that older value from db does not overwrite concurrent save
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	found, err = c.db.Get(ctx, metric)
+	if err != nil {
+		if !errors.Is(err, store.ErrNotFound) {
+			c.log.Err(err).Str("func", "*CachedDatabaseMetricsService.Get").Any("metric", metric).Msg("error reading metric from db")
+		}
+		return models.Metrics{}, err
+	}
+
+	c.cache.Set(ctx, found)
+	return found, nil
+}
+
+// GetAll возвращает все метрики из кэша
+func (c *CachedDatabaseMetricsService) GetAll(ctx context.Context) ([]models.Metrics, error) {
+	return c.cache.GetAll(ctx)
+}
+
+// Delete удаляет метрики из БД, а затем из кэша
+func (c *CachedDatabaseMetricsService) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted, err := c.db.Delete(ctx, metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = c.cache.Delete(ctx, metrics)
+	return deleted, err
+}
+
+// Purge удаляет из БД все метрики, подходящие под фильтр, и убирает из кэша те, что действительно удалены
+func (c *CachedDatabaseMetricsService) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	purged, err := purgeMetrics(ctx, c.db, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.cache.Delete(ctx, purged)
+	return purged, err
+}
+
+// Expire удаляет из БД метрики, которые давно не обновлялись, и убирает их из кэша
+func (c *CachedDatabaseMetricsService) Expire(ctx context.Context, expired store.ExpiredFunc) ([]models.Metrics, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	deleted, err := expireMetrics(ctx, c.db, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = c.cache.Delete(ctx, deleted)
+	return deleted, err
+}
+
+// Flush ничего не делает: метрики записываются в БД сразу при сохранении
+func (c *CachedDatabaseMetricsService) Flush(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,113 @@
+package service
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCachedDatabaseMetricsService(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+	value := 1.5
+
+	cfg := &config.ServerConfig{
+		ServerAddress: "localhost:8080",
+		DatabaseDSN:   store.SQLiteScheme + path.Join(t.TempDir(), "metrics.db"),
+		DatabaseCache: true,
+	}
+	db, err := store.NewConnectSQLite(ctx, cfg, &logger)
+	require.NoError(t, err)
+	defer db.Close()
+
+	// metrics saved before start are loaded into cache
+	require.NoError(t, db.SaveAll(ctx, []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+		{ID: "Alloc", MType: models.Gauge, Value: &value},
+	}))
+
+	metricsService, err := NewMetricsServiceBuilder(ctx, cfg, &logger).
+		WithSQLite(db).
+		WithCache(store.NewMemStorage(&logger)).
+		Build()
+	require.NoError(t, err)
+	require.IsType(t, &CachedDatabaseMetricsService{}, metricsService)
+
+	all, err := metricsService.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Len(t, all, 2)
+
+	// reads are served from cache: direct db updates are not visible
+	_, err = db.Save(ctx, models.Metrics{ID: "PollCount", MType: models.Counter, Delta: mDelta(10)})
+	require.NoError(t, err)
+	got, err := metricsService.Get(ctx, models.Metrics{ID: "PollCount", MType: models.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(5), *got.Delta)
+
+	// writes go through db, counter in cache is the sum from db
+	saved, err := metricsService.Save(ctx, models.Metrics{ID: "PollCount", MType: models.Counter, Delta: mDelta(1)})
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), *saved.Delta)
+	got, err = metricsService.Get(ctx, models.Metrics{ID: "PollCount", MType: models.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(16), *got.Delta)
+
+	require.NoError(t, metricsService.SaveAll(ctx, []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(2)},
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(3)},
+		{ID: "Sys", MType: models.Counter, Delta: mDelta(7)},
+	}))
+	got, err = metricsService.Get(ctx, models.Metrics{ID: "PollCount", MType: models.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(21), *got.Delta)
+
+	// histograms in cache are merged in db
+	latency := models.Metrics{ID: "latency", MType: models.Histogram, Histogram: models.NewHistogramValue([]float64{1})}
+	latency.Histogram.Observe(0.5)
+	require.NoError(t, metricsService.SaveAll(ctx, []models.Metrics{latency, latency}))
+	got, err = metricsService.Get(ctx, models.Metrics{ID: "latency", MType: models.Histogram})
+	require.NoError(t, err)
+	assert.Equal(t, &models.HistogramValue{Bounds: []float64{1}, Counts: []uint64{2, 0}, Sum: 1, Count: 2}, got.Histogram)
+
+	// counter and gauge with the same name are different series in db and in cache
+	require.NoError(t, metricsService.SaveAll(ctx, []models.Metrics{{ID: "Alloc", MType: models.Counter, Delta: mDelta(4)}}))
+	got, err = metricsService.Get(ctx, models.Metrics{ID: "Alloc", MType: models.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(4), *got.Delta)
+	got, err = metricsService.Get(ctx, models.Metrics{ID: "Alloc", MType: models.Gauge})
+	require.NoError(t, err)
+	assert.Equal(t, value, *got.Value)
+
+	// metric missing in cache is read from db
+	_, err = db.Save(ctx, models.Metrics{ID: "Other", MType: models.Gauge, Value: &value})
+	require.NoError(t, err)
+	got, err = metricsService.Get(ctx, models.Metrics{ID: "Other", MType: models.Gauge})
+	require.NoError(t, err)
+	assert.Equal(t, value, *got.Value)
+
+	_, err = metricsService.Get(ctx, models.Metrics{ID: "Unknown", MType: models.Gauge})
+	assert.ErrorIs(t, err, store.ErrNotFound)
+
+	fromDB, err := db.GetAll(ctx)
+	require.NoError(t, err)
+	fromCache, err := metricsService.GetAll(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, fromDB, fromCache)
+
+	// warm up keeps all series too
+	restarted, err := NewMetricsServiceBuilder(ctx, cfg, &logger).
+		WithSQLite(db).
+		WithCache(store.NewMemStorage(&logger)).
+		Build()
+	require.NoError(t, err)
+	fromCache, err = restarted.GetAll(ctx)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, fromDB, fromCache)
+}
@@ -30,3 +30,23 @@ func (m *DatabaseMetricsService) Get(ctx context.Context, metric models.Metrics)
 func (m *DatabaseMetricsService) GetAll(ctx context.Context) ([]models.Metrics, error) {
 	return m.db.GetAll(ctx)
 }
+
+// Delete удаляет метрики из БД
+func (m *DatabaseMetricsService) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	return m.db.Delete(ctx, metrics)
+}
+
+// Purge удаляет все метрики, подходящие под фильтр
+func (m *DatabaseMetricsService) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	return purgeMetrics(ctx, m.db, filter)
+}
+
+// Expire удаляет из БД метрики, которые давно не обновлялись
+func (m *DatabaseMetricsService) Expire(ctx context.Context, expired store.ExpiredFunc) ([]models.Metrics, error) {
+	return expireMetrics(ctx, m.db, expired)
+}
+
+// Flush ничего не делает: метрики записываются в БД сразу при сохранении
+func (m *DatabaseMetricsService) Flush(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,8 @@
+package service
+
+import "errors"
+
+var (
+	ErrInvalidAggregation = errors.New("aggregation is not supported for metric type")
+	ErrInvalidTimeRange   = errors.New("time range is not valid")
+)
@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+var (
+	gaugeAggregations   = []string{models.AggregationLast, models.AggregationMin, models.AggregationMax, models.AggregationAvg}
+	counterAggregations = []string{models.AggregationIncrease, models.AggregationRate, models.AggregationLast}
+)
+
+type HistoryMetricsService struct {
+	history store.MetricsHistoryStorage
+	log     *zerolog.Logger
+}
+
+func NewHistoryMetricsService(history store.MetricsHistoryStorage, log *zerolog.Logger) MetricsHistoryService {
+	return &HistoryMetricsService{
+		history: history,
+		log:     log,
+	}
+}
+
+func (h *HistoryMetricsService) GetHistory(ctx context.Context, query models.HistoryQuery) (models.MetricHistory, error) {
+	if query.To.Before(query.From) || query.Step < 0 {
+		h.log.Error().Str("func", "*HistoryMetricsService.GetHistory").Any("query", query).Msg("time range is not valid")
+		return models.MetricHistory{}, ErrInvalidTimeRange
+	}
+
+	// check aggregation before going to storage
+	aggregation, err := h.getAggregation(query)
+	if err != nil {
+		h.log.Err(err).Str("func", "*HistoryMetricsService.GetHistory").Any("query", query).Msg("aggregation is not valid")
+		return models.MetricHistory{}, err
+	}
+
+	samples, err := h.history.GetSamples(ctx, query.Metric, query.From, query.To)
+	if err != nil {
+		h.log.Err(err).Str("func", "*HistoryMetricsService.GetHistory").Any("query", query).Msg("error during getting metric samples")
+		return models.MetricHistory{}, fmt.Errorf("error during getting metric samples: %w", err)
+	}
+
+	result := models.MetricHistory{
+		ID:      query.Metric.ID,
+		MType:   query.Metric.MType,
+		Labels:  query.Metric.Labels,
+		From:    query.From,
+		To:      query.To,
+		Samples: samples,
+	}
+
+	// no step - return raw samples
+	if query.Step == 0 {
+		return result, nil
+	}
+
+	result.Step = int64(query.Step.Seconds())
+	result.Aggregation = aggregation
+	result.Samples = downsample(samples, query.From, query.Step, aggregation)
+
+	return result, nil
+}
+
+func (h *HistoryMetricsService) getAggregation(query models.HistoryQuery) (string, error) {
+	allowed := gaugeAggregations
+	if query.Metric.MType == models.Counter {
+		allowed = counterAggregations
+	}
+
+	// first allowed aggregation is the default one
+	if query.Aggregation == "" {
+		return allowed[0], nil
+	}
+	if !slices.Contains(allowed, query.Aggregation) {
+		return "", ErrInvalidAggregation
+	}
+
+	return query.Aggregation, nil
+}
+
+// downsample splits samples in buckets [from + k*step, from + (k+1)*step) and aggregates every non-empty bucket.
+// Timestamp of resulting sample is the start of the bucket
+func downsample(samples []models.MetricSample, from time.Time, step time.Duration, aggregation string) []models.MetricSample {
+	result := make([]models.MetricSample, 0)
+
+	// for counters: last value before the current bucket
+	var previous *models.MetricSample
+	for start := 0; start < len(samples); {
+		bucket := samples[start].Timestamp.Sub(from) / step
+		bucketStart := from.Add(bucket * step)
+
+		end := start
+		for end < len(samples) && samples[end].Timestamp.Sub(from)/step == bucket {
+			end++
+		}
+
+		result = append(result, aggregate(samples[start:end], previous, bucketStart, step, aggregation))
+		previous = &samples[end-1]
+		start = end
+	}
+
+	return result
+}
+
+func aggregate(bucket []models.MetricSample, previous *models.MetricSample, timestamp time.Time, step time.Duration, aggregation string) models.MetricSample {
+	last := bucket[len(bucket)-1]
+
+	switch aggregation {
+	case models.AggregationMin, models.AggregationMax, models.AggregationAvg:
+		var minValue, maxValue, sum float64
+		var count int
+		for _, sample := range bucket {
+			if sample.Value == nil {
+				continue
+			}
+			if count == 0 || *sample.Value < minValue {
+				minValue = *sample.Value
+			}
+			if count == 0 || *sample.Value > maxValue {
+				maxValue = *sample.Value
+			}
+			sum += *sample.Value
+			count++
+		}
+		if count == 0 {
+			return models.MetricSample{Timestamp: timestamp}
+		}
+
+		value := sum / float64(count)
+		switch aggregation {
+		case models.AggregationMin:
+			value = minValue
+		case models.AggregationMax:
+			value = maxValue
+		}
+		return models.MetricSample{Timestamp: timestamp, Value: &value}
+
+	case models.AggregationIncrease, models.AggregationRate:
+		// counter values are cumulative: compare with the last value before the bucket
+		// or with the first value in the bucket if there is no previous one
+		baseline := bucket[0]
+		if previous != nil {
+			baseline = *previous
+		}
+		if last.Delta == nil || baseline.Delta == nil {
+			return models.MetricSample{Timestamp: timestamp}
+		}
+
+		increase := *last.Delta - *baseline.Delta
+		// counter was reset (metric was recreated) - count from zero
+		if increase < 0 {
+			increase = *last.Delta
+		}
+
+		if aggregation == models.AggregationRate {
+			rate := float64(increase) / step.Seconds()
+			return models.MetricSample{Timestamp: timestamp, Value: &rate}
+		}
+		return models.MetricSample{Timestamp: timestamp, Delta: &increase}
+
+	default:
+		return models.MetricSample{Timestamp: timestamp, Delta: last.Delta, Value: last.Value}
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryMetricsService_GetHistory(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Logger()
+	ctx := context.Background()
+	history := store.NewMemHistoryStorage(&logger)
+	historyService := NewHistoryMetricsService(history, &logger)
+
+	from := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	gauge := models.Metrics{ID: "HeapAlloc", MType: models.Gauge}
+	counter := models.Metrics{ID: "PollCount", MType: models.Counter}
+
+	// two buckets of 10 seconds
+	for i, value := range []float64{4, 2, 6, 10, 20} {
+		v := value
+		d := int64(10 * (i + 1))
+		timestamp := from.Add(time.Duration(i*4) * time.Second) // 0s, 4s, 8s, 12s, 16s
+		require.NoError(t, history.AddSamples(ctx, timestamp,
+			models.Metrics{ID: gauge.ID, MType: gauge.MType, Value: &v},
+			models.Metrics{ID: counter.ID, MType: counter.MType, Delta: &d},
+		))
+	}
+
+	tests := []struct {
+		name   string
+		query  models.HistoryQuery
+		want   []float64
+		wantIn []int64
+		err    error
+	}{
+		{name: "gauge raw samples", query: models.HistoryQuery{Metric: gauge}, want: []float64{4, 2, 6, 10, 20}},
+		{name: "gauge min", query: models.HistoryQuery{Metric: gauge, Step: 10 * time.Second, Aggregation: models.AggregationMin}, want: []float64{2, 10}},
+		{name: "gauge max", query: models.HistoryQuery{Metric: gauge, Step: 10 * time.Second, Aggregation: models.AggregationMax}, want: []float64{6, 20}},
+		{name: "gauge avg", query: models.HistoryQuery{Metric: gauge, Step: 10 * time.Second, Aggregation: models.AggregationAvg}, want: []float64{4, 15}},
+		{name: "gauge last by default", query: models.HistoryQuery{Metric: gauge, Step: 10 * time.Second}, want: []float64{6, 20}},
+		{name: "counter increase by default", query: models.HistoryQuery{Metric: counter, Step: 10 * time.Second}, wantIn: []int64{20, 20}},
+		{name: "counter rate", query: models.HistoryQuery{Metric: counter, Step: 10 * time.Second, Aggregation: models.AggregationRate}, want: []float64{2, 2}},
+		{name: "rate is not allowed for gauge", query: models.HistoryQuery{Metric: gauge, Step: 10 * time.Second, Aggregation: models.AggregationRate}, err: ErrInvalidAggregation},
+		{name: "avg is not allowed for counter", query: models.HistoryQuery{Metric: counter, Step: 10 * time.Second, Aggregation: models.AggregationAvg}, err: ErrInvalidAggregation},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.query.From = from
+			test.query.To = from.Add(time.Minute)
+
+			result, err := historyService.GetHistory(ctx, test.query)
+			if test.err != nil {
+				assert.ErrorIs(t, err, test.err)
+				return
+			}
+			require.NoError(t, err)
+
+			if test.wantIn != nil {
+				require.Len(t, result.Samples, len(test.wantIn))
+				for i, sample := range result.Samples {
+					assert.Equal(t, test.wantIn[i], *sample.Delta)
+				}
+				return
+			}
+			require.Len(t, result.Samples, len(test.want))
+			for i, sample := range result.Samples {
+				assert.Equal(t, test.want[i], *sample.Value)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package service
 import (
 	"context"
 
+	"github.com/MKhiriev/stunning-adventure/internal/store"
 	"github.com/MKhiriev/stunning-adventure/models"
 )
 
@@ -11,6 +12,19 @@ type MetricsService interface {
 	SaveAll(context.Context, []models.Metrics) error
 	Get(context.Context, models.Metrics) (models.Metrics, error)
 	GetAll(context.Context) ([]models.Metrics, error)
+	Delete(context.Context, []models.Metrics) (int, error)
+	Purge(context.Context, models.MetricsFilter) ([]models.Metrics, error)
+	Expire(context.Context, store.ExpiredFunc) ([]models.Metrics, error)
+	Flush(context.Context) error
+}
+
+type MetricsHistoryService interface {
+	GetHistory(context.Context, models.HistoryQuery) (models.MetricHistory, error)
+}
+
+type AgentRegistryService interface {
+	Register(context.Context, models.AgentInfo) error
+	GetAll(context.Context) ([]models.AgentInfo, error)
 }
 
 type PingService interface {
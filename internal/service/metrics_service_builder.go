@@ -1,8 +1,10 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"sync"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
 	"github.com/MKhiriev/stunning-adventure/internal/store"
@@ -13,13 +15,18 @@ type MetricsServiceBuilder struct {
 	wrappers     []MetricsServiceWrapper
 	fileStorage  *store.FileStorage
 	dbStorage    *store.DB
+	sqliteDB     *store.SQLiteDB
+	boltStorage  *store.BoltStorage
 	cacheStorage *store.MemStorage
+	history      store.MetricsHistoryStorage
+	ctx          context.Context // background jobs of the service stop when ctx is done
 	cfg          *config.ServerConfig
 	log          *zerolog.Logger
 }
 
-func NewMetricsServiceBuilder(cfg *config.ServerConfig, log *zerolog.Logger) *MetricsServiceBuilder {
+func NewMetricsServiceBuilder(ctx context.Context, cfg *config.ServerConfig, log *zerolog.Logger) *MetricsServiceBuilder {
 	return &MetricsServiceBuilder{
+		ctx: ctx,
 		cfg: cfg,
 		log: log,
 	}
@@ -30,6 +37,17 @@ func (b *MetricsServiceBuilder) WithDB(db *store.DB) *MetricsServiceBuilder {
 	return b
 }
 
+// WithSQLite add SQLite storage, it is used instead of PostgreSQL if DSN has `sqlite://` scheme
+func (b *MetricsServiceBuilder) WithSQLite(db *store.SQLiteDB) *MetricsServiceBuilder {
+	b.sqliteDB = db
+	return b
+}
+
+func (b *MetricsServiceBuilder) WithBolt(bolt *store.BoltStorage) *MetricsServiceBuilder {
+	b.boltStorage = bolt
+	return b
+}
+
 func (b *MetricsServiceBuilder) WithFile(file *store.FileStorage) *MetricsServiceBuilder {
 	b.fileStorage = file
 	return b
@@ -40,6 +58,12 @@ func (b *MetricsServiceBuilder) WithCache(cache *store.MemStorage) *MetricsServi
 	return b
 }
 
+// WithHistory add storage for metric history. Every save in main storage adds a sample to history
+func (b *MetricsServiceBuilder) WithHistory(history store.MetricsHistoryStorage) *MetricsServiceBuilder {
+	b.history = history
+	return b
+}
+
 // WithWrapper add wrappers
 func (b *MetricsServiceBuilder) WithWrapper(wrapperService MetricsServiceWrapper) *MetricsServiceBuilder {
 	b.wrappers = append(b.wrappers, wrapperService)
@@ -68,16 +92,31 @@ func (b *MetricsServiceBuilder) Build() (MetricsService, error) {
 
 func (b *MetricsServiceBuilder) buildMetricsService() (MetricsService, error) {
 	// DB - high priority
+	if store.IsSQLiteDSN(b.cfg.DatabaseDSN) {
+		if b.sqliteDB == nil {
+			b.log.Error().Msg("SQLite storage is nil")
+			return nil, errors.New("sqlite storage is nil")
+		}
+		b.log.Info().Str("func", "MetricsServiceBuilder.buildMetricsService").Msg("DatabaseMetricsService with SQLite created")
+		return b.buildDatabaseService(b.sqliteDB)
+	}
 	if b.cfg.DatabaseDSN != "" {
 		if b.dbStorage == nil {
 			b.log.Error().Msg("DB storage is nil")
 			return nil, errors.New("db storage is nil")
 		}
 		b.log.Info().Str("func", "MetricsServiceBuilder.buildMetricsService").Msg("DatabaseMetricsService created")
-		return &DatabaseMetricsService{
-			db:  b.dbStorage,
-			log: b.log,
-		}, nil
+		return b.buildDatabaseService(b.dbStorage)
+	}
+
+	// Embedded bolt database - metrics are saved on disk directly, like in DB
+	if b.cfg.BoltPath != "" {
+		if b.boltStorage == nil {
+			b.log.Error().Msg("Bolt storage is nil")
+			return nil, errors.New("bolt storage is nil")
+		}
+		b.log.Info().Str("func", "MetricsServiceBuilder.buildMetricsService").Msg("DatabaseMetricsService with Bolt created")
+		return b.buildDatabaseService(b.boltStorage)
 	}
 
 	// File + Cache
@@ -91,23 +130,65 @@ func (b *MetricsServiceBuilder) buildMetricsService() (MetricsService, error) {
 			return nil, errors.New("cache storage is nil")
 		}
 		b.log.Info().Str("func", "MetricsServiceBuilder.buildMetricsService").Msg("CacheMetricsService with File created")
-		return &CacheMetricsService{
-			cache:         b.cacheStorage,
+		service := &CacheMetricsService{
+			cache:         b.withHistory(b.cacheStorage),
 			file:          b.fileStorage,
 			log:           b.log,
 			storeInterval: b.cfg.StoreInterval,
-		}, nil
+			mu:            &sync.Mutex{},
+		}
+		service.runSnapshotter(b.ctx)
+		return service, nil
 	}
 
 	// Cache only
 	if b.cacheStorage != nil {
 		b.log.Info().Str("func", "MetricsServiceBuilder.buildMetricsService").Msg("CacheMetricsService created")
 		return &CacheMetricsService{
-			cache: b.cacheStorage,
+			cache: b.withHistory(b.cacheStorage),
 			log:   b.log,
+			mu:    &sync.Mutex{},
 		}, nil
 	}
 
 	b.log.Error().Str("func", "MetricsServiceBuilder.buildMetricsService").Msg("nil storage was provided")
 	return nil, errors.New("no valid storage provided")
 }
+
+// buildDatabaseService creates service over database storage. If database cache is enabled,
+// metrics are read from cache storage and written through to database
+func (b *MetricsServiceBuilder) buildDatabaseService(db store.Storage) (MetricsService, error) {
+	if !b.cfg.DatabaseCache {
+		return &DatabaseMetricsService{
+			db:  b.withHistory(db),
+			log: b.log,
+		}, nil
+	}
+
+	if b.cacheStorage == nil {
+		b.log.Error().Msg("Cache storage is nil")
+		return nil, errors.New("cache storage is nil")
+	}
+	service := &CachedDatabaseMetricsService{
+		db:    b.withHistory(db),
+		cache: b.cacheStorage,
+		mu:    &sync.Mutex{},
+		log:   b.log,
+	}
+	if err := service.warmUp(b.ctx); err != nil {
+		return nil, err
+	}
+
+	b.log.Info().Str("func", "MetricsServiceBuilder.buildDatabaseService").Msg("database cache is enabled")
+	return service, nil
+}
+
+// withHistory wraps main storage so that every save is recorded in history (if history storage was provided)
+func (b *MetricsServiceBuilder) withHistory(storage store.Storage) store.Storage {
+	if b.history == nil {
+		return storage
+	}
+
+	b.log.Info().Str("func", "MetricsServiceBuilder.withHistory").Msg("metric history is enabled")
+	return store.NewHistoryStorage(storage, b.history, b.log)
+}
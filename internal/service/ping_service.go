@@ -4,26 +4,30 @@ import (
 	"context"
 	"errors"
 
-	"github.com/MKhiriev/stunning-adventure/internal/store"
 	"github.com/rs/zerolog"
 )
 
+// DBPinger соединение с БД: *store.DB или *store.SQLiteDB
+type DBPinger interface {
+	PingContext(ctx context.Context) error
+}
+
 type PingDBService struct {
-	*store.DB
+	db  DBPinger
 	log *zerolog.Logger
 }
 
 func (p *PingDBService) Ping(ctx context.Context) error {
-	if p.DB != nil {
+	if p.db != nil {
 		p.log.Info().Str("func", "*PingDBService.Ping").Msg("Ping to DB sent")
-		return p.DB.PingContext(ctx)
+		return p.db.PingContext(ctx)
 	}
 
 	p.log.Error().Str("func", "*PingDBService.Ping").Msg("error during DB ping: db pointer is nil")
 	return errors.New("DB connection is nil")
 }
 
-func NewPingDBService(db *store.DB, log *zerolog.Logger) (PingService, error) {
+func NewPingDBService(db DBPinger, log *zerolog.Logger) (PingService, error) {
 	log.Info().Str("func", "service.NewPingDBService").Msg("PingDBService successfully created")
-	return &PingDBService{DB: db, log: log}, nil
+	return &PingDBService{db: db, log: log}, nil
 }
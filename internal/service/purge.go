@@ -0,0 +1,26 @@
+package service
+
+import (
+	"context"
+
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+// purgeMetrics удаляет метрики, подходящие под фильтр, средствами хранилища и возвращает те, что оно действительно удалило
+func purgeMetrics(ctx context.Context, storage store.Storage, filter models.MetricsFilter) ([]models.Metrics, error) {
+	purger, ok := storage.(store.MetricsPurgeStorage)
+	if !ok {
+		return nil, store.ErrPurgeNotSupported
+	}
+	return purger.Purge(ctx, filter)
+}
+
+// expireMetrics удаляет устаревшие метрики, если хранилище помнит время их обновления
+func expireMetrics(ctx context.Context, storage store.Storage, expired store.ExpiredFunc) ([]models.Metrics, error) {
+	retention, ok := storage.(store.MetricsRetentionStorage)
+	if !ok {
+		return nil, store.ErrRetentionNotSupported
+	}
+	return retention.DeleteExpired(ctx, expired)
+}
@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/internal/utils"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+const defaultRetentionInterval = time.Minute
+
+// RetentionRule срок хранения метрик, имя которых подходит под Pattern. TTL 0 - метрики хранятся всегда
+type RetentionRule struct {
+	Pattern *regexp.Regexp
+	TTL     time.Duration
+}
+
+// RetentionPolicy срок хранения метрик: правило первого подходящего шаблона, иначе общий TTL.
+// Метрика удаляется, если не обновлялась дольше своего срока
+type RetentionPolicy struct {
+	ttl   time.Duration
+	rules []RetentionRule
+}
+
+func NewRetentionPolicy(cfg *config.ServerConfig) (*RetentionPolicy, error) {
+	rules, err := ParseRetentionRules(cfg.RetentionRules)
+	if err != nil {
+		return nil, err
+	}
+
+	return &RetentionPolicy{
+		ttl:   time.Duration(cfg.RetentionTTL) * time.Second,
+		rules: rules,
+	}, nil
+}
+
+// ParseRetentionRules разбирает правила вида `regex=ttl;regex2=ttl2`, ttl в секундах
+func ParseRetentionRules(s string) ([]RetentionRule, error) {
+	var rules []RetentionRule
+	for _, rule := range strings.Split(s, ";") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+
+		// regex may contain `=`, ttl can't
+		idx := strings.LastIndex(rule, "=")
+		if idx <= 0 {
+			return nil, fmt.Errorf("invalid retention rule %q, expected regex=ttl", rule)
+		}
+		pattern, err := regexp.Compile(rule[:idx])
+		if err != nil {
+			return nil, fmt.Errorf("invalid retention rule %q: %w", rule, err)
+		}
+		ttl, err := strconv.ParseInt(strings.TrimSpace(rule[idx+1:]), 10, 64)
+		if err != nil || ttl < 0 {
+			return nil, fmt.Errorf("invalid retention rule %q: ttl must be a non-negative number of seconds", rule)
+		}
+
+		rules = append(rules, RetentionRule{Pattern: pattern, TTL: time.Duration(ttl) * time.Second})
+	}
+
+	return rules, nil
+}
+
+// Enabled есть ли метрики, которые могут устареть
+func (p *RetentionPolicy) Enabled() bool {
+	if p.ttl > 0 {
+		return true
+	}
+	for _, rule := range p.rules {
+		if rule.TTL > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// TTL срок хранения метрики, 0 - хранить всегда
+func (p *RetentionPolicy) TTL(metric models.Metrics) time.Duration {
+	for _, rule := range p.rules {
+		if rule.Pattern.MatchString(metric.ID) {
+			return rule.TTL
+		}
+	}
+	return p.ttl
+}
+
+// Expired возвращает проверку, устарела ли метрика к моменту now
+func (p *RetentionPolicy) Expired(now time.Time) store.ExpiredFunc {
+	return func(metric models.Metrics, updatedAt time.Time) bool {
+		ttl := p.TTL(metric)
+		return ttl > 0 && now.Sub(updatedAt) > ttl
+	}
+}
+
+// RetentionJanitor периодически удаляет метрики, которые не обновлялись дольше срока хранения
+type RetentionJanitor struct {
+	metricsService MetricsService
+	policy         *RetentionPolicy
+	interval       time.Duration
+	now            func() time.Time
+	log            *zerolog.Logger
+}
+
+func NewRetentionJanitor(metricsService MetricsService, policy *RetentionPolicy, cfg *config.ServerConfig, log *zerolog.Logger) *RetentionJanitor {
+	interval := time.Duration(cfg.RetentionInterval) * time.Second
+	if interval <= 0 {
+		interval = defaultRetentionInterval
+	}
+
+	return &RetentionJanitor{
+		metricsService: metricsService,
+		policy:         policy,
+		interval:       interval,
+		now:            time.Now,
+		log:            log,
+	}
+}
+
+// Run удаляет устаревшие метрики раз в interval, пока ctx не завершен
+func (j *RetentionJanitor) Run(ctx context.Context) {
+	j.log.Info().Str("func", "*RetentionJanitor.Run").Dur("interval", j.interval).Msg("retention janitor is started")
+	utils.RunWithTicker(ctx, func() {
+		_, _ = j.Clean(ctx)
+	}, j.interval)
+}
+
+// Clean удаляет устаревшие метрики и возвращает их
+func (j *RetentionJanitor) Clean(ctx context.Context) ([]models.Metrics, error) {
+	expired, err := j.metricsService.Expire(ctx, j.policy.Expired(j.now()))
+	if err != nil {
+		j.log.Err(err).Str("func", "*RetentionJanitor.Clean").Msg("error during deleting expired metrics")
+		return nil, err
+	}
+
+	if len(expired) > 0 {
+		j.log.Info().Str("func", "*RetentionJanitor.Clean").Int("metrics", len(expired)).Msg("expired metrics are deleted")
+	}
+	return expired, nil
+}
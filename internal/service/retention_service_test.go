@@ -0,0 +1,163 @@
+package service
+
+import (
+	"context"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/store"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetentionRules(t *testing.T) {
+	tests := []struct {
+		name    string
+		rules   string
+		want    map[string]time.Duration // pattern -> ttl
+		wantErr bool
+	}{
+		{name: "empty", rules: "", want: map[string]time.Duration{}},
+		{
+			name:  "several rules",
+			rules: "^container_=300; ^tmp_.*=60;^keep$=0;",
+			want:  map[string]time.Duration{"^container_": 5 * time.Minute, "^tmp_.*": time.Minute, "^keep$": 0},
+		},
+		{name: "regex with equals sign", rules: "a=b=10", want: map[string]time.Duration{"a=b": 10 * time.Second}},
+		{name: "no ttl", rules: "^tmp_", wantErr: true},
+		{name: "negative ttl", rules: "^tmp_=-1", wantErr: true},
+		{name: "invalid regex", rules: "(tmp=10", wantErr: true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rules, err := ParseRetentionRules(test.rules)
+			if test.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			got := make(map[string]time.Duration, len(rules))
+			for _, rule := range rules {
+				got[rule.Pattern.String()] = rule.TTL
+			}
+			assert.Equal(t, test.want, got)
+		})
+	}
+}
+
+func TestRetentionPolicy_Expired(t *testing.T) {
+	policy, err := NewRetentionPolicy(&config.ServerConfig{RetentionTTL: 3600, RetentionRules: "^tmp_=60;^keep_=0"})
+	require.NoError(t, err)
+	require.True(t, policy.Enabled())
+
+	now := time.Now()
+	expired := policy.Expired(now)
+	tests := []struct {
+		id      string
+		age     time.Duration
+		expired bool
+	}{
+		{id: "Alloc", age: 30 * time.Minute, expired: false},
+		{id: "Alloc", age: 2 * time.Hour, expired: true},
+		{id: "tmp_gauge", age: 30 * time.Second, expired: false},
+		{id: "tmp_gauge", age: 2 * time.Minute, expired: true},
+		{id: "keep_gauge", age: 24 * time.Hour, expired: false},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expired, expired(models.Metrics{ID: test.id}, now.Add(-test.age)), "%s updated %s ago", test.id, test.age)
+	}
+
+	policy, err = NewRetentionPolicy(&config.ServerConfig{RetentionRules: "^keep_=0"})
+	require.NoError(t, err)
+	assert.False(t, policy.Enabled())
+}
+
+func TestRetentionJanitor(t *testing.T) {
+	logger := zerolog.Nop()
+	value := 1.5
+
+	tests := []struct {
+		name  string
+		build func(t *testing.T, ctx context.Context) MetricsService
+	}{
+		{
+			name: "cache with file",
+			build: func(t *testing.T, ctx context.Context) MetricsService {
+				cfg := &config.ServerConfig{FileStoragePath: t.TempDir(), RestoreMetricsFromFile: true}
+				memStorage := store.NewMemStorage(&logger)
+				fileStorage, err := store.NewFileStorage(ctx, memStorage, cfg, &logger)
+				require.NoError(t, err)
+				t.Cleanup(func() { fileStorage.Close() })
+				metricsService, err := NewMetricsServiceBuilder(ctx, cfg, &logger).WithCache(memStorage).WithFile(fileStorage).Build()
+				require.NoError(t, err)
+				return metricsService
+			},
+		},
+		{
+			name: "sqlite with cache and history",
+			build: func(t *testing.T, ctx context.Context) MetricsService {
+				cfg := &config.ServerConfig{DatabaseDSN: store.SQLiteScheme + path.Join(t.TempDir(), "metrics.db"), DatabaseCache: true}
+				db, err := store.NewConnectSQLite(ctx, cfg, &logger)
+				require.NoError(t, err)
+				t.Cleanup(func() { db.Close() })
+				metricsService, err := NewMetricsServiceBuilder(ctx, cfg, &logger).
+					WithSQLite(db).
+					WithCache(store.NewMemStorage(&logger)).
+					WithHistory(store.NewMemHistoryStorage(&logger)).
+					Build()
+				require.NoError(t, err)
+				return metricsService
+			},
+		},
+		{
+			name: "bolt",
+			build: func(t *testing.T, ctx context.Context) MetricsService {
+				cfg := &config.ServerConfig{BoltPath: path.Join(t.TempDir(), "metrics.db")}
+				bolt, err := store.NewBoltStorage(cfg, &logger)
+				require.NoError(t, err)
+				t.Cleanup(func() { bolt.Close() })
+				metricsService, err := NewMetricsServiceBuilder(ctx, cfg, &logger).WithBolt(bolt).Build()
+				require.NoError(t, err)
+				return metricsService
+			},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			metricsService := test.build(t, ctx)
+			require.NoError(t, metricsService.SaveAll(ctx, []models.Metrics{
+				{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+				{ID: "Alloc", MType: models.Gauge, Value: &value},
+				{ID: "keep_gauge", MType: models.Gauge, Value: &value},
+			}))
+
+			cfg := &config.ServerConfig{RetentionTTL: 3600, RetentionRules: "^keep_=0"}
+			policy, err := NewRetentionPolicy(cfg)
+			require.NoError(t, err)
+			janitor := NewRetentionJanitor(metricsService, policy, cfg, &logger)
+
+			// fresh metrics are kept
+			expired, err := janitor.Clean(ctx)
+			require.NoError(t, err)
+			assert.Empty(t, expired)
+
+			janitor.now = func() time.Time { return time.Now().Add(2 * time.Hour) }
+			expired, err = janitor.Clean(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, []models.Metrics{
+				{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+				{ID: "Alloc", MType: models.Gauge, Value: &value},
+			}, expired)
+
+			left, err := metricsService.GetAll(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, []models.Metrics{{ID: "keep_gauge", MType: models.Gauge, Value: &value}}, left)
+		})
+	}
+}
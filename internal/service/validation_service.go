@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/MKhiriev/stunning-adventure/internal/store"
 	"github.com/MKhiriev/stunning-adventure/internal/validators"
 	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/rs/zerolog"
@@ -68,6 +69,38 @@ func (v *ValidatingMetricsService) GetAll(ctx context.Context) ([]models.Metrics
 	return v.inner.GetAll(ctx)
 }
 
+func (v *ValidatingMetricsService) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	v.log.Info().Str("func", "*ValidatingMetricsService.Delete").Any("metrics", metrics).Msg("validation before Delete() started")
+
+	for _, metric := range metrics {
+		if err := v.validator.Validate(ctx, metric, validators.ID, validators.MType, validators.Labels); err != nil {
+			v.log.Info().Str("func", "*ValidatingMetricsService.Delete").Any("metrics", metric).Msg("metric is not valid")
+			return 0, fmt.Errorf("error during metric validation before deleting: %w", err)
+		}
+	}
+
+	return v.inner.Delete(ctx, metrics)
+}
+
+func (v *ValidatingMetricsService) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	v.log.Info().Str("func", "*ValidatingMetricsService.Purge").Any("filter", filter).Msg("validation before Purge() started")
+
+	if err := v.validator.Validate(ctx, filter); err != nil {
+		v.log.Info().Str("func", "*ValidatingMetricsService.Purge").Any("filter", filter).Msg("filter is not valid")
+		return nil, fmt.Errorf("error during filter validation before purging: %w", err)
+	}
+
+	return v.inner.Purge(ctx, filter)
+}
+
+func (v *ValidatingMetricsService) Expire(ctx context.Context, expired store.ExpiredFunc) ([]models.Metrics, error) {
+	return v.inner.Expire(ctx, expired)
+}
+
+func (v *ValidatingMetricsService) Flush(ctx context.Context) error {
+	return v.inner.Flush(ctx)
+}
+
 func (v *ValidatingMetricsService) Wrap(wrapper MetricsService) MetricsService {
 	v.log.Info().Str("func", "*ValidatingMetricsService.Wrap").Msg("wrapping a service")
 	v.inner = wrapper
@@ -0,0 +1,342 @@
+// Package sketch содержит объединяемые вероятностные структуры для агрегирования метрик
+// на агентах и сервере
+package sketch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultRelativeAccuracy относительная ошибка квантилей по умолчанию - 1%
+	DefaultRelativeAccuracy = 0.01
+	// minIndexableValue значения по модулю меньше попадают в нулевую корзину, это ограничивает число корзин
+	minIndexableValue = 1e-9
+
+	ddSketchVersion = 1
+)
+
+var (
+	ErrInvalidAccuracy  = errors.New("relative accuracy must be in (0, 1)")
+	ErrInvalidValue     = errors.New("value must be a finite number")
+	ErrInvalidQuantile  = errors.New("quantile must be in [0, 1]")
+	ErrEmptySketch      = errors.New("sketch is empty")
+	ErrAccuracyMismatch = errors.New("sketches with different relative accuracy can't be merged")
+	ErrMalformedSketch  = errors.New("malformed sketch")
+)
+
+// DefaultQuantiles квантили, которые показываются, если не запрошены другие
+var DefaultQuantiles = []float64{0.5, 0.9, 0.99}
+
+// DDSketch квантильный скетч DDSketch (https://arxiv.org/abs/1908.10693).
+// Значения раскладываются по логарифмическим корзинам, поэтому любой квантиль вычисляется
+// с относительной ошибкой не больше alpha, а скетчи с одинаковой точностью объединяются без потерь
+type DDSketch struct {
+	alpha    float64
+	gamma    float64
+	logGamma float64
+
+	positive map[int32]uint64 // index -> count, value in (gamma^(index-1), gamma^index]
+	negative map[int32]uint64 // the same for absolute values of negative numbers
+	zero     uint64
+
+	count uint64
+	sum   float64
+	min   float64
+	max   float64
+}
+
+func NewDDSketch(alpha float64) (*DDSketch, error) {
+	if !(alpha > 0 && alpha < 1) {
+		return nil, ErrInvalidAccuracy
+	}
+
+	gamma := (1 + alpha) / (1 - alpha)
+	return &DDSketch{
+		alpha:    alpha,
+		gamma:    gamma,
+		logGamma: math.Log(gamma),
+		positive: make(map[int32]uint64),
+		negative: make(map[int32]uint64),
+		min:      math.Inf(1),
+		max:      math.Inf(-1),
+	}, nil
+}
+
+// NewDefaultDDSketch скетч с точностью DefaultRelativeAccuracy
+func NewDefaultDDSketch() *DDSketch {
+	s, _ := NewDDSketch(DefaultRelativeAccuracy)
+	return s
+}
+
+// Add добавляет значение в скетч
+func (s *DDSketch) Add(value float64) error {
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return ErrInvalidValue
+	}
+
+	switch {
+	case value >= minIndexableValue:
+		s.positive[s.index(value)]++
+	case value <= -minIndexableValue:
+		s.negative[s.index(-value)]++
+	default:
+		s.zero++
+	}
+
+	s.count++
+	s.sum += value
+	s.min = min(s.min, value)
+	s.max = max(s.max, value)
+	return nil
+}
+
+// Merge добавляет в скетч значения other. Точность скетчей должна совпадать
+func (s *DDSketch) Merge(other *DDSketch) error {
+	if s.alpha != other.alpha {
+		return ErrAccuracyMismatch
+	}
+	if other.count == 0 {
+		return nil
+	}
+
+	for index, count := range other.positive {
+		s.positive[index] += count
+	}
+	for index, count := range other.negative {
+		s.negative[index] += count
+	}
+	s.zero += other.zero
+	s.count += other.count
+	s.sum += other.sum
+	s.min = min(s.min, other.min)
+	s.max = max(s.max, other.max)
+	return nil
+}
+
+// Clone независимая копия скетча
+func (s *DDSketch) Clone() *DDSketch {
+	clone := *s
+	clone.positive = maps.Clone(s.positive)
+	clone.negative = maps.Clone(s.negative)
+	return &clone
+}
+
+// Quantile значение квантиля q, например 0.99 для p99
+func (s *DDSketch) Quantile(q float64) (float64, error) {
+	if !(q >= 0 && q <= 1) {
+		return 0, ErrInvalidQuantile
+	}
+	if s.count == 0 {
+		return 0, ErrEmptySketch
+	}
+	if q == 0 {
+		return s.min, nil
+	}
+	if q == 1 {
+		return s.max, nil
+	}
+
+	rank := q * float64(s.count-1)
+	var seen float64
+	// negative values from the largest absolute value to the smallest
+	for _, index := range slices.Backward(slices.Sorted(maps.Keys(s.negative))) {
+		seen += float64(s.negative[index])
+		if seen > rank {
+			return s.clamp(-s.value(index)), nil
+		}
+	}
+	seen += float64(s.zero)
+	if seen > rank {
+		return s.clamp(0), nil
+	}
+	for _, index := range slices.Sorted(maps.Keys(s.positive)) {
+		seen += float64(s.positive[index])
+		if seen > rank {
+			return s.clamp(s.value(index)), nil
+		}
+	}
+
+	return s.max, nil
+}
+
+// RelativeAccuracy относительная ошибка квантилей
+func (s *DDSketch) RelativeAccuracy() float64 {
+	return s.alpha
+}
+
+// Count количество добавленных значений
+func (s *DDSketch) Count() uint64 {
+	return s.count
+}
+
+// Sum сумма добавленных значений
+func (s *DDSketch) Sum() float64 {
+	return s.sum
+}
+
+// String скетч в виде `count=3 sum=1.5 p50=0.5 p90=0.9 p99=0.99`
+func (s *DDSketch) String() string {
+	parts := []string{
+		"count=" + strconv.FormatUint(s.count, 10),
+		"sum=" + strconv.FormatFloat(s.sum, 'f', -1, 64),
+	}
+	for _, q := range DefaultQuantiles {
+		value, err := s.Quantile(q)
+		if err != nil {
+			break
+		}
+		parts = append(parts, "p"+strconv.FormatFloat(q*100, 'f', -1, 64)+"="+strconv.FormatFloat(value, 'f', -1, 64))
+	}
+	return strings.Join(parts, " ")
+}
+
+// MarshalBinary компактное представление скетча: корзины записываются varint-ами
+func (s *DDSketch) MarshalBinary() ([]byte, error) {
+	data := []byte{ddSketchVersion}
+	data = binary.BigEndian.AppendUint64(data, math.Float64bits(s.alpha))
+	data = binary.AppendUvarint(data, s.count)
+	data = binary.AppendUvarint(data, s.zero)
+	for _, value := range []float64{s.sum, s.min, s.max} {
+		data = binary.BigEndian.AppendUint64(data, math.Float64bits(value))
+	}
+	data = appendBins(data, s.positive)
+	data = appendBins(data, s.negative)
+
+	return data, nil
+}
+
+func (s *DDSketch) UnmarshalBinary(data []byte) error {
+	reader := bytes.NewReader(data)
+	version, err := reader.ReadByte()
+	if err != nil || version != ddSketchVersion {
+		return fmt.Errorf("%w: unknown version", ErrMalformedSketch)
+	}
+
+	var alpha uint64
+	if err = binary.Read(reader, binary.BigEndian, &alpha); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	decoded, err := NewDDSketch(math.Float64frombits(alpha))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+
+	if decoded.count, err = binary.ReadUvarint(reader); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	if decoded.zero, err = binary.ReadUvarint(reader); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	var stats [3]uint64
+	if err = binary.Read(reader, binary.BigEndian, &stats); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	decoded.sum = math.Float64frombits(stats[0])
+	decoded.min = math.Float64frombits(stats[1])
+	decoded.max = math.Float64frombits(stats[2])
+
+	if err = readBins(reader, decoded.positive); err != nil {
+		return err
+	}
+	if err = readBins(reader, decoded.negative); err != nil {
+		return err
+	}
+	if reader.Len() > 0 {
+		return fmt.Errorf("%w: unexpected trailing bytes", ErrMalformedSketch)
+	}
+
+	// count must match bins, otherwise quantiles are meaningless
+	total := decoded.zero
+	for _, count := range decoded.positive {
+		total += count
+	}
+	for _, count := range decoded.negative {
+		total += count
+	}
+	if total != decoded.count {
+		return fmt.Errorf("%w: count %d is not equal to the sum of bins %d", ErrMalformedSketch, decoded.count, total)
+	}
+
+	*s = *decoded
+	return nil
+}
+
+// MarshalJSON скетч в JSON передается строкой base64 с бинарным представлением
+func (s *DDSketch) MarshalJSON() ([]byte, error) {
+	data, err := s.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(data))
+}
+
+func (s *DDSketch) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	return s.UnmarshalBinary(decoded)
+}
+
+// index номер корзины для положительного значения
+func (s *DDSketch) index(value float64) int32 {
+	return int32(math.Ceil(math.Log(value) / s.logGamma))
+}
+
+// value середина корзины index: ее относительное отклонение от любого значения корзины не больше alpha
+func (s *DDSketch) value(index int32) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (1 + s.gamma)
+}
+
+func (s *DDSketch) clamp(value float64) float64 {
+	return max(s.min, min(s.max, value))
+}
+
+func appendBins(data []byte, bins map[int32]uint64) []byte {
+	data = binary.AppendUvarint(data, uint64(len(bins)))
+	for _, index := range slices.Sorted(maps.Keys(bins)) {
+		data = binary.AppendVarint(data, int64(index))
+		data = binary.AppendUvarint(data, bins[index])
+	}
+	return data
+}
+
+func readBins(reader *bytes.Reader, bins map[int32]uint64) error {
+	length, err := binary.ReadUvarint(reader)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	if length > uint64(reader.Len()) {
+		return fmt.Errorf("%w: too many bins", ErrMalformedSketch)
+	}
+
+	for range length {
+		index, err := binary.ReadVarint(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+		}
+		if index < math.MinInt32 || index > math.MaxInt32 {
+			return fmt.Errorf("%w: bin index out of range", ErrMalformedSketch)
+		}
+		count, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+		}
+		bins[int32(index)] += count
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package sketch
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand/v2"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDDSketch_Quantile(t *testing.T) {
+	random := rand.New(rand.NewPCG(1, 2))
+
+	tests := []struct {
+		name   string
+		values func(n int) float64
+	}{
+		{name: "uniform", values: func(int) float64 { return random.Float64() * 1000 }},
+		{name: "exponential latency", values: func(int) float64 { return random.ExpFloat64() / 10 }},
+		{name: "negative and zero", values: func(n int) float64 { return float64(n%201 - 100) }},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			s := NewDefaultDDSketch()
+			values := make([]float64, 10000)
+			for i := range values {
+				values[i] = test.values(i)
+				require.NoError(t, s.Add(values[i]))
+			}
+			slices.Sort(values)
+
+			for _, q := range []float64{0, 0.1, 0.5, 0.9, 0.99, 0.999, 1} {
+				got, err := s.Quantile(q)
+				require.NoError(t, err)
+				want := values[int(q*float64(len(values)-1))]
+				assert.InDelta(t, want, got, math.Abs(want)*DefaultRelativeAccuracy+1e-9, "quantile %v", q)
+			}
+			assert.Equal(t, uint64(len(values)), s.Count())
+		})
+	}
+}
+
+func TestDDSketch_Merge(t *testing.T) {
+	all := NewDefaultDDSketch()
+	first, second := NewDefaultDDSketch(), NewDefaultDDSketch()
+	for i := range 1000 {
+		value := float64(i) / 10
+		require.NoError(t, all.Add(value))
+		if i%2 == 0 {
+			require.NoError(t, first.Add(value))
+		} else {
+			require.NoError(t, second.Add(value))
+		}
+	}
+
+	merged := first.Clone()
+	require.NoError(t, merged.Merge(second))
+	assert.Equal(t, all.count, merged.count)
+	assert.Equal(t, all.positive, merged.positive)
+	assert.Equal(t, all.zero, merged.zero)
+	assert.Equal(t, all.min, merged.min)
+	assert.Equal(t, all.max, merged.max)
+	assert.InDelta(t, all.sum, merged.sum, 1e-6)
+	// clone is not changed by merge
+	assert.Equal(t, uint64(500), first.Count())
+
+	other, err := NewDDSketch(0.05)
+	require.NoError(t, err)
+	assert.ErrorIs(t, merged.Merge(other), ErrAccuracyMismatch)
+}
+
+func TestDDSketch_Encoding(t *testing.T) {
+	s := NewDefaultDDSketch()
+	for _, value := range []float64{-3, 0, 0.001, 0.25, 7, 7, 1e6} {
+		require.NoError(t, s.Add(value))
+	}
+
+	data, err := s.MarshalBinary()
+	require.NoError(t, err)
+	decoded := &DDSketch{}
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, s, decoded)
+
+	encoded, err := json.Marshal(s)
+	require.NoError(t, err)
+	decoded = &DDSketch{}
+	require.NoError(t, json.Unmarshal(encoded, decoded))
+	assert.Equal(t, s, decoded)
+
+	empty := NewDefaultDDSketch()
+	data, err = empty.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, decoded.UnmarshalBinary(data))
+	assert.Equal(t, empty, decoded)
+	_, err = decoded.Quantile(0.5)
+	assert.ErrorIs(t, err, ErrEmptySketch)
+
+	for name, malformed := range map[string][]byte{
+		"empty":         nil,
+		"truncated":     data[:len(data)-1],
+		"trailing":      append(slices.Clone(data), 0),
+		"wrong version": append([]byte{2}, data[1:]...),
+	} {
+		assert.ErrorIs(t, decoded.UnmarshalBinary(malformed), ErrMalformedSketch, name)
+	}
+	assert.Error(t, json.Unmarshal([]byte(`"not base64"`), decoded))
+}
+
+func TestDDSketch_Validation(t *testing.T) {
+	_, err := NewDDSketch(0)
+	assert.ErrorIs(t, err, ErrInvalidAccuracy)
+	_, err = NewDDSketch(1)
+	assert.ErrorIs(t, err, ErrInvalidAccuracy)
+
+	s := NewDefaultDDSketch()
+	assert.ErrorIs(t, s.Add(math.NaN()), ErrInvalidValue)
+	assert.ErrorIs(t, s.Add(math.Inf(1)), ErrInvalidValue)
+	require.NoError(t, s.Add(1))
+	_, err = s.Quantile(1.5)
+	assert.ErrorIs(t, err, ErrInvalidQuantile)
+	_, err = s.Quantile(math.NaN())
+	assert.ErrorIs(t, err, ErrInvalidQuantile)
+}
@@ -0,0 +1,249 @@
+package sketch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math"
+	"math/bits"
+)
+
+const (
+	// DefaultPrecision 2^14 регистров, стандартная ошибка оценки около 0.8%
+	DefaultPrecision = 14
+	MinPrecision     = 4
+	MaxPrecision     = 18
+
+	hllVersion = 1
+
+	hllDense  = 0
+	hllSparse = 1
+)
+
+var (
+	ErrInvalidPrecision  = fmt.Errorf("precision must be in [%d, %d]", MinPrecision, MaxPrecision)
+	ErrPrecisionMismatch = errors.New("sets with different precision can't be merged")
+)
+
+// HyperLogLog оценка количества различных значений (https://algo.inria.fr/flajolet/Publications/FlFuGaMe07.pdf).
+// Память ограничена 2^precision регистрами независимо от числа значений,
+// а скетчи с одинаковой точностью объединяются взятием максимума регистров
+type HyperLogLog struct {
+	precision uint8
+	registers []uint8 // the largest position of the first 1 bit among hashes of the register
+}
+
+func NewHyperLogLog(precision uint8) (*HyperLogLog, error) {
+	if precision < MinPrecision || precision > MaxPrecision {
+		return nil, ErrInvalidPrecision
+	}
+
+	return &HyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}, nil
+}
+
+// NewDefaultHyperLogLog скетч с точностью DefaultPrecision
+func NewDefaultHyperLogLog() *HyperLogLog {
+	h, _ := NewHyperLogLog(DefaultPrecision)
+	return h
+}
+
+// Add добавляет значение в скетч. Повторные значения не меняют оценку
+func (h *HyperLogLog) Add(value string) {
+	hash := hashString(value)
+	index := hash >> (64 - h.precision)
+	// sentinel bit limits rank by 64 - precision + 1
+	rank := uint8(bits.LeadingZeros64(hash<<h.precision|1<<(h.precision-1))) + 1
+	h.registers[index] = max(h.registers[index], rank)
+}
+
+// Merge добавляет в скетч значения other. Точность скетчей должна совпадать
+func (h *HyperLogLog) Merge(other *HyperLogLog) error {
+	if h.precision != other.precision {
+		return ErrPrecisionMismatch
+	}
+
+	for i, rank := range other.registers {
+		h.registers[i] = max(h.registers[i], rank)
+	}
+	return nil
+}
+
+// Clone независимая копия скетча
+func (h *HyperLogLog) Clone() *HyperLogLog {
+	return &HyperLogLog{
+		precision: h.precision,
+		registers: bytes.Clone(h.registers),
+	}
+}
+
+// Estimate оценка количества различных добавленных значений
+func (h *HyperLogLog) Estimate() uint64 {
+	m := float64(len(h.registers))
+	var sum float64
+	var zeros int
+	for _, rank := range h.registers {
+		sum += math.Ldexp(1, -int(rank))
+		if rank == 0 {
+			zeros++
+		}
+	}
+
+	estimate := alpha(len(h.registers)) * m * m / sum
+	// linear counting is more accurate for small cardinalities
+	if estimate <= 2.5*m && zeros > 0 {
+		estimate = m * math.Log(m/float64(zeros))
+	}
+	return uint64(math.Round(estimate))
+}
+
+// Precision количество бит хеша, выбирающих регистр
+func (h *HyperLogLog) Precision() uint8 {
+	return h.precision
+}
+
+// MarshalBinary компактное представление скетча: пока заполнено мало регистров,
+// записываются только ненулевые в виде пар (смещение индекса varint, значение)
+func (h *HyperLogLog) MarshalBinary() ([]byte, error) {
+	var pairs []byte
+	var count uint64
+	previous := 0
+	for i, rank := range h.registers {
+		if rank == 0 {
+			continue
+		}
+		count++
+		pairs = binary.AppendUvarint(pairs, uint64(i-previous))
+		pairs = append(pairs, rank)
+		previous = i
+		if len(pairs) >= len(h.registers) {
+			break
+		}
+	}
+
+	data := []byte{hllVersion, h.precision}
+	if len(pairs) >= len(h.registers) {
+		data = append(data, hllDense)
+		return append(data, h.registers...), nil
+	}
+
+	data = append(data, hllSparse)
+	data = binary.AppendUvarint(data, count)
+	return append(data, pairs...), nil
+}
+
+func (h *HyperLogLog) UnmarshalBinary(data []byte) error {
+	if len(data) < 3 || data[0] != hllVersion {
+		return fmt.Errorf("%w: unknown version", ErrMalformedSketch)
+	}
+	decoded, err := NewHyperLogLog(data[1])
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	maxRank := 64 - decoded.precision + 1
+
+	reader := bytes.NewReader(data[3:])
+	switch data[2] {
+	case hllDense:
+		if reader.Len() != len(decoded.registers) {
+			return fmt.Errorf("%w: expected %d registers, got %d", ErrMalformedSketch, len(decoded.registers), reader.Len())
+		}
+		reader.Read(decoded.registers)
+	case hllSparse:
+		count, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+		}
+		if count > uint64(len(decoded.registers)) {
+			return fmt.Errorf("%w: too many registers", ErrMalformedSketch)
+		}
+
+		var index uint64
+		for i := range count {
+			offset, err := binary.ReadUvarint(reader)
+			if err != nil {
+				return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+			}
+			// indexes are strictly increasing, only the first one may be zero
+			if i > 0 && offset == 0 {
+				return fmt.Errorf("%w: register indexes are not increasing", ErrMalformedSketch)
+			}
+			if index += offset; index >= uint64(len(decoded.registers)) {
+				return fmt.Errorf("%w: register index out of range", ErrMalformedSketch)
+			}
+			if decoded.registers[index], err = reader.ReadByte(); err != nil {
+				return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+			}
+		}
+		if reader.Len() > 0 {
+			return fmt.Errorf("%w: unexpected trailing bytes", ErrMalformedSketch)
+		}
+	default:
+		return fmt.Errorf("%w: unknown encoding", ErrMalformedSketch)
+	}
+
+	for _, rank := range decoded.registers {
+		if rank > maxRank {
+			return fmt.Errorf("%w: register value %d is greater than %d", ErrMalformedSketch, rank, maxRank)
+		}
+	}
+
+	*h = *decoded
+	return nil
+}
+
+// MarshalJSON скетч в JSON передается строкой base64 с бинарным представлением
+func (h *HyperLogLog) MarshalJSON() ([]byte, error) {
+	data, err := h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(base64.StdEncoding.EncodeToString(data))
+}
+
+func (h *HyperLogLog) UnmarshalJSON(data []byte) error {
+	var encoded string
+	if err := json.Unmarshal(data, &encoded); err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrMalformedSketch, err)
+	}
+	return h.UnmarshalBinary(decoded)
+}
+
+// hashString стабильный между процессами 64-битный хеш: агенты и сервер должны раскладывать значения одинаково.
+// FNV-1a перемешивается финализатором murmur3, иначе у похожих строк совпадают старшие биты
+func hashString(value string) uint64 {
+	hasher := fnv.New64a()
+	hasher.Write([]byte(value))
+	hash := hasher.Sum64()
+
+	hash ^= hash >> 33
+	hash *= 0xff51afd7ed558ccd
+	hash ^= hash >> 33
+	hash *= 0xc4ceb9fe1a85ec53
+	hash ^= hash >> 33
+	return hash
+}
+
+// alpha поправочный коэффициент оценки для m регистров
+func alpha(m int) float64 {
+	switch m {
+	case 16:
+		return 0.673
+	case 32:
+		return 0.697
+	case 64:
+		return 0.709
+	default:
+		return 0.7213 / (1 + 1.079/float64(m))
+	}
+}
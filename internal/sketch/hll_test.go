@@ -0,0 +1,109 @@
+package sketch
+
+import (
+	"encoding/json"
+	"slices"
+	"strconv"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHyperLogLog_Estimate(t *testing.T) {
+	tests := []struct {
+		name     string
+		distinct int
+	}{
+		{name: "empty", distinct: 0},
+		{name: "few values", distinct: 10},
+		{name: "linear counting range", distinct: 1000},
+		{name: "hyperloglog range", distinct: 200000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := NewDefaultHyperLogLog()
+			for i := range test.distinct {
+				// every value is added twice
+				h.Add("user-" + strconv.Itoa(i))
+				h.Add("user-" + strconv.Itoa(i))
+			}
+			assert.InDelta(t, test.distinct, h.Estimate(), float64(test.distinct)*0.03)
+		})
+	}
+}
+
+func TestHyperLogLog_Merge(t *testing.T) {
+	all := NewDefaultHyperLogLog()
+	first, second := NewDefaultHyperLogLog(), NewDefaultHyperLogLog()
+	// sets overlap by a half
+	for i := range 30000 {
+		value := strconv.Itoa(i)
+		all.Add(value)
+		if i < 20000 {
+			first.Add(value)
+		}
+		if i >= 10000 {
+			second.Add(value)
+		}
+	}
+
+	merged := first.Clone()
+	require.NoError(t, merged.Merge(second))
+	assert.Equal(t, all, merged)
+	assert.InDelta(t, 30000, merged.Estimate(), 30000*0.03)
+	// clone is not changed by merge
+	assert.InDelta(t, 20000, first.Estimate(), 20000*0.03)
+
+	other, err := NewHyperLogLog(10)
+	require.NoError(t, err)
+	assert.ErrorIs(t, merged.Merge(other), ErrPrecisionMismatch)
+}
+
+func TestHyperLogLog_Encoding(t *testing.T) {
+	sparse := NewDefaultHyperLogLog()
+	for _, value := range []string{"alice", "bob", "carol"} {
+		sparse.Add(value)
+	}
+	dense := NewDefaultHyperLogLog()
+	for i := range 100000 {
+		dense.Add(strconv.Itoa(i))
+	}
+
+	for name, h := range map[string]*HyperLogLog{"empty": NewDefaultHyperLogLog(), "sparse": sparse, "dense": dense} {
+		data, err := h.MarshalBinary()
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(data), len(h.registers)+3, name)
+		decoded := &HyperLogLog{}
+		require.NoError(t, decoded.UnmarshalBinary(data), name)
+		assert.Equal(t, h, decoded, name)
+
+		encoded, err := json.Marshal(h)
+		require.NoError(t, err)
+		decoded = &HyperLogLog{}
+		require.NoError(t, json.Unmarshal(encoded, decoded), name)
+		assert.Equal(t, h, decoded, name)
+	}
+
+	data, err := sparse.MarshalBinary()
+	require.NoError(t, err)
+	assert.Less(t, len(data), 16)
+	decoded := &HyperLogLog{}
+	for name, malformed := range map[string][]byte{
+		"empty":              nil,
+		"wrong version":      append([]byte{2}, data[1:]...),
+		"wrong precision":    append([]byte{data[0], 30}, data[2:]...),
+		"unknown encoding":   append([]byte{data[0], data[1], 7}, data[3:]...),
+		"truncated":          data[:len(data)-1],
+		"trailing":           append(slices.Clone(data), 0),
+		"short dense":        {hllVersion, DefaultPrecision, hllDense, 1},
+		"index out of range": {hllVersion, MinPrecision, hllSparse, 1, 16, 1},
+		"register too big":   {hllVersion, MinPrecision, hllSparse, 1, 0, 62},
+	} {
+		assert.ErrorIs(t, decoded.UnmarshalBinary(malformed), ErrMalformedSketch, name)
+	}
+	assert.Error(t, json.Unmarshal([]byte(`"not base64"`), decoded))
+
+	_, err = NewHyperLogLog(MaxPrecision + 1)
+	assert.ErrorIs(t, err, ErrInvalidPrecision)
+}
@@ -0,0 +1,42 @@
+package store
+
+import (
+	"context"
+	"slices"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+// SaveAllReturning сохраняет пачку и возвращает значения метрик после сохранения.
+// Хранилище без MetricsBatchStorage сохраняет метрики по одной через Save - так пачка не атомарна,
+// зато значения соответствуют именно этой записи
+func SaveAllReturning(ctx context.Context, storage Storage, metrics []models.Metrics) ([]models.Metrics, error) {
+	if batchStorage, ok := storage.(MetricsBatchStorage); ok {
+		return batchStorage.SaveAllReturning(ctx, metrics)
+	}
+
+	saved := make([]models.Metrics, 0, len(metrics))
+	for _, metric := range metrics {
+		result, err := storage.Save(ctx, metric)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, result)
+	}
+	return saved, nil
+}
+
+// LastBySeries оставляет последнее значение каждой серии: после сохранения пачки это итоговое значение
+func LastBySeries(metrics []models.Metrics) []models.Metrics {
+	seen := make(map[string]struct{}, len(metrics))
+	last := make([]models.Metrics, 0, len(metrics))
+	for _, metric := range slices.Backward(metrics) {
+		if _, ok := seen[metric.SeriesKey()]; ok {
+			continue
+		}
+		seen[metric.SeriesKey()] = struct{}{}
+		last = append(last, metric)
+	}
+	slices.Reverse(last)
+	return last
+}
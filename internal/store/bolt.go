@@ -0,0 +1,331 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltOpenTimeout сколько ждать блокировку файла, если его держит другой процесс
+const boltOpenTimeout = time.Second
+
+var metricsBucket = []byte("metrics")
+
+// BoltStorage хранит метрики во встроенной key-value базе bbolt (один файл на диске).
+// Каждое сохранение выполняется в отдельной транзакции, поэтому после падения процесса
+// в файле остаются только полностью записанные изменения
+type BoltStorage struct {
+	db  *bolt.DB
+	log *zerolog.Logger
+}
+
+func NewBoltStorage(cfg *config.ServerConfig, log *zerolog.Logger) (*BoltStorage, error) {
+	if cfg.BoltPath == "" {
+		log.Error().Str("func", "store.NewBoltStorage").Msg("no bolt storage path was provided")
+		return nil, errors.New("no bolt storage path was provided")
+	}
+
+	// create directory for database file
+	if err := os.MkdirAll(path.Dir(cfg.BoltPath), 0755); err != nil {
+		log.Err(err).Str("func", "store.NewBoltStorage").Msg("error creating directory for bolt storage")
+		return nil, err
+	}
+
+	db, err := bolt.Open(cfg.BoltPath, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		log.Err(err).Str("func", "store.NewBoltStorage").Msg("error opening bolt storage")
+		return nil, fmt.Errorf("error opening bolt storage: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(metricsBucket)
+		return err
+	})
+	if err != nil {
+		log.Err(err).Str("func", "store.NewBoltStorage").Msg("error creating metrics bucket")
+		db.Close()
+		return nil, err
+	}
+
+	log.Info().Str("func", "store.NewBoltStorage").Str("path", cfg.BoltPath).Msg("bolt storage opened")
+	return &BoltStorage{db: db, log: log}, nil
+}
+
+func (b *BoltStorage) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	var result models.Metrics
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		var err error
+		result, err = saveMetric(tx.Bucket(metricsBucket), metric)
+		return err
+	})
+	if err != nil {
+		b.log.Err(err).Str("func", "*BoltStorage.Save").Any("metric", metric).Msg("error saving metric")
+		return models.Metrics{}, err
+	}
+
+	return result, nil
+}
+
+// SaveAll сохраняет все метрики в одной транзакции: либо записываются все, либо ни одной
+func (b *BoltStorage) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	_, err := b.SaveAllReturning(ctx, metrics)
+	return err
+}
+
+// SaveAllReturning сохраняет метрики в одной транзакции и возвращает их значения после сохранения
+func (b *BoltStorage) SaveAllReturning(ctx context.Context, metrics []models.Metrics) ([]models.Metrics, error) {
+	saved := make([]models.Metrics, 0, len(metrics))
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metricsBucket)
+		for _, metric := range metrics {
+			result, err := saveMetric(bucket, metric)
+			if err != nil {
+				return err
+			}
+			saved = append(saved, result)
+		}
+		return nil
+	})
+	if err != nil {
+		b.log.Err(err).Str("func", "*BoltStorage.SaveAll").Msg("error saving metrics")
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+func (b *BoltStorage) Get(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	var result models.Metrics
+	err := b.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket(metricsBucket).Get(boltKey(metric))
+		if data == nil {
+			return ErrNotFound
+		}
+		var stored timedMetric
+		if err := json.Unmarshal(data, &stored); err != nil {
+			return err
+		}
+		result = stored.Metrics
+		return nil
+	})
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	return result, nil
+}
+
+func (b *BoltStorage) GetAll(ctx context.Context) ([]models.Metrics, error) {
+	var metrics []models.Metrics
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(metricsBucket).ForEach(func(_, data []byte) error {
+			var stored timedMetric
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+			metrics = append(metrics, stored.Metrics)
+			return nil
+		})
+	})
+	if err != nil {
+		b.log.Err(err).Str("func", "*BoltStorage.GetAll").Msg("error reading metrics")
+		return nil, err
+	}
+
+	return metrics, nil
+}
+
+// Delete удаляет метрики в одной транзакции. Возвращает количество удаленных метрик
+func (b *BoltStorage) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	deleted := 0
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metricsBucket)
+		for _, metric := range metrics {
+			key := boltKey(metric)
+			if bucket.Get(key) == nil {
+				continue
+			}
+			if err := bucket.Delete(key); err != nil {
+				return err
+			}
+			deleted++
+		}
+		return nil
+	})
+	if err != nil {
+		b.log.Err(err).Str("func", "*BoltStorage.Delete").Msg("error deleting metrics")
+		return 0, err
+	}
+
+	return deleted, nil
+}
+
+// DeleteExpired удаляет метрики, для которых expired возвращает true, и возвращает их.
+// Метрикам, записанным без времени обновления, оно проставляется текущим
+func (b *BoltStorage) DeleteExpired(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error) {
+	var deleted []models.Metrics
+	err := b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metricsBucket)
+		now := time.Now()
+
+		// bucket can't be changed during iteration, so collect keys first
+		var expiredKeys [][]byte
+		untracked := make(map[string]timedMetric)
+		err := bucket.ForEach(func(key, data []byte) error {
+			var stored timedMetric
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+			switch {
+			case stored.UpdatedAt.IsZero():
+				stored.UpdatedAt = now
+				untracked[string(key)] = stored
+			case expired(stored.Metrics, stored.UpdatedAt):
+				expiredKeys = append(expiredKeys, bytes.Clone(key))
+				deleted = append(deleted, stored.Metrics)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range expiredKeys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		for key, stored := range untracked {
+			if err = putMetric(bucket, []byte(key), stored); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.log.Err(err).Str("func", "*BoltStorage.DeleteExpired").Msg("error deleting expired metrics")
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// Purge удаляет в одной транзакции все метрики, подходящие под фильтр, и возвращает их
+func (b *BoltStorage) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []models.Metrics
+	err = b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(metricsBucket)
+
+		// bucket can't be changed during iteration, so collect keys first
+		var matchedKeys [][]byte
+		err := bucket.ForEach(func(key, data []byte) error {
+			var stored timedMetric
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return err
+			}
+			if match(stored.Metrics) {
+				matchedKeys = append(matchedKeys, bytes.Clone(key))
+				deleted = append(deleted, stored.Metrics)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, key := range matchedKeys {
+			if err = bucket.Delete(key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		b.log.Err(err).Str("func", "*BoltStorage.Purge").Msg("error purging metrics")
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+// Close закрывает файл базы
+func (b *BoltStorage) Close() error {
+	return b.db.Close()
+}
+
+// saveMetric применяет логику метрики внутри транзакции: counter суммируется, gauge заменяется,
+// гистограммы и скетчи объединяются
+func saveMetric(bucket *bolt.Bucket, metric models.Metrics) (models.Metrics, error) {
+	key := boltKey(metric)
+
+	switch metric.MType {
+	case models.Counter:
+		if metric.Delta == nil {
+			return models.Metrics{}, errors.New("counter has no delta")
+		}
+		if data := bucket.Get(key); data != nil {
+			var stored timedMetric
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return models.Metrics{}, err
+			}
+			// counters without delta could be stored before the check above
+			delta := *metric.Delta
+			if stored.Delta != nil {
+				delta += *stored.Delta
+			}
+			metric.Delta = &delta
+		}
+	case models.Histogram, models.Summary, models.Set:
+		if data := bucket.Get(key); data != nil {
+			var stored timedMetric
+			if err := json.Unmarshal(data, &stored); err != nil {
+				return models.Metrics{}, err
+			}
+			merged, err := stored.Metrics.Merge(metric)
+			if err != nil {
+				return models.Metrics{}, err
+			}
+			metric = merged
+		}
+	case models.Gauge:
+		if metric.Value == nil {
+			return models.Metrics{}, errors.New("gauge has no value")
+		}
+	default:
+		return models.Metrics{}, errors.New("unsupported metric type")
+	}
+
+	if err := putMetric(bucket, key, timedMetric{Metrics: metric, UpdatedAt: time.Now()}); err != nil {
+		return models.Metrics{}, err
+	}
+
+	return metric, nil
+}
+
+func putMetric(bucket *bolt.Bucket, key []byte, metric timedMetric) error {
+	data, err := json.Marshal(metric)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
+
+// boltKey ключ метрики в базе: тип и имя с метками, так что метрики разных типов не пересекаются
+func boltKey(metric models.Metrics) []byte {
+	return []byte(metric.SeriesKey())
+}
@@ -0,0 +1,168 @@
+package store
+
+import (
+	"context"
+	"path"
+	"testing"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBoltStorage(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		saves   [][]models.Metrics // every element is saved with one SaveAll call
+		want    []models.Metrics
+		wantErr bool // error of the last SaveAll
+	}{
+		{
+			name: "counter is summed, gauge is replaced",
+			saves: [][]models.Metrics{
+				{counterMetric("PollCount", 2), gaugeMetric("Alloc", 1)},
+				{counterMetric("PollCount", 3), gaugeMetric("Alloc", 5)},
+			},
+			want: []models.Metrics{gaugeMetric("Alloc", 5), counterMetric("PollCount", 5)},
+		},
+		{
+			name: "same name with different type or labels",
+			saves: [][]models.Metrics{
+				{counterMetric("Alloc", 2), gaugeMetric("Alloc", 1)},
+				{{ID: "Alloc", MType: models.Gauge, Value: mValue(3), Labels: map[string]string{"host": "a"}}},
+			},
+			want: []models.Metrics{
+				counterMetric("Alloc", 2),
+				gaugeMetric("Alloc", 1),
+				{ID: "Alloc", MType: models.Gauge, Value: mValue(3), Labels: map[string]string{"host": "a"}},
+			},
+		},
+		{
+			name: "batch with invalid metric is not saved",
+			saves: [][]models.Metrics{
+				{counterMetric("PollCount", 2)},
+				{counterMetric("PollCount", 3), {ID: "Bad", MType: "unknown"}},
+			},
+			want:    []models.Metrics{counterMetric("PollCount", 2)},
+			wantErr: true,
+		},
+		{
+			name: "histograms are merged",
+			saves: [][]models.Metrics{
+				{histogramMetric("latency", []float64{1, 5}, 0.5), histogramMetric("latency", []float64{1, 5}, 3)},
+				{histogramMetric("latency", []float64{1, 5}, 10)},
+			},
+			want: []models.Metrics{histogramMetric("latency", []float64{1, 5}, 0.5, 3, 10)},
+		},
+		{
+			name: "histogram with other buckets is not saved",
+			saves: [][]models.Metrics{
+				{histogramMetric("latency", []float64{1, 5}, 0.5)},
+				{gaugeMetric("Alloc", 1), histogramMetric("latency", []float64{1, 2}, 0.5)},
+			},
+			want:    []models.Metrics{histogramMetric("latency", []float64{1, 5}, 0.5)},
+			wantErr: true,
+		},
+		{
+			name: "summary sketches are merged",
+			saves: [][]models.Metrics{
+				{summaryMetric("latency", 0.5, 3), summaryMetric("latency", -1, 0)},
+				{summaryMetric("latency", 10)},
+			},
+			want: []models.Metrics{summaryMetric("latency", 0.5, 3, -1, 0, 10)},
+		},
+		{
+			name: "sets are merged",
+			saves: [][]models.Metrics{
+				{setMetric("users", "alice", "bob"), setMetric("users", "bob")},
+				{setMetric("users", "carol", "alice")},
+			},
+			want: []models.Metrics{setMetric("users", "alice", "bob", "carol")},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &config.ServerConfig{BoltPath: path.Join(t.TempDir(), "metrics.db")}
+			storage, err := NewBoltStorage(cfg, &logger)
+			require.NoError(t, err)
+
+			for i, batch := range tt.saves {
+				err = storage.SaveAll(ctx, batch)
+				if i == len(tt.saves)-1 && tt.wantErr {
+					assert.Error(t, err)
+				} else {
+					require.NoError(t, err)
+				}
+			}
+			require.NoError(t, storage.Close())
+
+			// metrics survive reopening
+			storage, err = NewBoltStorage(cfg, &logger)
+			require.NoError(t, err)
+			defer storage.Close()
+
+			got, err := storage.GetAll(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.want, got)
+
+			for _, metric := range tt.want {
+				found, err := storage.Get(ctx, metric)
+				require.NoError(t, err)
+				assert.Equal(t, metric, found)
+			}
+		})
+	}
+}
+
+func TestBoltStorage_Save(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+
+	storage, err := NewBoltStorage(&config.ServerConfig{BoltPath: path.Join(t.TempDir(), "metrics.db")}, &logger)
+	require.NoError(t, err)
+	defer storage.Close()
+
+	_, err = storage.Get(ctx, counterMetric("PollCount", 0))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	result, err := storage.Save(ctx, counterMetric("PollCount", 2))
+	require.NoError(t, err)
+	assert.Equal(t, counterMetric("PollCount", 2), result)
+
+	result, err = storage.Save(ctx, counterMetric("PollCount", 3))
+	require.NoError(t, err)
+	assert.Equal(t, counterMetric("PollCount", 5), result)
+
+	_, err = storage.Get(ctx, gaugeMetric("PollCount", 0))
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	_, err = storage.Save(ctx, models.Metrics{ID: "Bad", MType: "unknown"})
+	assert.Error(t, err)
+
+	// counter with value instead of delta and gauge without value are rejected and don't break the series
+	value := 1.0
+	_, err = storage.Save(ctx, models.Metrics{ID: "PollCount", MType: models.Counter, Value: &value})
+	assert.Error(t, err)
+	_, err = storage.Save(ctx, models.Metrics{ID: "Empty", MType: models.Gauge})
+	assert.Error(t, err)
+	result, err = storage.Get(ctx, counterMetric("PollCount", 0))
+	require.NoError(t, err)
+	assert.Equal(t, counterMetric("PollCount", 5), result)
+
+	// only metric with the same type and labels is deleted
+	_, err = storage.Save(ctx, gaugeMetric("Alloc", 1))
+	require.NoError(t, err)
+	deleted, err := storage.Delete(ctx, []models.Metrics{gaugeMetric("PollCount", 0), counterMetric("PollCount", 0), counterMetric("PollCount", 0)})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = storage.Get(ctx, counterMetric("PollCount", 0))
+	assert.ErrorIs(t, err, ErrNotFound)
+	all, err := storage.GetAll(ctx)
+	require.NoError(t, err)
+	assert.Equal(t, []models.Metrics{gaugeMetric("Alloc", 1)}, all)
+}
@@ -15,6 +15,9 @@ type PostgresErrorClassifier struct{}
 
 var (
 	ErrNotFound = errors.New("metric is not found")
+
+	ErrRetentionNotSupported = errors.New("storage does not track metric update time")
+	ErrPurgeNotSupported     = errors.New("storage does not support deleting metrics by filter")
 )
 
 const (
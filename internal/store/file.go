@@ -1,22 +1,40 @@
 package store
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"os"
 	"path"
+	"sync"
+	"time"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
 	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/rs/zerolog"
 )
 
+const (
+	snapshotFileName = "metrics.log"
+	walFileName      = "metrics.wal"
+)
+
+// FileStorage хранит метрики в файлах: снимок всех метрик (JSON-массив) и журнал изменений (WAL) -
+// по одной метрике с итоговым значением в строке. Журнал дописывается при каждом сохранении,
+// а при сжатии (SaveMetricsToFile) записывается новый снимок и журнал очищается.
+// При восстановлении к снимку применяется журнал; оборванная последняя запись журнала отбрасывается
 type FileStorage struct {
 	cfg          *config.ServerConfig
 	memStorage   *MemStorage
 	log          *zerolog.Logger
 	fullFileName string
+	walFileName  string
+	wal          *os.File
+	walSize      int64
+	syncWAL      bool        // fsync journal after every append
+	mu           *sync.Mutex // guards metrics files
 }
 
 func NewFileStorage(ctx context.Context, memStorage *MemStorage, cfg *config.ServerConfig, log *zerolog.Logger) (*FileStorage, error) {
@@ -29,7 +47,10 @@ func NewFileStorage(ctx context.Context, memStorage *MemStorage, cfg *config.Ser
 		memStorage:   memStorage,
 		cfg:          cfg,
 		log:          log,
-		fullFileName: path.Join(cfg.FileStoragePath, "metrics.log"),
+		fullFileName: path.Join(cfg.FileStoragePath, snapshotFileName),
+		walFileName:  path.Join(cfg.FileStoragePath, walFileName),
+		syncWAL:      cfg.StoreInterval == 0,
+		mu:           &sync.Mutex{},
 	}
 
 	// create directory for metrics file
@@ -38,86 +59,200 @@ func NewFileStorage(ctx context.Context, memStorage *MemStorage, cfg *config.Ser
 		return nil, err
 	}
 
-	// load metrics from file if needed
+	// load metrics from file if needed, otherwise previous journal is dropped
+	var metricsFromFile []timedMetric
+	var walSize int64
+	var err error
 	if cfg.RestoreMetricsFromFile {
-		metricsFromFile, err := fs.LoadMetricsFromFile(ctx)
+		metricsFromFile, walSize, err = fs.load()
 		if err != nil {
 			fs.log.Err(err).Str("func", "store.NewFileStorage").Msg("error loading metrics from file")
 			return nil, err
 		}
 		fs.log.Debug().Str("func", "store.NewFileStorage").Any("metrics", metricsFromFile).Msg("restored metrics from file")
-		for _, metric := range metricsFromFile {
-			fs.memStorage.Memory[metric.ID] = metric
+		for _, record := range metricsFromFile {
+			fs.memStorage.restore(record.Metrics, record.UpdatedAt)
 		}
 	}
 
+	// open journal and cut off torn last record
+	fs.wal, err = os.OpenFile(fs.walFileName, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		fs.log.Err(err).Str("func", "store.NewFileStorage").Msg("error opening metrics journal")
+		return nil, err
+	}
+	if err = fs.wal.Truncate(walSize); err != nil {
+		fs.log.Err(err).Str("func", "store.NewFileStorage").Msg("error truncating metrics journal")
+		fs.wal.Close()
+		return nil, err
+	}
+	fs.walSize = walSize
+
 	return fs, nil
 }
 
+// SaveMetricsToFile сжимает хранилище: атомарно перезаписывает снимок метрик (через временный файл и переименование)
+// и очищает журнал. allMetrics должны содержать все изменения, записанные в журнал
 func (fs *FileStorage) SaveMetricsToFile(ctx context.Context, allMetrics []models.Metrics) error {
-	fs.memStorage.mu.Lock()
-	defer fs.memStorage.mu.Unlock()
+	// snapshot keeps update time of every metric for retention
+	records := make([]timedMetric, len(allMetrics))
+	for i, metric := range allMetrics {
+		records[i] = timedMetric{Metrics: metric}
+		if fs.memStorage != nil {
+			records[i].UpdatedAt = fs.memStorage.updatedAt(metric.SeriesKey())
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-	jsonData, err := json.Marshal(allMetrics)
+	jsonData, err := json.Marshal(records)
 	if err != nil {
 		fs.log.Err(err).Str("func", "*FileStorage.SaveMetricsToFile").Msg("error marshalling metric to JSON")
 		return err
 	}
 
-	return os.WriteFile(fs.fullFileName, jsonData, 0644)
+	tmpFileName := fs.fullFileName + ".tmp"
+	if err = writeFileSync(tmpFileName, jsonData); err != nil {
+		fs.log.Err(err).Str("func", "*FileStorage.SaveMetricsToFile").Msg("error writing temporary metrics file")
+		return err
+	}
+	if err = os.Rename(tmpFileName, fs.fullFileName); err != nil {
+		fs.log.Err(err).Str("func", "*FileStorage.SaveMetricsToFile").Msg("error replacing metrics file")
+		return err
+	}
+
+	// snapshot contains everything from journal
+	if err = fs.wal.Truncate(0); err != nil {
+		fs.log.Err(err).Str("func", "*FileStorage.SaveMetricsToFile").Msg("error truncating metrics journal")
+		return err
+	}
+	fs.walSize = 0
+
+	return nil
 }
 
-func (fs *FileStorage) LoadMetricsFromFile(context.Context) ([]models.Metrics, error) {
-	fs.memStorage.mu.Lock()
-	defer fs.memStorage.mu.Unlock()
+// AppendMetricsToLog дописывает итоговые значения метрик в журнал
+func (fs *FileStorage) AppendMetricsToLog(ctx context.Context, metrics ...models.Metrics) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	now := time.Now()
+	for _, metric := range metrics {
+		if err := encoder.Encode(timedMetric{Metrics: metric, UpdatedAt: now}); err != nil {
+			fs.log.Err(err).Str("func", "*FileStorage.AppendMetricsToLog").Msg("error marshalling metric to JSON")
+			return err
+		}
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
 
-	// open existing file or create new
-	file, err := os.OpenFile(fs.fullFileName, os.O_RDONLY|os.O_CREATE, 0644)
+	// the whole batch is written at once: crash may only leave the last record torn
+	n, err := fs.wal.Write(buf.Bytes())
+	fs.walSize += int64(n)
 	if err != nil {
-		fs.log.Err(err).Str("func", "*FileStorage.LoadMetricsFromFile").Msg("error opening existing file or creating new file")
-		return nil, err
+		fs.log.Err(err).Str("func", "*FileStorage.AppendMetricsToLog").Msg("error appending metrics to journal")
+		return err
+	}
+	if fs.syncWAL {
+		if err = fs.wal.Sync(); err != nil {
+			fs.log.Err(err).Str("func", "*FileStorage.AppendMetricsToLog").Msg("error syncing metrics journal")
+			return err
+		}
 	}
-	defer file.Close()
 
-	// check file size
-	fileInfo, err := file.Stat()
+	return nil
+}
+
+// LogSize размер журнала в байтах
+func (fs *FileStorage) LogSize() int64 {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.walSize
+}
+
+// LoadMetricsFromFile возвращает метрики из снимка с примененным к нему журналом
+func (fs *FileStorage) LoadMetricsFromFile(context.Context) ([]models.Metrics, error) {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	records, _, err := fs.load()
 	if err != nil {
-		fs.log.Err(err).Str("func", "*FileStorage.LoadMetricsFromFile").Msg("error getting file size")
 		return nil, err
 	}
-	// if empty - return empty slice of metrics
-	if fileInfo.Size() == 0 {
-		fs.log.Info().Str("func", "*FileStorage.LoadMetricsFromFile").Msg("metrics file is empty")
-		return []models.Metrics{}, nil
+
+	metrics := make([]models.Metrics, len(records))
+	for i, record := range records {
+		metrics[i] = record.Metrics
 	}
+	return metrics, nil
+}
+
+// Close закрывает журнал
+func (fs *FileStorage) Close() error {
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	return fs.wal.Close()
+}
+
+// load читает снимок и применяет к нему журнал. Возвращает размер корректной части журнала
+func (fs *FileStorage) load() ([]timedMetric, int64, error) {
+	loadedMetrics := []timedMetric{}
 
-	// if not empty - read file
 	data, err := os.ReadFile(fs.fullFileName)
-	if err != nil {
-		if os.IsNotExist(err) {
-			fs.log.Err(err).Str("func", "*FileStorage.LoadMetricsFromFile").Msg("error during reading file")
-			return []models.Metrics{}, nil
+	if err != nil && !os.IsNotExist(err) {
+		fs.log.Err(err).Str("func", "*FileStorage.load").Msg("error during reading file")
+		return nil, 0, err
+	}
+	// decode contents of file from JSON array to slice of metrics
+	if len(data) > 0 {
+		if err = json.Unmarshal(data, &loadedMetrics); err != nil {
+			fs.log.Err(err).Str("func", "*FileStorage.load").Msg("error during unmarshalling JSON from file to a slice of metrics")
+			return nil, 0, err
 		}
-		return nil, err
 	}
 
-	// decode contents of file from JSON array to slice of metrics
-	var loadedMetrics []models.Metrics
-	if err = json.Unmarshal(data, &loadedMetrics); err != nil {
-		fs.log.Err(err).Str("func", "*FileStorage.LoadMetricsFromFile").Msg("error during unmarshalling JSON from file to a slice of metrics")
-		return nil, err
+	walData, err := os.ReadFile(fs.walFileName)
+	if err != nil && !os.IsNotExist(err) {
+		fs.log.Err(err).Str("func", "*FileStorage.load").Msg("error during reading journal")
+		return nil, 0, err
+	}
+	records, walSize, err := parseWAL(walData)
+	if err != nil {
+		fs.log.Err(err).Str("func", "*FileStorage.load").Msg("metrics journal is corrupted")
+		return nil, 0, err
+	}
+	if walSize < int64(len(walData)) {
+		fs.log.Warn().Str("func", "*FileStorage.load").Int64("dropped bytes", int64(len(walData))-walSize).Msg("torn last record of metrics journal is dropped")
+	}
+	if len(records) == 0 {
+		return loadedMetrics, walSize, nil
 	}
 
-	return loadedMetrics, nil
+	// replay journal: the last value of every metric wins
+	index := make(map[string]int, len(loadedMetrics))
+	for i, metric := range loadedMetrics {
+		index[metric.SeriesKey()] = i
+	}
+	for _, metric := range records {
+		if i, ok := index[metric.SeriesKey()]; ok {
+			loadedMetrics[i] = metric
+			continue
+		}
+		index[metric.SeriesKey()] = len(loadedMetrics)
+		loadedMetrics = append(loadedMetrics, metric)
+	}
+
+	return loadedMetrics, walSize, nil
 }
 
 func (fs *FileStorage) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
-	fs.memStorage.mu.Lock()
-	defer fs.memStorage.mu.Unlock()
+	fs.memStorage.Set(ctx, metric)
 
 	// save metric to file
-	fs.memStorage.Memory[metric.ID] = metric
-	if err := fs.SaveMetricsToFile(ctx, fs.memStorage.GetAllMetrics(ctx)); err != nil {
+	if err := fs.AppendMetricsToLog(ctx, metric); err != nil {
 		fs.log.Err(err).Str("func", "*FileStorage.Save").Msg("error during saving metric to a file")
 		return models.Metrics{}, err
 	}
@@ -138,7 +273,7 @@ func (fs *FileStorage) Get(ctx context.Context, metric models.Metrics) (models.M
 	}
 
 	for _, m := range metrics {
-		if m.ID == metric.ID && m.MType == metric.MType {
+		if m.SeriesKey() == metric.SeriesKey() {
 			return m, nil
 		}
 	}
@@ -150,3 +285,80 @@ func (fs *FileStorage) Get(ctx context.Context, metric models.Metrics) (models.M
 func (fs *FileStorage) GetAll(ctx context.Context) ([]models.Metrics, error) {
 	return fs.LoadMetricsFromFile(ctx)
 }
+
+// Delete удаляет метрики из памяти и переписывает снимок без них
+func (fs *FileStorage) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	if _, err := fs.memStorage.Delete(ctx, metrics); err != nil {
+		return 0, err
+	}
+
+	stored, err := fs.LoadMetricsFromFile(ctx)
+	if err != nil {
+		fs.log.Err(err).Str("func", "*FileStorage.Delete").Msg("error during getting metrics from file")
+		return 0, err
+	}
+
+	toDelete := make(map[string]bool, len(metrics))
+	for _, metric := range metrics {
+		toDelete[metric.SeriesKey()] = true
+	}
+	rest := make([]models.Metrics, 0, len(stored))
+	for _, metric := range stored {
+		if !toDelete[metric.SeriesKey()] {
+			rest = append(rest, metric)
+		}
+	}
+
+	if err = fs.SaveMetricsToFile(ctx, rest); err != nil {
+		return 0, err
+	}
+	return len(stored) - len(rest), nil
+}
+
+// writeFileSync записывает данные в файл и сбрасывает их на диск
+func writeFileSync(name string, data []byte) error {
+	file, err := os.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err = file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err = file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// parseWAL разбирает журнал. Последняя запись без перевода строки или с ошибкой разбора считается оборванной
+// и отбрасывается, ошибка в середине журнала означает его повреждение.
+// Возвращает записи и размер корректной части журнала
+func parseWAL(data []byte) ([]timedMetric, int64, error) {
+	var records []timedMetric
+	var offset int64
+	for len(data) > 0 {
+		line, rest, complete := bytes.Cut(data, []byte("\n"))
+		if !complete {
+			break
+		}
+
+		if len(bytes.TrimSpace(line)) > 0 {
+			var metric timedMetric
+			if err := json.Unmarshal(line, &metric); err != nil {
+				if len(bytes.TrimSpace(rest)) == 0 {
+					break
+				}
+				return nil, 0, fmt.Errorf("corrupted journal record at offset %d: %w", offset, err)
+			}
+			records = append(records, metric)
+		}
+
+		offset += int64(len(line)) + 1
+		data = rest
+	}
+
+	return records, offset, nil
+}
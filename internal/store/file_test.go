@@ -0,0 +1,164 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStorage_Restore(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+
+	snapshot := `[{"id":"Alloc","type":"gauge","value":1},{"id":"PollCount","type":"counter","delta":5}]`
+	tests := []struct {
+		name    string
+		wal     string
+		want    []models.Metrics
+		wantWAL string // journal after restore
+		wantErr bool
+	}{
+		{
+			name: "snapshot only",
+			want: []models.Metrics{gaugeMetric("Alloc", 1), counterMetric("PollCount", 5)},
+		},
+		{
+			name: "journal is replayed over snapshot",
+			wal: `{"id":"PollCount","type":"counter","delta":7}` + "\n" +
+				`{"id":"Alloc","type":"gauge","value":2}` + "\n" +
+				`{"id":"PollCount","type":"counter","delta":9}` + "\n" +
+				`{"id":"Sys","type":"gauge","value":3,"labels":{"host":"a"}}` + "\n",
+			want: []models.Metrics{gaugeMetric("Alloc", 2), counterMetric("PollCount", 9), {ID: "Sys", MType: models.Gauge, Value: mValue(3), Labels: map[string]string{"host": "a"}}},
+			wantWAL: `{"id":"PollCount","type":"counter","delta":7}` + "\n" +
+				`{"id":"Alloc","type":"gauge","value":2}` + "\n" +
+				`{"id":"PollCount","type":"counter","delta":9}` + "\n" +
+				`{"id":"Sys","type":"gauge","value":3,"labels":{"host":"a"}}` + "\n",
+		},
+		{
+			name:    "torn last record without newline is dropped",
+			wal:     `{"id":"PollCount","type":"counter","delta":7}` + "\n" + `{"id":"PollCount","type":"coun`,
+			want:    []models.Metrics{gaugeMetric("Alloc", 1), counterMetric("PollCount", 7)},
+			wantWAL: `{"id":"PollCount","type":"counter","delta":7}` + "\n",
+		},
+		{
+			name:    "torn last record with garbage is dropped",
+			wal:     `{"id":"PollCount","type":"counter","delta":7}` + "\n" + `{"id":"Poll` + "\x00\x00\n",
+			want:    []models.Metrics{gaugeMetric("Alloc", 1), counterMetric("PollCount", 7)},
+			wantWAL: `{"id":"PollCount","type":"counter","delta":7}` + "\n",
+		},
+		{
+			name:    "corrupted record in the middle",
+			wal:     `{"id":"PollCount","type":"coun` + "\n" + `{"id":"PollCount","type":"counter","delta":7}` + "\n",
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			cfg := &config.ServerConfig{FileStoragePath: t.TempDir(), RestoreMetricsFromFile: true}
+			require.NoError(t, os.WriteFile(path.Join(cfg.FileStoragePath, snapshotFileName), []byte(snapshot), 0644))
+			if test.wal != "" {
+				require.NoError(t, os.WriteFile(path.Join(cfg.FileStoragePath, walFileName), []byte(test.wal), 0644))
+			}
+
+			memStorage := NewMemStorage(&logger)
+			fileStorage, err := NewFileStorage(ctx, memStorage, cfg, &logger)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			defer fileStorage.Close()
+
+			assert.ElementsMatch(t, test.want, memStorage.GetAllMetrics(ctx))
+			wal, err := os.ReadFile(path.Join(cfg.FileStoragePath, walFileName))
+			require.NoError(t, err)
+			assert.Equal(t, test.wantWAL, string(wal))
+
+			// new records are appended after the last valid one
+			require.NoError(t, fileStorage.AppendMetricsToLog(ctx, counterMetric("PollCount", 11)))
+			restored, err := fileStorage.GetAll(ctx)
+			require.NoError(t, err)
+			assert.Contains(t, restored, counterMetric("PollCount", 11))
+		})
+	}
+}
+
+func TestFileStorage_UpdatedAt(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+	cfg := &config.ServerConfig{FileStoragePath: t.TempDir(), RestoreMetricsFromFile: true}
+
+	// snapshot and journal keep update time, old records have none
+	snapshot := `[{"id":"Old","type":"gauge","value":1,"updated_at":"2020-01-01T00:00:00Z"},{"id":"Legacy","type":"gauge","value":1}]`
+	wal := `{"id":"Journal","type":"gauge","value":1,"updated_at":"2020-01-01T00:00:00Z"}` + "\n"
+	require.NoError(t, os.WriteFile(path.Join(cfg.FileStoragePath, snapshotFileName), []byte(snapshot), 0644))
+	require.NoError(t, os.WriteFile(path.Join(cfg.FileStoragePath, walFileName), []byte(wal), 0644))
+
+	memStorage := NewMemStorage(&logger)
+	fileStorage, err := NewFileStorage(ctx, memStorage, cfg, &logger)
+	require.NoError(t, err)
+	defer fileStorage.Close()
+	_, err = memStorage.Save(ctx, gaugeMetric("New", 1))
+	require.NoError(t, err)
+	require.NoError(t, fileStorage.SaveMetricsToFile(ctx, memStorage.GetAllMetrics(ctx)))
+
+	// metrics without update time are counted from restore
+	cutoff := time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+	expired, err := memStorage.DeleteExpired(ctx, func(_ models.Metrics, updatedAt time.Time) bool {
+		return updatedAt.Before(cutoff)
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []models.Metrics{gaugeMetric("Old", 1), gaugeMetric("Journal", 1)}, expired)
+
+	// compacted snapshot keeps update time too
+	restored := NewMemStorage(&logger)
+	reopened, err := NewFileStorage(ctx, restored, cfg, &logger)
+	require.NoError(t, err)
+	defer reopened.Close()
+	expired, err = restored.DeleteExpired(ctx, func(_ models.Metrics, updatedAt time.Time) bool {
+		return updatedAt.Before(cutoff)
+	})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []models.Metrics{gaugeMetric("Old", 1), gaugeMetric("Journal", 1)}, expired)
+}
+
+func gaugeMetric(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: models.Gauge, Value: &value}
+}
+
+func counterMetric(id string, delta int64) models.Metrics {
+	return models.Metrics{ID: id, MType: models.Counter, Delta: &delta}
+}
+
+func histogramMetric(id string, bounds []float64, values ...float64) models.Metrics {
+	histogram := models.NewHistogramValue(bounds)
+	for _, value := range values {
+		histogram.Observe(value)
+	}
+	return models.Metrics{ID: id, MType: models.Histogram, Histogram: histogram}
+}
+
+func summaryMetric(id string, values ...float64) models.Metrics {
+	s := sketch.NewDefaultDDSketch()
+	for _, value := range values {
+		_ = s.Add(value)
+	}
+	return models.Metrics{ID: id, MType: models.Summary, Sketch: s}
+}
+
+func setMetric(id string, values ...string) models.Metrics {
+	s := sketch.NewDefaultHyperLogLog()
+	for _, value := range values {
+		s.Add(value)
+	}
+	return models.Metrics{ID: id, MType: models.Set, Set: s}
+}
@@ -0,0 +1,228 @@
+package store
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+)
+
+// defaultMaxSamples сколько последних значений хранится в памяти для одной метрики
+const defaultMaxSamples = 10000
+
+// HistoryStorage обёртка над Storage: Get/GetAll возвращают текущие значения,
+// а каждое сохранение дополнительно записывает значение метрики с отметкой времени в историю.
+// История хранится только для counter и gauge
+type HistoryStorage struct {
+	Storage
+	history MetricsHistoryStorage
+	log     *zerolog.Logger
+}
+
+func NewHistoryStorage(storage Storage, history MetricsHistoryStorage, log *zerolog.Logger) *HistoryStorage {
+	return &HistoryStorage{
+		Storage: storage,
+		history: history,
+		log:     log,
+	}
+}
+
+func (h *HistoryStorage) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	result, err := h.Storage.Save(ctx, metric)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	if !hasHistory(result) {
+		return result, nil
+	}
+
+	if err = h.history.AddSamples(ctx, time.Now(), result); err != nil {
+		h.log.Err(err).Str("func", "*HistoryStorage.Save").Any("metric", result).Msg("error during adding metric sample to history")
+		return models.Metrics{}, err
+	}
+
+	return result, nil
+}
+
+// Delete удаляет метрики вместе с их историей
+func (h *HistoryStorage) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	deleted, err := h.Storage.Delete(ctx, metrics)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = h.history.DeleteSamples(ctx, metrics...); err != nil {
+		h.log.Err(err).Str("func", "*HistoryStorage.Delete").Msg("error during deleting history of metrics")
+		return 0, err
+	}
+	return deleted, nil
+}
+
+// DeleteExpired удаляет устаревшие метрики вместе с историей, а у остальных метрик - значения истории старше срока хранения
+func (h *HistoryStorage) DeleteExpired(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error) {
+	retention, ok := h.Storage.(MetricsRetentionStorage)
+	if !ok {
+		return nil, ErrRetentionNotSupported
+	}
+	deleted, err := retention.DeleteExpired(ctx, expired)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.history.DeleteSamples(ctx, deleted...); err != nil {
+		h.log.Err(err).Str("func", "*HistoryStorage.DeleteExpired").Msg("error during deleting history of expired metrics")
+		return nil, err
+	}
+	if err = h.history.DeleteExpiredSamples(ctx, expired); err != nil {
+		h.log.Err(err).Str("func", "*HistoryStorage.DeleteExpired").Msg("error during deleting expired history samples")
+		return nil, err
+	}
+	return deleted, nil
+}
+
+// Purge удаляет метрики, подходящие под фильтр, вместе с историей
+func (h *HistoryStorage) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	purger, ok := h.Storage.(MetricsPurgeStorage)
+	if !ok {
+		return nil, ErrPurgeNotSupported
+	}
+	deleted, err := purger.Purge(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.history.DeleteSamples(ctx, deleted...); err != nil {
+		h.log.Err(err).Str("func", "*HistoryStorage.Purge").Msg("error during deleting history of purged metrics")
+		return nil, err
+	}
+	return deleted, nil
+}
+
+func (h *HistoryStorage) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	_, err := h.SaveAllReturning(ctx, metrics)
+	return err
+}
+
+// SaveAllReturning сохраняет пачку и записывает в историю итоговые значения, которые вернуло хранилище:
+// перечитывание после сохранения могло бы вернуть уже чужую запись
+func (h *HistoryStorage) SaveAllReturning(ctx context.Context, metrics []models.Metrics) ([]models.Metrics, error) {
+	saved, err := SaveAllReturning(ctx, h.Storage, metrics)
+	if err != nil {
+		return nil, err
+	}
+
+	samples := slices.DeleteFunc(LastBySeries(saved), func(metric models.Metrics) bool {
+		return !hasHistory(metric)
+	})
+	if err = h.history.AddSamples(ctx, time.Now(), samples...); err != nil {
+		h.log.Err(err).Str("func", "*HistoryStorage.SaveAll").Msg("error during adding metric samples to history")
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+// hasHistory пишется ли история метрики: значения гистограмм и скетчей одним числом не выражаются
+func hasHistory(metric models.Metrics) bool {
+	return metric.MType == models.Counter || metric.MType == models.Gauge
+}
+
+// MemHistoryStorage хранит историю значений метрик в памяти.
+// Для каждой метрики хранится не более maxSamples последних значений
+type MemHistoryStorage struct {
+	series     map[string]*memSeries
+	maxSamples int
+	mu         *sync.Mutex
+	log        *zerolog.Logger
+}
+
+// memSeries история одной метрики, значения идут в хронологическом порядке
+type memSeries struct {
+	metric  models.Metrics
+	samples []models.MetricSample
+}
+
+func NewMemHistoryStorage(log *zerolog.Logger) *MemHistoryStorage {
+	return &MemHistoryStorage{
+		series:     make(map[string]*memSeries),
+		maxSamples: defaultMaxSamples,
+		mu:         &sync.Mutex{},
+		log:        log,
+	}
+}
+
+func (m *MemHistoryStorage) AddSamples(ctx context.Context, timestamp time.Time, metrics ...models.Metrics) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, metric := range metrics {
+		key := historyKey(metric)
+		series, ok := m.series[key]
+		if !ok {
+			series = &memSeries{metric: metric}
+			m.series[key] = series
+		}
+		series.samples = append(series.samples, models.NewMetricSample(timestamp, metric))
+		// drop the oldest samples if limit is exceeded
+		if len(series.samples) > m.maxSamples {
+			series.samples = series.samples[len(series.samples)-m.maxSamples:]
+		}
+	}
+
+	return nil
+}
+
+func (m *MemHistoryStorage) GetSamples(ctx context.Context, metric models.Metrics, from, to time.Time) ([]models.MetricSample, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	series, ok := m.series[historyKey(metric)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	result := make([]models.MetricSample, 0)
+	for _, sample := range series.samples {
+		if sample.Timestamp.Before(from) || sample.Timestamp.After(to) {
+			continue
+		}
+		result = append(result, sample)
+	}
+
+	return result, nil
+}
+
+func (m *MemHistoryStorage) DeleteSamples(ctx context.Context, metrics ...models.Metrics) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, metric := range metrics {
+		delete(m.series, historyKey(metric))
+	}
+	return nil
+}
+
+func (m *MemHistoryStorage) DeleteExpiredSamples(ctx context.Context, expired ExpiredFunc) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, series := range m.series {
+		// samples are in chronological order: the expired ones are at the beginning
+		kept := slices.IndexFunc(series.samples, func(sample models.MetricSample) bool {
+			return !expired(series.metric, sample.Timestamp)
+		})
+		if kept == -1 {
+			delete(m.series, key)
+			continue
+		}
+		series.samples = series.samples[kept:]
+	}
+	return nil
+}
+
+func historyKey(metric models.Metrics) string {
+	return metric.SeriesKey()
+}
@@ -0,0 +1,150 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistoryStorage(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Logger()
+	ctx := context.Background()
+	memStorage := NewMemStorage(&logger)
+	history := NewMemHistoryStorage(&logger)
+	storage := NewHistoryStorage(memStorage, history, &logger)
+
+	start := time.Now()
+	_, err := storage.Save(ctx, models.Metrics{ID: "PollCount", MType: models.Counter, Delta: mDelta(2)})
+	require.NoError(t, err)
+	err = storage.SaveAll(ctx, []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(3)},
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+		{ID: "Alloc", MType: models.Gauge, Value: mValue(10.5)},
+	})
+	require.NoError(t, err)
+
+	// current value semantics are kept
+	current, err := storage.Get(ctx, models.Metrics{ID: "PollCount", MType: models.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(10), *current.Delta)
+
+	// history contains resulting value after every save
+	samples, err := history.GetSamples(ctx, models.Metrics{ID: "PollCount", MType: models.Counter}, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 2)
+	assert.Equal(t, int64(2), *samples[0].Delta)
+	assert.Equal(t, int64(10), *samples[1].Delta)
+
+	samples, err = history.GetSamples(ctx, models.Metrics{ID: "Alloc", MType: models.Gauge}, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 10.5, *samples[0].Value)
+
+	_, err = history.GetSamples(ctx, models.Metrics{ID: "Unknown", MType: models.Gauge}, start, time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+// writeOnlyStorage не дает читать метрики: история должна записываться из результатов сохранения
+type writeOnlyStorage struct {
+	*MemStorage
+}
+
+func (s writeOnlyStorage) Get(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	return models.Metrics{}, errors.New("unexpected read")
+}
+
+func TestHistoryStorage_SaveAllReturning(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Logger()
+	ctx := context.Background()
+	history := NewMemHistoryStorage(&logger)
+	storage := NewHistoryStorage(writeOnlyStorage{NewMemStorage(&logger)}, history, &logger)
+
+	start := time.Now()
+	saved, err := storage.SaveAllReturning(ctx, []models.Metrics{
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(3)},
+		{ID: "PollCount", MType: models.Counter, Delta: mDelta(5)},
+		histogramMetric("latency", []float64{1}, 0.5),
+	})
+	require.NoError(t, err)
+	require.Len(t, saved, 3)
+	assert.Equal(t, int64(8), *saved[1].Delta)
+
+	samples, err := history.GetSamples(ctx, models.Metrics{ID: "PollCount", MType: models.Counter}, start, time.Now())
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, int64(8), *samples[0].Delta)
+	_, err = history.GetSamples(ctx, models.Metrics{ID: "latency", MType: models.Histogram}, start, time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func mDelta(v int64) *int64 {
+	return &v
+}
+
+func mValue(v float64) *float64 {
+	return &v
+}
+
+func TestHistoryStorage_Delete(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+	history := NewMemHistoryStorage(&logger)
+	storage := NewHistoryStorage(NewMemStorage(&logger), history, &logger)
+
+	pollCount := models.Metrics{ID: "PollCount", MType: models.Counter}
+	alloc := models.Metrics{ID: "Alloc", MType: models.Gauge}
+	keep := models.Metrics{ID: "keep_gauge", MType: models.Gauge}
+	_, err := storage.Save(ctx, models.Metrics{ID: "PollCount", MType: models.Counter, Delta: mDelta(1)})
+	require.NoError(t, err)
+
+	// deleted metric loses its history
+	deleted, err := storage.Delete(ctx, []models.Metrics{pollCount})
+	require.NoError(t, err)
+	assert.Equal(t, 1, deleted)
+	_, err = history.GetSamples(ctx, pollCount, time.Time{}, time.Now())
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	// samples older than an hour are expired, keep_gauge is kept forever
+	now := time.Now()
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, time.Minute} {
+		value := float64(age / time.Minute)
+		require.NoError(t, history.AddSamples(ctx, now.Add(-age),
+			models.Metrics{ID: "Alloc", MType: models.Gauge, Value: &value},
+			models.Metrics{ID: "keep_gauge", MType: models.Gauge, Value: &value},
+		))
+	}
+	_, err = storage.DeleteExpired(ctx, func(metric models.Metrics, updatedAt time.Time) bool {
+		return metric.ID != keep.ID && now.Sub(updatedAt) > time.Hour
+	})
+	require.NoError(t, err)
+
+	samples, err := history.GetSamples(ctx, alloc, time.Time{}, now)
+	require.NoError(t, err)
+	require.Len(t, samples, 1)
+	assert.Equal(t, 1.0, *samples[0].Value)
+	samples, err = history.GetSamples(ctx, keep, time.Time{}, now)
+	require.NoError(t, err)
+	assert.Len(t, samples, 3)
+}
+
+func TestExpiredBefore(t *testing.T) {
+	now := time.Now()
+	expired := func(metric models.Metrics, updatedAt time.Time) bool {
+		return now.Sub(updatedAt) > time.Hour
+	}
+	metric := models.Metrics{ID: "Alloc", MType: models.Gauge}
+
+	assert.True(t, expiredBefore(expired, metric, now.Add(-time.Minute), now).IsZero())
+	assert.Equal(t, now.Add(-2*time.Hour+time.Microsecond), expiredBefore(expired, metric, now.Add(-3*time.Hour), now.Add(-2*time.Hour)))
+
+	cutoff := expiredBefore(expired, metric, now.Add(-24*time.Hour), now)
+	assert.True(t, expired(metric, cutoff.Add(-time.Microsecond)))
+	assert.WithinDuration(t, now.Add(-time.Hour), cutoff, time.Second)
+}
@@ -2,6 +2,7 @@ package store
 
 import (
 	"context"
+	"time"
 
 	"github.com/MKhiriev/stunning-adventure/models"
 )
@@ -11,11 +12,20 @@ type Storage interface {
 	SaveAll(context.Context, []models.Metrics) error
 	Get(context.Context, models.Metrics) (models.Metrics, error)
 	GetAll(context.Context) ([]models.Metrics, error)
+	Delete(context.Context, []models.Metrics) (int, error)
+}
+
+// MetricsBatchStorage хранилище, которое возвращает значения метрик пачки после сохранения (как RETURNING в БД),
+// чтобы их не приходилось перечитывать отдельными запросами
+type MetricsBatchStorage interface {
+	SaveAllReturning(context.Context, []models.Metrics) ([]models.Metrics, error)
 }
 
 type MetricsFileStorage interface {
 	SaveMetricsToFile(context.Context, []models.Metrics) error
 	LoadMetricsFromFile(context.Context) ([]models.Metrics, error)
+	AppendMetricsToLog(context.Context, ...models.Metrics) error
+	LogSize() int64
 }
 
 type MetricsCacheStorage interface {
@@ -29,6 +39,25 @@ type MetricsDatabaseStorage interface {
 	Migrate(context.Context) error
 }
 
+type MetricsHistoryStorage interface {
+	AddSamples(ctx context.Context, timestamp time.Time, metrics ...models.Metrics) error
+	GetSamples(ctx context.Context, metric models.Metrics, from, to time.Time) ([]models.MetricSample, error)
+	// DeleteSamples удаляет всю историю метрик
+	DeleteSamples(ctx context.Context, metrics ...models.Metrics) error
+	// DeleteExpiredSamples удаляет значения, для которых expired по времени значения возвращает true
+	DeleteExpiredSamples(ctx context.Context, expired ExpiredFunc) error
+}
+
+// MetricsRetentionStorage хранилище, которое помнит время последнего обновления каждой метрики
+type MetricsRetentionStorage interface {
+	DeleteExpired(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error)
+}
+
+// MetricsPurgeStorage хранилище, которое само отбирает метрики по фильтру и удаляет их за одну операцию
+type MetricsPurgeStorage interface {
+	Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error)
+}
+
 type ErrorClassificator interface {
 	Classify(err error) ErrorClassification
 }
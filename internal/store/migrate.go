@@ -0,0 +1,300 @@
+package store
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// migrationLockID ключ advisory lock, под которым применяются миграции
+const migrationLockID = int64(20250101)
+
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var ErrNoMigration = errors.New("no migration to roll back")
+
+// Migration пронумерованная миграция схемы БД
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus состояние миграции: AppliedAt пустое, если миграция еще не применена
+type MigrationStatus struct {
+	Version   int64
+	Name      string
+	AppliedAt *time.Time
+}
+
+// Migrator применяет миграции из директории schema в порядке версий.
+// Примененные версии записываются в таблицу schema_migrations, каждая миграция выполняется в отдельной транзакции,
+// а одновременный запуск с нескольких серверов исключается advisory lock
+type Migrator struct {
+	db         *sql.DB
+	migrations []Migration
+	dialect    migrationDialect
+	log        *zerolog.Logger
+}
+
+// migrationDialect запросы Migrator, которые отличаются в разных СУБД
+type migrationDialect struct {
+	lock        string // empty if database needs no lock
+	unlock      string
+	createTable string
+}
+
+var (
+	postgresMigrationDialect = migrationDialect{
+		lock:   `select pg_advisory_lock($1)`,
+		unlock: `select pg_advisory_unlock($1)`,
+		createTable: `
+create table if not exists schema_migrations
+(
+    version    bigint primary key,
+    name       text not null,
+    applied_at timestamptz not null default now()
+);`,
+	}
+	// SQLite сам блокирует файл базы на время транзакции
+	sqliteMigrationDialect = migrationDialect{
+		createTable: `
+create table if not exists schema_migrations
+(
+    version    integer primary key,
+    name       text not null,
+    applied_at timestamp not null default current_timestamp
+);`,
+	}
+)
+
+func NewMigrator(db *sql.DB, migrations fs.FS, log *zerolog.Logger) (*Migrator, error) {
+	return newMigrator(db, migrations, postgresMigrationDialect, log)
+}
+
+// NewSQLiteMigrator создает Migrator для базы SQLite
+func NewSQLiteMigrator(db *sql.DB, migrations fs.FS, log *zerolog.Logger) (*Migrator, error) {
+	return newMigrator(db, migrations, sqliteMigrationDialect, log)
+}
+
+func newMigrator(db *sql.DB, migrations fs.FS, dialect migrationDialect, log *zerolog.Logger) (*Migrator, error) {
+	loaded, err := LoadMigrations(migrations)
+	if err != nil {
+		log.Err(err).Str("func", "store.NewMigrator").Msg("error loading migrations")
+		return nil, err
+	}
+
+	return &Migrator{
+		db:         db,
+		migrations: loaded,
+		dialect:    dialect,
+		log:        log,
+	}, nil
+}
+
+// LoadMigrations читает файлы `<version>_<name>.up.sql` и `<version>_<name>.down.sql` и сортирует миграции по версии
+func LoadMigrations(migrations fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(migrations, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error reading migrations: %w", err)
+	}
+
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %q: %w", entry.Name(), err)
+		}
+		query, err := fs.ReadFile(migrations, entry.Name())
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %q: %w", entry.Name(), err)
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration version %d has different names: %q and %q", version, migration.Name, match[2])
+		}
+		if match[3] == "up" {
+			migration.Up = string(query)
+		} else {
+			migration.Down = string(query)
+		}
+	}
+
+	result := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up file", migration.Version, migration.Name)
+		}
+		result = append(result, *migration)
+	}
+	slices.SortFunc(result, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+
+	return result, nil
+}
+
+// Up применяет все не примененные миграции
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range m.migrations {
+			if _, ok := applied[migration.Version]; ok {
+				continue
+			}
+
+			m.log.Info().Str("func", "*Migrator.Up").Int64("version", migration.Version).Str("name", migration.Name).Msg("applying migration")
+			err = m.inTx(ctx, conn, migration.Up, `insert into schema_migrations (version, name) values ($1, $2)`, migration.Version, migration.Name)
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+		}
+
+		return nil
+	})
+}
+
+// Down откатывает последнюю примененную миграцию
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for _, migration := range slices.Backward(m.migrations) {
+			if _, ok := applied[migration.Version]; !ok {
+				continue
+			}
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down file", migration.Version, migration.Name)
+			}
+
+			m.log.Info().Str("func", "*Migrator.Down").Int64("version", migration.Version).Str("name", migration.Name).Msg("rolling back migration")
+			err = m.inTx(ctx, conn, migration.Down, `delete from schema_migrations where version = $1`, migration.Version)
+			if err != nil {
+				return fmt.Errorf("error rolling back migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			return nil
+		}
+
+		return ErrNoMigration
+	})
+}
+
+// Status возвращает состояние всех известных миграций
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	var statuses []MigrationStatus
+	err := m.withLock(ctx, func(conn *sql.Conn) error {
+		applied, err := m.applied(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		statuses = make([]MigrationStatus, 0, len(m.migrations))
+		for _, migration := range m.migrations {
+			status := MigrationStatus{Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+		return nil
+	})
+
+	return statuses, err
+}
+
+// withLock выполняет fn на отдельном соединении под advisory lock (если СУБД его требует)
+func (m *Migrator) withLock(ctx context.Context, fn func(conn *sql.Conn) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		m.log.Err(err).Str("func", "*Migrator.withLock").Msg("error getting database connection")
+		return fmt.Errorf("error getting database connection: %w", err)
+	}
+	defer conn.Close()
+
+	if m.dialect.lock != "" {
+		if _, err = conn.ExecContext(ctx, m.dialect.lock, migrationLockID); err != nil {
+			m.log.Err(err).Str("func", "*Migrator.withLock").Msg("error acquiring migration lock")
+			return fmt.Errorf("error acquiring migration lock: %w", err)
+		}
+		defer func() {
+			if _, err := conn.ExecContext(context.WithoutCancel(ctx), m.dialect.unlock, migrationLockID); err != nil {
+				m.log.Err(err).Str("func", "*Migrator.withLock").Msg("error releasing migration lock")
+			}
+		}()
+	}
+
+	_, err = conn.ExecContext(ctx, m.dialect.createTable)
+	if err != nil {
+		m.log.Err(err).Str("func", "*Migrator.withLock").Msg("error while creating `schema_migrations` table")
+		return fmt.Errorf("error while creating schema_migrations table: %w", err)
+	}
+
+	return fn(conn)
+}
+
+// applied возвращает время применения каждой примененной версии
+func (m *Migrator) applied(ctx context.Context, conn *sql.Conn) (map[int64]time.Time, error) {
+	rows, err := conn.QueryContext(ctx, `select version, applied_at from schema_migrations`)
+	if err != nil {
+		m.log.Err(err).Str("func", "*Migrator.applied").Msg("error getting applied migrations")
+		return nil, fmt.Errorf("error getting applied migrations: %w", err)
+	}
+	defer rows.Close()
+
+	applied := make(map[int64]time.Time)
+	for rows.Next() {
+		var version int64
+		var appliedAt time.Time
+		if err = rows.Scan(&version, &appliedAt); err != nil {
+			return nil, fmt.Errorf("error scanning applied migration: %w", err)
+		}
+		applied[version] = appliedAt
+	}
+
+	return applied, rows.Err()
+}
+
+// inTx выполняет миграцию и запись о ней в schema_migrations в одной транзакции
+func (m *Migrator) inTx(ctx context.Context, conn *sql.Conn, query string, record string, args ...any) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err = tx.ExecContext(ctx, query); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, record, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,84 @@
+package store
+
+import (
+	"io/fs"
+	"testing"
+	"testing/fstest"
+
+	"github.com/MKhiriev/stunning-adventure/schema"
+	sqliteschema "github.com/MKhiriev/stunning-adventure/schema/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadMigrations(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   fstest.MapFS
+		want    []Migration
+		wantErr bool
+	}{
+		{
+			name: "migrations are sorted by version",
+			files: fstest.MapFS{
+				"10_add_index.up.sql": {Data: []byte("create index")},
+				"2_create.up.sql":     {Data: []byte("create table")},
+				"2_create.down.sql":   {Data: []byte("drop table")},
+				"schema.go":           {Data: []byte("package schema")},
+			},
+			want: []Migration{
+				{Version: 2, Name: "create", Up: "create table", Down: "drop table"},
+				{Version: 10, Name: "add_index", Up: "create index"},
+			},
+		},
+		{
+			name:    "no up file",
+			files:   fstest.MapFS{"1_init.down.sql": {Data: []byte("drop table")}},
+			wantErr: true,
+		},
+		{
+			name: "different names for one version",
+			files: fstest.MapFS{
+				"1_init.up.sql":     {Data: []byte("create table")},
+				"1_create.up.sql":   {Data: []byte("create table")},
+				"1_init.down.sql":   {Data: []byte("drop table")},
+				"2_labels.up.sql":   {Data: []byte("alter table")},
+				"2_labels.down.sql": {Data: []byte("alter table")},
+			},
+			wantErr: true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := LoadMigrations(test.files)
+			if test.wantErr {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, test.want, migrations)
+		})
+	}
+}
+
+func TestSchemaMigrations(t *testing.T) {
+	tests := []struct {
+		name       string
+		migrations fs.FS
+	}{
+		{name: "postgres", migrations: schema.Migrations},
+		{name: "sqlite", migrations: sqliteschema.Migrations},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			migrations, err := LoadMigrations(test.migrations)
+			require.NoError(t, err)
+			require.NotEmpty(t, migrations)
+
+			for i, migration := range migrations {
+				assert.Equal(t, int64(i+1), migration.Version, "migration versions must have no gaps")
+				assert.NotEmpty(t, migration.Down, "migration %d_%s must have down file", migration.Version, migration.Name)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package store
+
+import (
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/models"
+)
+
+// ExpiredFunc решает по времени последнего обновления, пора ли удалить метрику
+type ExpiredFunc func(metric models.Metrics, updatedAt time.Time) bool
+
+// expiredBefore время, раньше которого значения истории метрики устарели, или нулевое время, если устаревших нет.
+// expired монотонна по времени (чем старше значение, тем оно устаревшее), поэтому граница ищется
+// двоичным поиском между самым старым и самым новым значением с точностью до секунды
+func expiredBefore(expired ExpiredFunc, metric models.Metrics, oldest, newest time.Time) time.Time {
+	if !expired(metric, oldest) {
+		return time.Time{}
+	}
+	if expired(metric, newest) {
+		return newest.Add(time.Microsecond)
+	}
+
+	// expired at lo, not expired at hi
+	lo, hi := oldest, newest
+	for hi.Sub(lo) > time.Second {
+		mid := lo.Add(hi.Sub(lo) / 2)
+		if expired(metric, mid) {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+	return lo.Add(time.Microsecond)
+}
+
+// timedMetric метрика вместе со временем последнего обновления - так метрики хранятся в файле и в bolt.
+// У записей, сделанных до появления updated_at, время пустое
+type timedMetric struct {
+	models.Metrics
+	UpdatedAt time.Time `json:"updated_at,omitzero"`
+}
@@ -3,30 +3,70 @@ package store
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"time"
 
 	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
 	"github.com/MKhiriev/stunning-adventure/models"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/MKhiriev/stunning-adventure/schema"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog"
 )
 
 const (
-	insertMetricsQuery = `INSERT INTO metrics (id, type, delta, value) 
-VALUES ($1, $2, $3, $4)
-ON CONFLICT (id, type) DO 
+	insertMetricsQuery = `INSERT INTO metrics (id, type, labels, delta, value) 
+VALUES ($1, $2, $3::jsonb, $4, $5)
+ON CONFLICT (id, type, labels) DO 
 UPDATE SET 
            value = EXCLUDED.value,
-           delta = metrics.delta + EXCLUDED.delta
-RETURNING *;`
-	getMetric     = `SELECT * FROM metrics WHERE id=$1 AND type=$2;`
-	getAllMetrics = `SELECT * FROM metrics;`
+           delta = metrics.delta + EXCLUDED.delta,
+           updated_at = now()
+RETURNING id, type, labels, delta, value, histogram, sketch;`
+	// histograms and sketches are merged in code: new row is inserted as is, existing row is locked, merged and updated
+	insertMergeableQuery = `INSERT INTO metrics (id, type, labels, histogram, sketch)
+VALUES ($1, $2, $3::jsonb, $4::jsonb, $5)
+ON CONFLICT (id, type, labels) DO NOTHING
+RETURNING id, type, labels, delta, value, histogram, sketch;`
+	getMetricForUpdate   = `SELECT id, type, labels, delta, value, histogram, sketch FROM metrics WHERE id=$1 AND type=$2 AND labels=$3::jsonb FOR UPDATE;`
+	updateMergeableQuery = `UPDATE metrics SET histogram = $4::jsonb, sketch = $5, updated_at = now() WHERE id=$1 AND type=$2 AND labels=$3::jsonb
+RETURNING id, type, labels, delta, value, histogram, sketch;`
+	getMetric              = `SELECT id, type, labels, delta, value, histogram, sketch FROM metrics WHERE id=$1 AND type=$2 AND labels=$3::jsonb;`
+	getAllMetrics          = `SELECT id, type, labels, delta, value, histogram, sketch FROM metrics;`
+	getAllMetricsUpdatedAt = `SELECT id, type, labels, delta, value, histogram, sketch, updated_at FROM metrics;`
+	// metric is deleted only if it was not updated after it had been read
+	deleteExpiredMetrics = `DELETE FROM metrics WHERE (id, type, labels, updated_at) IN (
+    SELECT id, type, labels::jsonb, updated_at FROM unnest($1::text[], $2::text[], $3::text[], $4::timestamptz[]) AS d (id, type, labels, updated_at)
+) RETURNING id, type, labels, delta, value, histogram, sketch;`
+	deleteMetrics = `DELETE FROM metrics WHERE (id, type, labels) IN (
+    SELECT id, type, labels::jsonb FROM unnest($1::text[], $2::text[], $3::text[]) AS d (id, type, labels)
+);`
+	// type, name prefix and labels are filtered by the query, regex is checked by the filter itself:
+	// Go and PostgreSQL regular expressions differ
+	getPurgeCandidates = `SELECT id, type, labels, delta, value, histogram, sketch FROM metrics
+WHERE ($1 = '' OR type = $1) AND left(id, length($2)) = $2 AND labels @> $3::jsonb FOR UPDATE;`
+	deletePurgedMetrics = `DELETE FROM metrics WHERE (id, type, labels) IN (
+    SELECT id, type, labels::jsonb FROM unnest($1::text[], $2::text[], $3::text[]) AS d (id, type, labels)
+) RETURNING id, type, labels, delta, value, histogram, sketch;`
+
+	insertMetricSampleQuery = `INSERT INTO metrics_history (id, type, labels, delta, value, ts) VALUES ($1, $2, $3::jsonb, $4, $5, $6);`
+	getMetricSamples        = `SELECT ts, delta, value FROM metrics_history WHERE id=$1 AND type=$2 AND labels=$3::jsonb AND ts BETWEEN $4 AND $5 ORDER BY ts;`
+	getSampleRanges         = `SELECT id, type, labels, MIN(ts), MAX(ts) FROM metrics_history GROUP BY id, type, labels;`
+	deleteMetricSamples     = `DELETE FROM metrics_history WHERE (id, type, labels) IN (
+    SELECT id, type, labels::jsonb FROM unnest($1::text[], $2::text[], $3::text[]) AS d (id, type, labels)
+);`
+	deleteExpiredSamples = `DELETE FROM metrics_history h
+USING unnest($1::text[], $2::text[], $3::text[], $4::timestamptz[]) AS d (id, type, labels, expired_before)
+WHERE h.id = d.id AND h.type = d.type AND h.labels = d.labels::jsonb AND h.ts < d.expired_before;`
 )
 
 type DB struct {
 	*sql.DB
+	pool               *pgxpool.Pool // native pool for batch operations, *sql.DB works on top of it
 	errorClassificator ErrorClassificator
 	logger             *zerolog.Logger
 	retryIntervals     map[int]time.Duration
@@ -34,21 +74,31 @@ type DB struct {
 }
 
 func NewConnectPostgres(ctx context.Context, cfg *config.ServerConfig, log *zerolog.Logger) (*DB, error) {
+	poolConfig, err := newPoolConfig(cfg)
+	if err != nil {
+		log.Err(err).Str("func", "NewConnectPostgres").Msg("error occured during parsing database connection string")
+		return nil, fmt.Errorf("error occured during parsing database connection string: %w", err)
+	}
+
 	// establish connection
-	conn, err := sql.Open("pgx", cfg.DatabaseDSN)
+	pool, err := pgxpool.NewWithConfig(ctx, poolConfig)
 	if err != nil {
 		log.Err(err).Str("func", "NewConnectPostgres").Msg("error occured during database connection")
 		return nil, fmt.Errorf("error occured during database connection: %w", err)
 	}
+	conn := stdlib.OpenDBFromPool(pool)
 	err = conn.PingContext(ctx)
 	if err != nil {
 		log.Err(err).Str("func", "NewConnectPostgres").Msg("error connecting database (ping)")
+		conn.Close()
+		pool.Close()
 		return nil, err
 	}
-	log.Info().Str("func", "NewConnectPostgres").Msg("connected to database successfully")
+	log.Info().Str("func", "NewConnectPostgres").Int32("max conns", poolConfig.MaxConns).Msg("connected to database successfully")
 	// construct a DB struct
 	db := &DB{
 		DB:                 conn,
+		pool:               pool,
 		logger:             log,
 		errorClassificator: NewPostgresErrorClassifier(),
 		// add retry mechanism
@@ -62,12 +112,42 @@ func NewConnectPostgres(ctx context.Context, cfg *config.ServerConfig, log *zero
 	if err := db.Migrate(ctx); err != nil {
 		log.Err(err).Str("func", "NewConnectPostgres").Msg("failed migration")
 		// if there is no `metrics` table then there is no need to use db
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
 
+// newPoolConfig настраивает размер пула соединений. Нулевые значения - значения pgxpool по умолчанию
+func newPoolConfig(cfg *config.ServerConfig) (*pgxpool.Config, error) {
+	poolConfig, err := pgxpool.ParseConfig(cfg.DatabaseDSN)
+	if err != nil {
+		return nil, err
+	}
+
+	if cfg.DatabaseMaxConns > 0 {
+		poolConfig.MaxConns = int32(cfg.DatabaseMaxConns)
+	}
+	if cfg.DatabaseMinConns > 0 {
+		poolConfig.MinConns = min(int32(cfg.DatabaseMinConns), poolConfig.MaxConns)
+	}
+	if cfg.DatabaseMaxConnLifetime > 0 {
+		poolConfig.MaxConnLifetime = time.Duration(cfg.DatabaseMaxConnLifetime) * time.Second
+	}
+
+	return poolConfig, nil
+}
+
+// Close закрывает соединения с БД
+func (db *DB) Close() error {
+	err := db.DB.Close()
+	if db.pool != nil {
+		db.pool.Close()
+	}
+	return err
+}
+
 func (db *DB) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
 	var result models.Metrics
 	err := db.withRetry(ctx, "*DB.Save", func() error {
@@ -79,11 +159,19 @@ func (db *DB) Save(ctx context.Context, metric models.Metrics) (models.Metrics,
 }
 
 func (db *DB) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	_, err := db.SaveAllReturning(ctx, metrics)
+	return err
+}
+
+// SaveAllReturning сохраняет метрики пачкой и возвращает строки RETURNING в порядке сохранения
+func (db *DB) SaveAllReturning(ctx context.Context, metrics []models.Metrics) ([]models.Metrics, error) {
+	var result []models.Metrics
 	err := db.withRetry(ctx, "*DB.SaveAll", func() error {
-		saveAllErr := db.saveAllMetrics(ctx, metrics)
+		var saveAllErr error
+		result, saveAllErr = db.saveAllMetrics(ctx, metrics)
 		return saveAllErr
 	})
-	return err
+	return result, err
 }
 
 func (db *DB) Get(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
@@ -110,20 +198,82 @@ func (db *DB) GetAll(ctx context.Context) ([]models.Metrics, error) {
 	return result, err
 }
 
+// Delete удаляет метрики одним запросом. Возвращает количество удаленных метрик
+func (db *DB) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	var deleted int
+	err := db.withRetry(ctx, "*DB.Delete", func() error {
+		var deleteErr error
+		deleted, deleteErr = db.deleteMetrics(ctx, metrics)
+		return deleteErr
+	})
+	return deleted, err
+}
+
+// DeleteExpired удаляет метрики, для которых expired возвращает true, и возвращает их
+func (db *DB) DeleteExpired(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error) {
+	var deleted []models.Metrics
+	err := db.withRetry(ctx, "*DB.DeleteExpired", func() error {
+		var deleteErr error
+		deleted, deleteErr = db.deleteExpiredMetrics(ctx, expired)
+		return deleteErr
+	})
+	return deleted, err
+}
+
+// Purge удаляет все метрики, подходящие под фильтр, и возвращает их. Отбор и удаление выполняются в одной транзакции
+func (db *DB) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []models.Metrics
+	err = db.withRetry(ctx, "*DB.Purge", func() error {
+		var purgeErr error
+		deleted, purgeErr = db.purgeMetrics(ctx, filter, match)
+		return purgeErr
+	})
+	return deleted, err
+}
+
+func (db *DB) AddSamples(ctx context.Context, timestamp time.Time, metrics ...models.Metrics) error {
+	return db.withRetry(ctx, "*DB.AddSamples", func() error {
+		return db.addSamples(ctx, timestamp, metrics)
+	})
+}
+
+func (db *DB) GetSamples(ctx context.Context, metric models.Metrics, from, to time.Time) ([]models.MetricSample, error) {
+	var result []models.MetricSample
+	err := db.withRetry(ctx, "*DB.GetSamples", func() error {
+		var getSamplesErr error
+		result, getSamplesErr = db.getSamples(ctx, metric, from, to)
+		return getSamplesErr
+	})
+	return result, err
+}
+
+func (db *DB) DeleteSamples(ctx context.Context, metrics ...models.Metrics) error {
+	return db.withRetry(ctx, "*DB.DeleteSamples", func() error {
+		return db.deleteSamples(ctx, metrics)
+	})
+}
+
+func (db *DB) DeleteExpiredSamples(ctx context.Context, expired ExpiredFunc) error {
+	return db.withRetry(ctx, "*DB.DeleteExpiredSamples", func() error {
+		return db.deleteExpiredSamples(ctx, expired)
+	})
+}
+
+// Migrate применяет не примененные миграции из директории schema
 func (db *DB) Migrate(ctx context.Context) error {
-	query := `  
-create table if not exists metrics  
-(  
-    id  text not null,   
-    type  text not null,    
-    delta bigint default null,    
-    value double precision,    
-    primary key (id, type)
-);`
-	_, err := db.ExecContext(ctx, query)
+	migrator, err := NewMigrator(db.DB, schema.Migrations, db.logger)
 	if err != nil {
-		db.logger.Err(err).Str("func", "*DB.Migrate").Msg("error while creating `metrics` table")
-		return fmt.Errorf("error while creating metrics table: %w", err)
+		return err
+	}
+
+	if err = migrator.Up(ctx); err != nil {
+		db.logger.Err(err).Str("func", "*DB.Migrate").Msg("error while applying migrations")
+		return fmt.Errorf("error while applying migrations: %w", err)
 	}
 
 	return nil
@@ -148,21 +298,38 @@ func (db *DB) withRetry(ctx context.Context, operation string, fn func() error)
 }
 
 func (db *DB) saveMetric(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
-	if metric.MType == models.Gauge || metric.MType == models.Counter {
+	switch metric.MType {
+	case models.Gauge, models.Counter:
 		db.logger.Info().Str("func", "*DB.saveMetric").Any("metric", metric).Msg("trying to save metric")
 		// save metric in db
-		row := db.QueryRowContext(ctx, insertMetricsQuery, metric.ID, metric.MType, metric.Delta, metric.Value)
-		if err := row.Err(); err != nil {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			db.logger.Error().Err(err).Str("func", "*DB.saveMetric").Msg("error: encoding labels")
+			return models.Metrics{}, err
+		}
+
+		row := db.QueryRowContext(ctx, insertMetricsQuery, metric.ID, metric.MType, labels, metric.Delta, metric.Value)
+		if err = row.Err(); err != nil {
 			db.logger.Error().Err(err).Str("func", "*DB.saveMetric").Msg("error: row is nil")
 			return models.Metrics{}, err
 		}
 
 		// scan saved metric from db
-		if err := row.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value); err != nil {
+		if metric, err = scanMetric(row); err != nil {
 			db.logger.Error().Err(err).Str("func", "*DB.saveMetric").Msg("error: scanning error")
 			return models.Metrics{}, err
 		}
-	} else {
+	case models.Histogram, models.Summary, models.Set:
+		db.logger.Info().Str("func", "*DB.saveMetric").Any("metric", metric).Msg("trying to merge metric")
+		err := db.inTx(ctx, "*DB.saveMetric", func(tx pgx.Tx) error {
+			var mergeErr error
+			metric, mergeErr = mergeMetric(ctx, tx, metric)
+			return mergeErr
+		})
+		if err != nil {
+			return models.Metrics{}, err
+		}
+	default:
 		db.logger.Error().Str("func", "*DB.saveMetric").Any("metric", metric).Msg("unsupported metric type was passed")
 		return models.Metrics{}, errors.New("unsupported metric type was passed")
 	}
@@ -171,57 +338,105 @@ func (db *DB) saveMetric(ctx context.Context, metric models.Metrics) (models.Met
 	return metric, nil
 }
 
-func (db *DB) saveAllMetrics(ctx context.Context, metrics []models.Metrics) error {
-	// begin transaction
-	tx, err := db.BeginTx(ctx, nil)
+// saveAllMetrics сохраняет метрики одной пачкой запросов (pgx.Batch) в транзакции - за один обмен с БД.
+// Гистограммы и скетчи объединяются с сохраненными после пачки, в той же транзакции.
+// Возвращаются сохраненные значения: сначала counter и gauge в порядке пачки, затем объединяемые метрики
+func (db *DB) saveAllMetrics(ctx context.Context, metrics []models.Metrics) ([]models.Metrics, error) {
+	batch := &pgx.Batch{}
+	var mergeable []models.Metrics
+	for idx, metric := range metrics {
+		if models.IsMergeable(metric.MType) {
+			mergeable = append(mergeable, metric)
+			continue
+		}
+		if metric.MType != models.Gauge && metric.MType != models.Counter {
+			db.logger.Error().Str("func", "*DB.saveAllMetrics").Any("metric", metric).Int("iteration", idx).Msg("unsupported metric type was passed")
+			return nil, errors.New("unsupported metric type was passed")
+		}
+
+		labels, labelsErr := encodeLabels(metric.Labels)
+		if labelsErr != nil {
+			db.logger.Err(labelsErr).Str("func", "*DB.saveAllMetrics").Any("metric", metric).Int("iteration", idx).Msg("error encoding metric labels")
+			return nil, labelsErr
+		}
+		batch.Queue(insertMetricsQuery, metric.ID, metric.MType, labels, metric.Delta, metric.Value)
+	}
+	db.logger.Info().Str("func", "*DB.saveAllMetrics").Int("metrics", batch.Len()).Int("mergeable", len(mergeable)).Msg("trying to save metrics")
+	if batch.Len() == 0 && len(mergeable) == 0 {
+		return nil, nil
+	}
+
+	var saved []models.Metrics
+	err := db.inTx(ctx, "*DB.saveAllMetrics", func(tx pgx.Tx) error {
+		var err error
+		if saved, err = scanBatch(ctx, tx, "*DB.saveAllMetrics", batch, db.logger); err != nil {
+			return err
+		}
+		for _, metric := range mergeable {
+			merged, err := mergeMetric(ctx, tx, metric)
+			if err != nil {
+				db.logger.Err(err).Str("func", "*DB.saveAllMetrics").Any("metric", metric).Msg("error merging metric")
+				return err
+			}
+			saved = append(saved, merged)
+		}
+		return nil
+	})
 	if err != nil {
-		db.logger.Err(err).Str("func", "*DB.saveAllMetrics").Msg("error during opening transaction")
-		return fmt.Errorf("error during opening transaction: %w", err)
+		return nil, err
 	}
-	defer tx.Rollback()
 
-	// prepare context
-	stmt, err := tx.PrepareContext(ctx, insertMetricsQuery)
+	return saved, nil
+}
+
+// mergeMetric объединяет гистограмму или скетч с сохраненными. Строка блокируется до конца транзакции,
+// поэтому одновременные обновления не теряются
+func mergeMetric(ctx context.Context, tx pgx.Tx, metric models.Metrics) (models.Metrics, error) {
+	labels, err := encodeLabels(metric.Labels)
 	if err != nil {
-		db.logger.Err(err).Str("func", "*DB.saveAllMetrics").Msg("error during preparing context")
-		return err
+		return models.Metrics{}, err
+	}
+	histogram, sketchData, err := encodeMergeable(metric)
+	if err != nil {
+		return models.Metrics{}, err
 	}
-	defer stmt.Close()
 
-	// for each metric
-	for idx, metric := range metrics {
-		// save metric
-		var result sql.Result
-		var statementExecutionError error
-		if metric.MType == models.Gauge || metric.MType == models.Counter {
-			db.logger.Info().Str("func", "*DB.saveAllMetrics").Any("metric", metric).Int("iteration", idx).Msg("trying to save metric")
-			result, statementExecutionError = stmt.ExecContext(ctx, metric.ID, metric.MType, metric.Delta, metric.Value)
-			if statementExecutionError != nil {
-				db.logger.Err(statementExecutionError).Str("func", "*DB.saveAllMetrics").Any("metric", metric).Int("iteration", idx).Msg("error executing prepared UPSERT query for saving metric")
-				return statementExecutionError
-			}
-		} else {
-			db.logger.Error().Str("func", "*DB.saveAllMetrics").Any("metric", metric).Int("iteration", idx).Msg("unsupported metric type was passed")
-			return errors.New("unsupported metric type was passed")
-		}
+	// new metric is saved as is
+	saved, err := scanMetric(tx.QueryRow(ctx, insertMergeableQuery, metric.ID, metric.MType, labels, histogram, sketchData))
+	if err == nil {
+		return saved, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return models.Metrics{}, err
+	}
 
-		rowsAffected, _ := result.RowsAffected()
-		if rowsAffected == 0 {
-			db.logger.Err(err).Str("func", "*DB.saveAllMetrics").Msg("metric was not updated")
-			return err
-		}
+	stored, err := scanMetric(tx.QueryRow(ctx, getMetricForUpdate, metric.ID, metric.MType, labels))
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	merged, err := stored.Merge(metric)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+	if histogram, sketchData, err = encodeMergeable(merged); err != nil {
+		return models.Metrics{}, err
 	}
-	// commit transaction if all metrics are successfully updated
 
-	return tx.Commit()
+	return scanMetric(tx.QueryRow(ctx, updateMergeableQuery, metric.ID, metric.MType, labels, histogram, sketchData))
 }
 
 func (db *DB) getMetric(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
 	db.logger.Info().Str("func", "*DB.getMetric").Any("metric to find", metric).Msg("trying to find metric")
-	// query row with given name and type
-	row := db.QueryRowContext(ctx, getMetric, metric.ID, metric.MType)
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.getMetric").Msg("error encoding metric labels")
+		return models.Metrics{}, err
+	}
+
+	// query row with given name, type and labels
+	row := db.QueryRowContext(ctx, getMetric, metric.ID, metric.MType, labels)
 	// scan resulting row
-	err := row.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+	metric, err = scanMetric(row)
 	// check for error type
 	switch {
 	case errors.Is(err, sql.ErrNoRows):
@@ -245,8 +460,7 @@ func (db *DB) getAllMetrics(ctx context.Context) ([]models.Metrics, error) {
 
 	var all []models.Metrics
 	for rows.Next() {
-		var metric models.Metrics
-		err = rows.Scan(&metric.ID, &metric.MType, &metric.Delta, &metric.Value)
+		metric, err := scanMetric(rows)
 		if err != nil {
 			db.logger.Err(err).Str("func", "*DB.getAllMetrics").Msg("error during getting values from row")
 			return nil, err
@@ -263,6 +477,348 @@ func (db *DB) getAllMetrics(ctx context.Context) ([]models.Metrics, error) {
 	return all, nil
 }
 
+func (db *DB) deleteMetrics(ctx context.Context, metrics []models.Metrics) (int, error) {
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+
+	ids := make([]string, 0, len(metrics))
+	types := make([]string, 0, len(metrics))
+	labels := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		encoded, err := encodeLabels(metric.Labels)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*DB.deleteMetrics").Any("metric", metric).Msg("error encoding metric labels")
+			return 0, err
+		}
+		ids = append(ids, metric.ID)
+		types = append(types, metric.MType)
+		labels = append(labels, encoded)
+	}
+
+	tag, err := db.pool.Exec(ctx, deleteMetrics, ids, types, labels)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteMetrics").Msg("error during query execution")
+		return 0, err
+	}
+
+	return int(tag.RowsAffected()), nil
+}
+
+func (db *DB) deleteExpiredMetrics(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error) {
+	rows, err := db.QueryContext(ctx, getAllMetricsUpdatedAt)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteExpiredMetrics").Msg("error during query execution")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids, types, labels []string
+	var updated []time.Time
+	for rows.Next() {
+		var metric models.Metrics
+		var encodedLabels, histogram, sketchData []byte
+		var updatedAt time.Time
+		if err = rows.Scan(&metric.ID, &metric.MType, &encodedLabels, &metric.Delta, &metric.Value, &histogram, &sketchData, &updatedAt); err != nil {
+			db.logger.Err(err).Str("func", "*DB.deleteExpiredMetrics").Msg("error during getting values from row")
+			return nil, err
+		}
+		if err = decodeMetric(&metric, encodedLabels, histogram, sketchData); err != nil {
+			return nil, err
+		}
+
+		if expired(metric, updatedAt) {
+			ids = append(ids, metric.ID)
+			types = append(types, metric.MType)
+			labels = append(labels, string(encodedLabels))
+			updated = append(updated, updatedAt)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteExpiredMetrics").Msg("error during rows scanning")
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	deletedRows, err := db.pool.Query(ctx, deleteExpiredMetrics, ids, types, labels, updated)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteExpiredMetrics").Msg("error during deleting expired metrics")
+		return nil, err
+	}
+	defer deletedRows.Close()
+
+	var deleted []models.Metrics
+	for deletedRows.Next() {
+		metric, err := scanMetric(deletedRows)
+		if err != nil {
+			return nil, err
+		}
+		deleted = append(deleted, metric)
+	}
+
+	return deleted, deletedRows.Err()
+}
+
+func (db *DB) purgeMetrics(ctx context.Context, filter models.MetricsFilter, match func(models.Metrics) bool) ([]models.Metrics, error) {
+	filterLabels, err := encodeLabels(filter.Labels)
+	if err != nil {
+		return nil, err
+	}
+
+	var deleted []models.Metrics
+	err = db.inTx(ctx, "*DB.purgeMetrics", func(tx pgx.Tx) error {
+		rows, err := tx.Query(ctx, getPurgeCandidates, filter.MType, filter.Prefix, filterLabels)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*DB.purgeMetrics").Msg("error during query execution")
+			return err
+		}
+
+		var ids, types, labels []string
+		for rows.Next() {
+			metric, err := scanMetric(rows)
+			if err != nil {
+				rows.Close()
+				db.logger.Err(err).Str("func", "*DB.purgeMetrics").Msg("error during getting values from row")
+				return err
+			}
+			if !match(metric) {
+				continue
+			}
+			encoded, err := encodeLabels(metric.Labels)
+			if err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, metric.ID)
+			types = append(types, metric.MType)
+			labels = append(labels, encoded)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			db.logger.Err(err).Str("func", "*DB.purgeMetrics").Msg("error during rows scanning")
+			return err
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+
+		deletedRows, err := tx.Query(ctx, deletePurgedMetrics, ids, types, labels)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*DB.purgeMetrics").Msg("error during deleting metrics")
+			return err
+		}
+		defer deletedRows.Close()
+
+		for deletedRows.Next() {
+			metric, err := scanMetric(deletedRows)
+			if err != nil {
+				return err
+			}
+			deleted = append(deleted, metric)
+		}
+		return deletedRows.Err()
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return deleted, nil
+}
+
+func (db *DB) addSamples(ctx context.Context, timestamp time.Time, metrics []models.Metrics) error {
+	batch := &pgx.Batch{}
+	for _, metric := range metrics {
+		labels, labelsErr := encodeLabels(metric.Labels)
+		if labelsErr != nil {
+			db.logger.Err(labelsErr).Str("func", "*DB.addSamples").Any("metric", metric).Msg("error encoding metric labels")
+			return labelsErr
+		}
+		batch.Queue(insertMetricSampleQuery, metric.ID, metric.MType, labels, metric.Delta, metric.Value, timestamp)
+	}
+
+	return db.sendBatch(ctx, "*DB.addSamples", batch)
+}
+
+// sendBatch выполняет пачку запросов в транзакции
+func (db *DB) sendBatch(ctx context.Context, operation string, batch *pgx.Batch) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	return db.inTx(ctx, operation, func(tx pgx.Tx) error {
+		return execBatch(ctx, tx, operation, batch, db.logger)
+	})
+}
+
+// inTx выполняет fn в транзакции и фиксирует ее, если fn завершилась без ошибки
+func (db *DB) inTx(ctx context.Context, operation string, fn func(tx pgx.Tx) error) error {
+	tx, err := db.pool.Begin(ctx)
+	if err != nil {
+		db.logger.Err(err).Str("func", operation).Msg("error during opening transaction")
+		return fmt.Errorf("error during opening transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err = fn(tx); err != nil {
+		return err
+	}
+
+	// commit transaction if all queries are successfully executed
+	return tx.Commit(ctx)
+}
+
+func execBatch(ctx context.Context, tx pgx.Tx, operation string, batch *pgx.Batch, log *zerolog.Logger) error {
+	if batch.Len() == 0 {
+		return nil
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	for idx := range batch.Len() {
+		if _, err := results.Exec(); err != nil {
+			log.Err(err).Str("func", operation).Int("iteration", idx).Msg("error executing batched query")
+			results.Close()
+			return err
+		}
+	}
+	if err := results.Close(); err != nil {
+		log.Err(err).Str("func", operation).Msg("error closing batch results")
+		return err
+	}
+
+	return nil
+}
+
+// scanBatch выполняет пачку запросов, каждый из которых возвращает метрику (RETURNING), и читает результаты
+func scanBatch(ctx context.Context, tx pgx.Tx, operation string, batch *pgx.Batch, log *zerolog.Logger) ([]models.Metrics, error) {
+	if batch.Len() == 0 {
+		return nil, nil
+	}
+
+	saved := make([]models.Metrics, 0, batch.Len())
+	results := tx.SendBatch(ctx, batch)
+	for idx := range batch.Len() {
+		metric, err := scanMetric(results.QueryRow())
+		if err != nil {
+			log.Err(err).Str("func", operation).Int("iteration", idx).Msg("error executing batched query")
+			results.Close()
+			return nil, err
+		}
+		saved = append(saved, metric)
+	}
+	if err := results.Close(); err != nil {
+		log.Err(err).Str("func", operation).Msg("error closing batch results")
+		return nil, err
+	}
+
+	return saved, nil
+}
+
+func (db *DB) deleteSamples(ctx context.Context, metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(metrics))
+	types := make([]string, 0, len(metrics))
+	labels := make([]string, 0, len(metrics))
+	for _, metric := range metrics {
+		encoded, err := encodeLabels(metric.Labels)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*DB.deleteSamples").Any("metric", metric).Msg("error encoding metric labels")
+			return err
+		}
+		ids = append(ids, metric.ID)
+		types = append(types, metric.MType)
+		labels = append(labels, encoded)
+	}
+
+	if _, err := db.pool.Exec(ctx, deleteMetricSamples, ids, types, labels); err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteSamples").Msg("error during query execution")
+		return err
+	}
+	return nil
+}
+
+// deleteExpiredSamples по самому старому и самому новому значению каждой серии находит границу устаревших значений
+// и удаляет их одним запросом
+func (db *DB) deleteExpiredSamples(ctx context.Context, expired ExpiredFunc) error {
+	rows, err := db.QueryContext(ctx, getSampleRanges)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteExpiredSamples").Msg("error during query execution")
+		return err
+	}
+	defer rows.Close()
+
+	var ids, types, labels []string
+	var before []time.Time
+	for rows.Next() {
+		var metric models.Metrics
+		var encodedLabels []byte
+		var oldest, newest time.Time
+		if err = rows.Scan(&metric.ID, &metric.MType, &encodedLabels, &oldest, &newest); err != nil {
+			db.logger.Err(err).Str("func", "*DB.deleteExpiredSamples").Msg("error during getting values from row")
+			return err
+		}
+		if err = decodeMetric(&metric, encodedLabels, nil, nil); err != nil {
+			return err
+		}
+
+		if cutoff := expiredBefore(expired, metric, oldest, newest); !cutoff.IsZero() {
+			ids = append(ids, metric.ID)
+			types = append(types, metric.MType)
+			labels = append(labels, string(encodedLabels))
+			before = append(before, cutoff)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteExpiredSamples").Msg("error during rows scanning")
+		return err
+	}
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if _, err = db.pool.Exec(ctx, deleteExpiredSamples, ids, types, labels, before); err != nil {
+		db.logger.Err(err).Str("func", "*DB.deleteExpiredSamples").Msg("error during deleting expired samples")
+		return err
+	}
+	return nil
+}
+
+func (db *DB) getSamples(ctx context.Context, metric models.Metrics, from, to time.Time) ([]models.MetricSample, error) {
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.getSamples").Msg("error encoding metric labels")
+		return nil, err
+	}
+
+	rows, err := db.QueryContext(ctx, getMetricSamples, metric.ID, metric.MType, labels, from, to)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*DB.getSamples").Msg("error during query execution")
+		return nil, err
+	}
+	defer rows.Close()
+
+	samples := make([]models.MetricSample, 0)
+	for rows.Next() {
+		var sample models.MetricSample
+		if err = rows.Scan(&sample.Timestamp, &sample.Delta, &sample.Value); err != nil {
+			db.logger.Err(err).Str("func", "*DB.getSamples").Msg("error during getting values from row")
+			return nil, err
+		}
+		samples = append(samples, sample)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		db.logger.Err(rowsErr).Str("func", "*DB.getSamples").Msg("error during rows scanning")
+		return nil, rowsErr
+	}
+
+	return samples, nil
+}
+
 func (db *DB) checkIfRetryable(err error) bool {
 	db.logger.Info().Str("func", "*DB.checkIfRetryable").Msg("checking if given PostgreSQL error is retryable")
 	if db.errorClassificator.Classify(err) == NonRetryable {
@@ -273,3 +829,93 @@ func (db *DB) checkIfRetryable(err error) bool {
 	db.logger.Info().Str("func", "*DB.checkIfRetryable").Msg("given PostgreSQL error is retryable")
 	return true
 }
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+// scanMetric читает строку вида (id, type, labels, delta, value, histogram, sketch)
+func scanMetric(row rowScanner) (models.Metrics, error) {
+	var metric models.Metrics
+	var labels, histogram, sketchData []byte
+	if err := row.Scan(&metric.ID, &metric.MType, &labels, &metric.Delta, &metric.Value, &histogram, &sketchData); err != nil {
+		return models.Metrics{}, err
+	}
+
+	if err := decodeMetric(&metric, labels, histogram, sketchData); err != nil {
+		return models.Metrics{}, err
+	}
+	return metric, nil
+}
+
+// decodeMetric заполняет метки метрики из JSON, гистограмму из JSON и скетч из бинарного представления.
+// В колонке sketch у summary хранится DDSketch, а у set - HyperLogLog
+func decodeMetric(metric *models.Metrics, labels, histogram, sketchData []byte) error {
+	if err := json.Unmarshal(labels, &metric.Labels); err != nil {
+		return fmt.Errorf("error decoding metric labels: %w", err)
+	}
+	// metrics without labels are returned as before
+	if len(metric.Labels) == 0 {
+		metric.Labels = nil
+	}
+
+	if len(histogram) > 0 {
+		if err := json.Unmarshal(histogram, &metric.Histogram); err != nil {
+			return fmt.Errorf("error decoding metric histogram: %w", err)
+		}
+	}
+	switch {
+	case len(sketchData) == 0:
+	case metric.MType == models.Set:
+		metric.Set = &sketch.HyperLogLog{}
+		if err := metric.Set.UnmarshalBinary(sketchData); err != nil {
+			return fmt.Errorf("error decoding metric set: %w", err)
+		}
+	default:
+		metric.Sketch = &sketch.DDSketch{}
+		if err := metric.Sketch.UnmarshalBinary(sketchData); err != nil {
+			return fmt.Errorf("error decoding metric sketch: %w", err)
+		}
+	}
+	return nil
+}
+
+// encodeMergeable кодирует гистограмму в JSON, а скетчи - в бинарный вид. Незаданные значения хранятся как NULL
+func encodeMergeable(metric models.Metrics) (histogram any, sketchData any, err error) {
+	if metric.Histogram != nil {
+		encoded, err := json.Marshal(metric.Histogram)
+		if err != nil {
+			return nil, nil, err
+		}
+		histogram = string(encoded)
+	}
+	if metric.Sketch != nil {
+		encoded, err := metric.Sketch.MarshalBinary()
+		if err != nil {
+			return nil, nil, err
+		}
+		sketchData = encoded
+	}
+	if metric.Set != nil {
+		encoded, err := metric.Set.MarshalBinary()
+		if err != nil {
+			return nil, nil, err
+		}
+		sketchData = encoded
+	}
+
+	return histogram, sketchData, nil
+}
+
+// encodeLabels кодирует метки в JSON для колонки jsonb. Метрика без меток хранится с `{}`
+func encodeLabels(labels map[string]string) (string, error) {
+	if len(labels) == 0 {
+		return "{}", nil
+	}
+
+	encoded, err := json.Marshal(labels)
+	if err != nil {
+		return "", err
+	}
+	return string(encoded), nil
+}
@@ -0,0 +1,384 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	sqliteschema "github.com/MKhiriev/stunning-adventure/schema/sqlite"
+	"github.com/rs/zerolog"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteScheme префикс DATABASE_DSN, по которому вместо PostgreSQL выбирается SQLite: `sqlite://path/to/metrics.db`
+const SQLiteScheme = "sqlite://"
+
+const (
+	// same upsert as insertMetricsQuery, labels and histogram are stored as text.
+	// Histogram and sketch are merged with the stored ones before upsert
+	sqliteInsertMetricsQuery = `INSERT INTO metrics (id, type, labels, delta, value, histogram, sketch, updated_at)
+VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+ON CONFLICT (id, type, labels) DO
+UPDATE SET
+           value = EXCLUDED.value,
+           delta = metrics.delta + EXCLUDED.delta,
+           histogram = EXCLUDED.histogram,
+           sketch = EXCLUDED.sketch,
+           updated_at = EXCLUDED.updated_at
+RETURNING id, type, labels, delta, value, histogram, sketch;`
+	sqliteGetMetric    = `SELECT id, type, labels, delta, value, histogram, sketch FROM metrics WHERE id=$1 AND type=$2 AND labels=$3;`
+	sqliteDeleteMetric = `DELETE FROM metrics WHERE id=$1 AND type=$2 AND labels=$3;`
+	// updated_at is unix time in seconds
+	sqliteGetAllMetricsUpdatedAt = `SELECT id, type, labels, delta, value, histogram, sketch, updated_at FROM metrics;`
+	// type and name prefix are filtered by the query, regex and labels are checked by the filter itself
+	sqliteGetPurgeCandidates = `SELECT id, type, labels, delta, value, histogram, sketch FROM metrics WHERE ($1 = '' OR type = $1) AND substr(id, 1, length($2)) = $2;`
+)
+
+// SQLiteDB хранит метрики в файле SQLite (драйвер на чистом Go, без cgo).
+// Запись идет через единственное соединение, журнал базы в режиме WAL
+type SQLiteDB struct {
+	*sql.DB
+	logger *zerolog.Logger
+}
+
+// IsSQLiteDSN проверяет, что DSN указывает на файл SQLite
+func IsSQLiteDSN(dsn string) bool {
+	return strings.HasPrefix(dsn, SQLiteScheme)
+}
+
+func NewConnectSQLite(ctx context.Context, cfg *config.ServerConfig, log *zerolog.Logger) (*SQLiteDB, error) {
+	conn, err := OpenSQLite(cfg.DatabaseDSN)
+	if err != nil {
+		log.Err(err).Str("func", "NewConnectSQLite").Msg("error occured during opening sqlite database")
+		return nil, err
+	}
+
+	if err = conn.PingContext(ctx); err != nil {
+		log.Err(err).Str("func", "NewConnectSQLite").Msg("error connecting sqlite database (ping)")
+		conn.Close()
+		return nil, err
+	}
+	log.Info().Str("func", "NewConnectSQLite").Str("dsn", cfg.DatabaseDSN).Msg("opened sqlite database successfully")
+
+	db := &SQLiteDB{DB: conn, logger: log}
+	if err = db.Migrate(ctx); err != nil {
+		log.Err(err).Str("func", "NewConnectSQLite").Msg("failed migration")
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// OpenSQLite открывает файл базы из DSN вида `sqlite://path`, создавая директорию при необходимости
+func OpenSQLite(dsn string) (*sql.DB, error) {
+	filePath, ok := strings.CutPrefix(dsn, SQLiteScheme)
+	if !ok || filePath == "" {
+		return nil, fmt.Errorf("invalid sqlite DSN %q, expected %s<path>", dsn, SQLiteScheme)
+	}
+
+	// create directory for database file
+	if err := os.MkdirAll(path.Dir(filePath), 0755); err != nil {
+		return nil, fmt.Errorf("error creating directory for sqlite database: %w", err)
+	}
+
+	conn, err := sql.Open("sqlite", filePath+"?_pragma=journal_mode(WAL)&_pragma=busy_timeout(5000)&_txlock=immediate")
+	if err != nil {
+		return nil, fmt.Errorf("error occured during opening sqlite database: %w", err)
+	}
+	// sqlite allows only one writer at a time
+	conn.SetMaxOpenConns(1)
+
+	return conn, nil
+}
+
+// Migrate применяет не примененные миграции из директории schema/sqlite
+func (db *SQLiteDB) Migrate(ctx context.Context) error {
+	migrator, err := NewSQLiteMigrator(db.DB, sqliteschema.Migrations, db.logger)
+	if err != nil {
+		return err
+	}
+
+	if err = migrator.Up(ctx); err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Migrate").Msg("error while applying migrations")
+		return fmt.Errorf("error while applying migrations: %w", err)
+	}
+
+	return nil
+}
+
+// Save сохраняет метрику в транзакции: гистограмма или скетч читаются и записываются без вмешательства других запросов
+func (db *SQLiteDB) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Save").Msg("error during opening transaction")
+		return models.Metrics{}, fmt.Errorf("error during opening transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := db.saveMetric(ctx, tx, metric)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Save").Any("metric", metric).Msg("error saving metric")
+		return models.Metrics{}, err
+	}
+
+	return result, tx.Commit()
+}
+
+// SaveAll сохраняет метрики в одной транзакции
+func (db *SQLiteDB) SaveAll(ctx context.Context, metrics []models.Metrics) error {
+	_, err := db.SaveAllReturning(ctx, metrics)
+	return err
+}
+
+// SaveAllReturning сохраняет метрики в одной транзакции и возвращает их значения после сохранения
+func (db *SQLiteDB) SaveAllReturning(ctx context.Context, metrics []models.Metrics) ([]models.Metrics, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.SaveAll").Msg("error during opening transaction")
+		return nil, fmt.Errorf("error during opening transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	saved := make([]models.Metrics, 0, len(metrics))
+	for idx, metric := range metrics {
+		result, err := db.saveMetric(ctx, tx, metric)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*SQLiteDB.SaveAll").Any("metric", metric).Int("iteration", idx).Msg("error saving metric")
+			return nil, err
+		}
+		saved = append(saved, result)
+	}
+
+	// commit transaction if all metrics are successfully saved
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+	return saved, nil
+}
+
+func (db *SQLiteDB) Get(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Get").Msg("error encoding metric labels")
+		return models.Metrics{}, err
+	}
+
+	found, err := scanMetric(db.QueryRowContext(ctx, sqliteGetMetric, metric.ID, metric.MType, labels))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return models.Metrics{}, ErrNotFound
+	case err != nil:
+		db.logger.Err(err).Str("func", "*SQLiteDB.Get").Msg("error occurred during scanning")
+		return models.Metrics{}, err
+	default:
+		return found, nil
+	}
+}
+
+func (db *SQLiteDB) GetAll(ctx context.Context) ([]models.Metrics, error) {
+	rows, err := db.QueryContext(ctx, getAllMetrics)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.GetAll").Msg("error during query execution")
+		return nil, err
+	}
+	defer rows.Close()
+
+	var all []models.Metrics
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*SQLiteDB.GetAll").Msg("error during getting values from row")
+			return nil, err
+		}
+		all = append(all, metric)
+	}
+
+	if rowsErr := rows.Err(); rowsErr != nil {
+		db.logger.Err(rowsErr).Str("func", "*SQLiteDB.GetAll").Msg("error during rows scanning")
+		return nil, rowsErr
+	}
+
+	return all, nil
+}
+
+// Delete удаляет метрики в одной транзакции. Возвращает количество удаленных метрик
+func (db *SQLiteDB) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Delete").Msg("error during opening transaction")
+		return 0, fmt.Errorf("error during opening transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	deleted := int64(0)
+	for _, metric := range metrics {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return 0, err
+		}
+
+		result, err := tx.ExecContext(ctx, sqliteDeleteMetric, metric.ID, metric.MType, labels)
+		if err != nil {
+			db.logger.Err(err).Str("func", "*SQLiteDB.Delete").Any("metric", metric).Msg("error deleting metric")
+			return 0, err
+		}
+		affected, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+		deleted += affected
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+	return int(deleted), nil
+}
+
+// DeleteExpired удаляет метрики, для которых expired возвращает true, и возвращает их.
+// Чтение и удаление выполняются в одной транзакции
+func (db *SQLiteDB) DeleteExpired(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error) {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.DeleteExpired").Msg("error during opening transaction")
+		return nil, fmt.Errorf("error during opening transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, sqliteGetAllMetricsUpdatedAt)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.DeleteExpired").Msg("error during query execution")
+		return nil, err
+	}
+
+	var deleted []models.Metrics
+	for rows.Next() {
+		var metric models.Metrics
+		var labels, histogram, sketchData []byte
+		var updatedAt int64
+		if err = rows.Scan(&metric.ID, &metric.MType, &labels, &metric.Delta, &metric.Value, &histogram, &sketchData, &updatedAt); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if err = decodeMetric(&metric, labels, histogram, sketchData); err != nil {
+			rows.Close()
+			return nil, err
+		}
+
+		if expired(metric, time.Unix(updatedAt, 0)) {
+			deleted = append(deleted, metric)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.DeleteExpired").Msg("error during rows scanning")
+		return nil, err
+	}
+
+	for _, metric := range deleted {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = tx.ExecContext(ctx, sqliteDeleteMetric, metric.ID, metric.MType, labels); err != nil {
+			db.logger.Err(err).Str("func", "*SQLiteDB.DeleteExpired").Any("metric", metric).Msg("error deleting metric")
+			return nil, err
+		}
+	}
+
+	return deleted, tx.Commit()
+}
+
+// Purge удаляет все метрики, подходящие под фильтр, и возвращает их.
+// Чтение и удаление выполняются в одной транзакции
+func (db *SQLiteDB) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Purge").Msg("error during opening transaction")
+		return nil, fmt.Errorf("error during opening transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, sqliteGetPurgeCandidates, filter.MType, filter.Prefix)
+	if err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Purge").Msg("error during query execution")
+		return nil, err
+	}
+
+	var deleted []models.Metrics
+	for rows.Next() {
+		metric, err := scanMetric(rows)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		if match(metric) {
+			deleted = append(deleted, metric)
+		}
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		db.logger.Err(err).Str("func", "*SQLiteDB.Purge").Msg("error during rows scanning")
+		return nil, err
+	}
+
+	for _, metric := range deleted {
+		labels, err := encodeLabels(metric.Labels)
+		if err != nil {
+			return nil, err
+		}
+		if _, err = tx.ExecContext(ctx, sqliteDeleteMetric, metric.ID, metric.MType, labels); err != nil {
+			db.logger.Err(err).Str("func", "*SQLiteDB.Purge").Any("metric", metric).Msg("error deleting metric")
+			return nil, err
+		}
+	}
+
+	return deleted, tx.Commit()
+}
+
+type queryRower interface {
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func (db *SQLiteDB) saveMetric(ctx context.Context, q queryRower, metric models.Metrics) (models.Metrics, error) {
+	if metric.MType != models.Gauge && metric.MType != models.Counter && !models.IsMergeable(metric.MType) {
+		return models.Metrics{}, errors.New("unsupported metric type was passed")
+	}
+
+	labels, err := encodeLabels(metric.Labels)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	if models.IsMergeable(metric.MType) {
+		stored, err := scanMetric(q.QueryRowContext(ctx, sqliteGetMetric, metric.ID, metric.MType, labels))
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+		case err != nil:
+			return models.Metrics{}, err
+		default:
+			if metric, err = stored.Merge(metric); err != nil {
+				return models.Metrics{}, err
+			}
+		}
+	}
+	histogram, sketchData, err := encodeMergeable(metric)
+	if err != nil {
+		return models.Metrics{}, err
+	}
+
+	return scanMetric(q.QueryRowContext(ctx, sqliteInsertMetricsQuery, metric.ID, metric.MType, labels, metric.Delta, metric.Value, histogram, sketchData, time.Now().Unix()))
+}
@@ -6,19 +6,22 @@ import (
 	"maps"
 	"slices"
 	"sync"
+	"time"
 
 	"github.com/MKhiriev/stunning-adventure/models"
 	"github.com/rs/zerolog"
 )
 
+// MemStorage хранит метрики по ключу серии с типом (models.Metrics.SeriesKey)
 type MemStorage struct {
-	Memory map[string]models.Metrics `json:"metrics"`
-	mu     *sync.Mutex
-	log    *zerolog.Logger
+	Memory  map[string]models.Metrics `json:"metrics"`
+	updated map[string]time.Time      // last update time of every metric
+	mu      *sync.Mutex
+	log     *zerolog.Logger
 }
 
 func NewMemStorage(log *zerolog.Logger) *MemStorage {
-	return &MemStorage{Memory: make(map[string]models.Metrics), mu: &sync.Mutex{}, log: log}
+	return &MemStorage{Memory: make(map[string]models.Metrics), updated: make(map[string]time.Time), mu: &sync.Mutex{}, log: log}
 }
 
 func (m *MemStorage) AddCounter(ctx context.Context, metrics models.Metrics) (models.Metrics, error) {
@@ -30,18 +33,24 @@ func (m *MemStorage) AddCounter(ctx context.Context, metrics models.Metrics) (mo
 	if metrics.MType != models.Counter {
 		return models.Metrics{}, errors.New("metric type is not `counter`")
 	}
+	if metrics.Delta == nil {
+		return models.Metrics{}, errors.New("counter has no delta")
+	}
 
-	val, ok := m.Memory[metrics.ID]
+	key := metrics.SeriesKey()
+	val, ok := m.Memory[key]
 	// if metric name exists in storage - apply Counter logic
 	if ok {
 		newDelta := *val.Delta + *metrics.Delta
 		val.Delta = &newDelta
 
-		m.Memory[metrics.ID] = val
+		m.Memory[key] = val
+		m.updated[key] = time.Now()
 		result = val
 	} else {
 		// if metric name doesn't exist - add it
-		m.Memory[metrics.ID] = metrics
+		m.Memory[key] = metrics
+		m.updated[key] = time.Now()
 		result = metrics
 	}
 
@@ -57,35 +66,53 @@ func (m *MemStorage) UpdateGauge(ctx context.Context, metrics models.Metrics) (m
 	if metrics.MType != models.Gauge {
 		return models.Metrics{}, errors.New("metric type is not `gauge`")
 	}
+	if metrics.Value == nil {
+		return models.Metrics{}, errors.New("gauge has no value")
+	}
 
-	val, ok := m.Memory[metrics.ID]
+	key := metrics.SeriesKey()
+	val, ok := m.Memory[key]
 	// if metric name exists in storage - apply Gauge logic
 	if ok {
 		val.Value = metrics.Value
-		m.Memory[metrics.ID] = val
+		m.Memory[key] = val
+		m.updated[key] = time.Now()
 		result = val
 	} else {
 		// if metric name doesn't exist - add it
-		m.Memory[metrics.ID] = metrics
+		m.Memory[key] = metrics
+		m.updated[key] = time.Now()
 		result = metrics
 	}
 
 	return result, nil
 }
 
-func (m *MemStorage) GetMetricByNameAndType(ctx context.Context, metricName string, metricType string) (models.Metrics, error) {
+// MergeMetric объединяет гистограмму или скетч с сохраненными: границы корзин и точность должны совпадать
+func (m *MemStorage) MergeMetric(ctx context.Context, metrics models.Metrics) (models.Metrics, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	foundMetric, ok := m.Memory[metricName]
-	if ok {
-		if foundMetric.MType == metricType {
-			return foundMetric, nil
+	if !models.IsMergeable(metrics.MType) {
+		return models.Metrics{}, errors.New("metric type can't be merged")
+	}
+
+	key := metrics.SeriesKey()
+	result := metrics
+	if val, ok := m.Memory[key]; ok {
+		var err error
+		if result, err = val.Merge(metrics); err != nil {
+			return models.Metrics{}, err
 		}
-		return models.Metrics{}, ErrNotFound
 	}
+	m.Memory[key] = result
+	m.updated[key] = time.Now()
+
+	return result, nil
+}
 
-	return models.Metrics{}, ErrNotFound
+func (m *MemStorage) GetMetricByNameAndType(ctx context.Context, metricName string, metricType string) (models.Metrics, error) {
+	return m.Get(ctx, models.Metrics{ID: metricName, MType: metricType})
 }
 
 func (m *MemStorage) GetAllMetrics(ctx context.Context) []models.Metrics {
@@ -95,42 +122,158 @@ func (m *MemStorage) GetAllMetrics(ctx context.Context) []models.Metrics {
 	return slices.Collect(maps.Values(m.Memory))
 }
 
+// Set записывает метрики как есть, без логики counter
+func (m *MemStorage) Set(ctx context.Context, metrics ...models.Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for _, metric := range metrics {
+		m.Memory[metric.SeriesKey()] = metric
+		m.updated[metric.SeriesKey()] = now
+	}
+}
+
+// Reset заменяет все содержимое хранилища переданными метриками
+func (m *MemStorage) Reset(ctx context.Context, metrics []models.Metrics) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	m.Memory = make(map[string]models.Metrics, len(metrics))
+	m.updated = make(map[string]time.Time, len(metrics))
+	for _, metric := range metrics {
+		m.Memory[metric.SeriesKey()] = metric
+		m.updated[metric.SeriesKey()] = now
+	}
+}
+
+// restore записывает метрику с известным временем обновления (например, прочитанную из файла)
+func (m *MemStorage) restore(metric models.Metrics, updatedAt time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if updatedAt.IsZero() {
+		updatedAt = time.Now()
+	}
+	m.Memory[metric.SeriesKey()] = metric
+	m.updated[metric.SeriesKey()] = updatedAt
+}
+
+// updatedAt время последнего обновления метрики
+func (m *MemStorage) updatedAt(key string) time.Time {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.updated[key]
+}
+
 func (m *MemStorage) Save(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
 	switch metric.MType {
 	case models.Counter:
 		return m.AddCounter(ctx, metric)
 	case models.Gauge:
 		return m.UpdateGauge(ctx, metric)
+	case models.Histogram, models.Summary, models.Set:
+		return m.MergeMetric(ctx, metric)
 	default:
 		return metric, errors.New("unsupported metric type")
 	}
 }
 
 func (m *MemStorage) SaveAll(ctx context.Context, metrics []models.Metrics) error {
-	var err error
+	_, err := m.SaveAllReturning(ctx, metrics)
+	return err
+}
+
+// SaveAllReturning сохраняет метрики по одной и возвращает их значения после каждого сохранения
+func (m *MemStorage) SaveAllReturning(ctx context.Context, metrics []models.Metrics) ([]models.Metrics, error) {
+	saved := make([]models.Metrics, 0, len(metrics))
 	for _, metric := range metrics {
-		switch metric.MType {
-		case models.Counter:
-			_, err = m.AddCounter(ctx, metric)
-			if err != nil {
-				return err
-			}
-		case models.Gauge:
-			_, err = m.UpdateGauge(ctx, metric)
-			if err != nil {
-				return err
-			}
-		default:
-			return errors.New("unsupported metric type")
+		result, err := m.Save(ctx, metric)
+		if err != nil {
+			return nil, err
 		}
+		saved = append(saved, result)
 	}
-	return nil
+	return saved, nil
 }
 
 func (m *MemStorage) Get(ctx context.Context, metric models.Metrics) (models.Metrics, error) {
-	return m.GetMetricByNameAndType(ctx, metric.ID, metric.MType)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	foundMetric, ok := m.Memory[metric.SeriesKey()]
+	if !ok {
+		return models.Metrics{}, ErrNotFound
+	}
+
+	return foundMetric, nil
 }
 
 func (m *MemStorage) GetAll(ctx context.Context) ([]models.Metrics, error) {
 	return m.GetAllMetrics(ctx), nil
 }
+
+// Delete удаляет метрики с совпадающими именем, типом и метками. Возвращает количество удаленных метрик
+func (m *MemStorage) Delete(ctx context.Context, metrics []models.Metrics) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	deleted := 0
+	for _, metric := range metrics {
+		key := metric.SeriesKey()
+		if _, ok := m.Memory[key]; ok {
+			delete(m.Memory, key)
+			delete(m.updated, key)
+			deleted++
+		}
+	}
+
+	return deleted, nil
+}
+
+// DeleteExpired удаляет метрики, для которых expired возвращает true, и возвращает их
+func (m *MemStorage) DeleteExpired(ctx context.Context, expired ExpiredFunc) ([]models.Metrics, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted []models.Metrics
+	for key, metric := range m.Memory {
+		updatedAt, ok := m.updated[key]
+		if !ok {
+			// metric was added without tracking, count its time from now
+			m.updated[key] = time.Now()
+			continue
+		}
+		if expired(metric, updatedAt) {
+			delete(m.Memory, key)
+			delete(m.updated, key)
+			deleted = append(deleted, metric)
+		}
+	}
+
+	return deleted, nil
+}
+
+// Purge удаляет все метрики, подходящие под фильтр, и возвращает их
+func (m *MemStorage) Purge(ctx context.Context, filter models.MetricsFilter) ([]models.Metrics, error) {
+	match, err := filter.Matcher()
+	if err != nil {
+		return nil, err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var deleted []models.Metrics
+	for key, metric := range m.Memory {
+		if match(metric) {
+			delete(m.Memory, key)
+			delete(m.updated, key)
+			deleted = append(deleted, metric)
+		}
+	}
+
+	return deleted, nil
+}
@@ -0,0 +1,195 @@
+package store
+
+import (
+	"context"
+	"os"
+	"path"
+	"testing"
+	"time"
+
+	"github.com/MKhiriev/stunning-adventure/internal/config"
+	"github.com/MKhiriev/stunning-adventure/models"
+	"github.com/rs/zerolog"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemStorage_SeriesKey(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Logger()
+	ctx := context.Background()
+
+	tests := []struct {
+		name    string
+		metrics []models.Metrics
+	}{
+		{
+			name: "labels in ID",
+			metrics: []models.Metrics{
+				{ID: "cpu;host=a", MType: models.Gauge, Value: mValue(1)},
+				{ID: "cpu", MType: models.Gauge, Value: mValue(2), Labels: map[string]string{"host": "a"}},
+			},
+		},
+		{
+			name: "separators in label value",
+			metrics: []models.Metrics{
+				{ID: "cpu", MType: models.Gauge, Value: mValue(1), Labels: map[string]string{"host": "a;dc=eu"}},
+				{ID: "cpu", MType: models.Gauge, Value: mValue(2), Labels: map[string]string{"host": "a", "dc": "eu"}},
+			},
+		},
+		{
+			name: "same name with different type",
+			metrics: []models.Metrics{
+				{ID: "cpu", MType: models.Gauge, Value: mValue(1)},
+				{ID: "cpu", MType: models.Counter, Delta: mDelta(2)},
+			},
+		},
+		{
+			name: "same name with mergeable types",
+			metrics: []models.Metrics{
+				histogramMetric("latency", []float64{1}, 0.5),
+				{ID: "latency", MType: models.Counter, Delta: mDelta(2)},
+				{ID: "latency", MType: models.Gauge, Value: mValue(1)},
+				summaryMetric("latency", 0.5),
+				setMetric("latency", "alice"),
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := NewMemStorage(&logger)
+			for _, metric := range tt.metrics {
+				_, err := storage.Save(ctx, metric)
+				require.NoError(t, err)
+			}
+
+			all, err := storage.GetAll(ctx)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tt.metrics, all)
+			for _, metric := range tt.metrics {
+				found, err := storage.Get(ctx, models.Metrics{ID: metric.ID, MType: metric.MType, Labels: metric.Labels})
+				require.NoError(t, err)
+				assert.Equal(t, metric, found)
+			}
+		})
+	}
+}
+
+func TestMemStorage_SaveWithoutValue(t *testing.T) {
+	logger := zerolog.New(os.Stdout).With().Logger()
+	ctx := context.Background()
+	storage := NewMemStorage(&logger)
+
+	_, err := storage.Save(ctx, models.Metrics{ID: "x", MType: models.Counter, Delta: mDelta(1)})
+	require.NoError(t, err)
+	_, err = storage.Save(ctx, models.Metrics{ID: "x", MType: models.Counter})
+	assert.Error(t, err)
+	err = storage.SaveAll(ctx, []models.Metrics{{ID: "x", MType: models.Gauge}})
+	assert.Error(t, err)
+
+	found, err := storage.Get(ctx, models.Metrics{ID: "x", MType: models.Counter})
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), *found.Delta)
+}
+
+func TestStorage_Purge(t *testing.T) {
+	logger := zerolog.Nop()
+	ctx := context.Background()
+
+	storages := map[string]func(t *testing.T) Storage{
+		"memory": func(t *testing.T) Storage {
+			return NewMemStorage(&logger)
+		},
+		"history": func(t *testing.T) Storage {
+			return NewHistoryStorage(NewMemStorage(&logger), NewMemHistoryStorage(&logger), &logger)
+		},
+		"sqlite": func(t *testing.T) Storage {
+			cfg := &config.ServerConfig{DatabaseDSN: SQLiteScheme + path.Join(t.TempDir(), "metrics.db")}
+			db, err := NewConnectSQLite(ctx, cfg, &logger)
+			require.NoError(t, err)
+			t.Cleanup(func() { db.Close() })
+			return db
+		},
+		"bolt": func(t *testing.T) Storage {
+			bolt, err := NewBoltStorage(&config.ServerConfig{BoltPath: path.Join(t.TempDir(), "metrics.bolt")}, &logger)
+			require.NoError(t, err)
+			t.Cleanup(func() { bolt.Close() })
+			return bolt
+		},
+	}
+
+	web := models.Metrics{ID: "http_requests", MType: models.Counter, Delta: mDelta(1), Labels: map[string]string{"host": "web"}}
+	db := models.Metrics{ID: "http_requests", MType: models.Counter, Delta: mDelta(2), Labels: map[string]string{"host": "db"}}
+	latency := gaugeMetric("http_latency", 1)
+	alloc := gaugeMetric("Alloc", 1)
+	all := []models.Metrics{web, db, latency, alloc}
+
+	tests := []struct {
+		name   string
+		filter models.MetricsFilter
+		want   []models.Metrics
+	}{
+		{
+			name:   "by prefix",
+			filter: models.MetricsFilter{Prefix: "http_"},
+			want:   []models.Metrics{web, db, latency},
+		},
+		{
+			name:   "by type and labels",
+			filter: models.MetricsFilter{MType: models.Counter, Labels: map[string]string{"host": "db"}},
+			want:   []models.Metrics{db},
+		},
+		{
+			name:   "by regex",
+			filter: models.MetricsFilter{Prefix: "http_", Regex: "latency$"},
+			want:   []models.Metrics{latency},
+		},
+		{
+			name:   "nothing matched",
+			filter: models.MetricsFilter{Prefix: "missing_"},
+		},
+	}
+	for name, newStorage := range storages {
+		for _, tt := range tests {
+			t.Run(name+"/"+tt.name, func(t *testing.T) {
+				storage := newStorage(t)
+				require.NoError(t, storage.SaveAll(ctx, all))
+
+				purged, err := storage.(MetricsPurgeStorage).Purge(ctx, tt.filter)
+				require.NoError(t, err)
+				assert.ElementsMatch(t, tt.want, purged)
+
+				// purging the same filter again deletes nothing
+				purged, err = storage.(MetricsPurgeStorage).Purge(ctx, tt.filter)
+				require.NoError(t, err)
+				assert.Empty(t, purged)
+
+				left, err := storage.GetAll(ctx)
+				require.NoError(t, err)
+				assert.Len(t, left, len(all)-len(tt.want))
+				for _, metric := range tt.want {
+					_, err = storage.Get(ctx, metric)
+					assert.ErrorIs(t, err, ErrNotFound)
+				}
+			})
+		}
+	}
+
+	t.Run("history of purged metric is deleted", func(t *testing.T) {
+		history := NewMemHistoryStorage(&logger)
+		storage := NewHistoryStorage(NewMemStorage(&logger), history, &logger)
+		require.NoError(t, storage.SaveAll(ctx, all))
+
+		_, err := storage.Purge(ctx, models.MetricsFilter{Prefix: "http_latency"})
+		require.NoError(t, err)
+		_, err = history.GetSamples(ctx, latency, time.Time{}, time.Now())
+		assert.ErrorIs(t, err, ErrNotFound)
+		samples, err := history.GetSamples(ctx, alloc, time.Time{}, time.Now())
+		require.NoError(t, err)
+		assert.Len(t, samples, 1)
+	})
+
+	t.Run("invalid regex", func(t *testing.T) {
+		_, err := NewMemStorage(&logger).Purge(ctx, models.MetricsFilter{Regex: "("})
+		assert.Error(t, err)
+	})
+}
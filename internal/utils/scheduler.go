@@ -1,13 +1,23 @@
 package utils
 
-import "time"
+import (
+	"context"
+	"time"
+)
 
-func RunWithTicker(fn func(), duration time.Duration) {
+// RunWithTicker вызывает fn раз в duration, пока ctx не завершен
+func RunWithTicker(ctx context.Context, fn func(), duration time.Duration) {
 	ticker := time.NewTicker(duration)
 
 	go func() {
-		for range ticker.C {
-			fn()
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				fn()
+			}
 		}
 	}()
 }
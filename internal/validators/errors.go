@@ -3,10 +3,14 @@ package validators
 import "errors"
 
 var (
-	ErrUnsupportedType = errors.New("unsupported type for validation")
-	ErrEmptyID         = errors.New("metric name (id) is empty")
-	ErrEmptyType       = errors.New("metric type is empty")
-	ErrInvalidType     = errors.New("metric type is not valid")
-	ErrNoValue         = errors.New("metric has no value")
-	ErrUnknownField    = errors.New("unknown field for validation")
+	ErrUnsupportedType  = errors.New("unsupported type for validation")
+	ErrEmptyID          = errors.New("metric name (id) is empty")
+	ErrEmptyType        = errors.New("metric type is empty")
+	ErrInvalidType      = errors.New("metric type is not valid")
+	ErrNoValue          = errors.New("metric has no value")
+	ErrInvalidHistogram = errors.New("metric histogram is not valid")
+	ErrInvalidLabel     = errors.New("metric label name is empty")
+	ErrUnknownField     = errors.New("unknown field for validation")
+	ErrEmptyFilter      = errors.New("metrics filter has no conditions")
+	ErrInvalidRegex     = errors.New("metrics filter regex is not valid")
 )
@@ -2,16 +2,18 @@ package validators
 
 import (
 	"context"
+	"fmt"
 	"slices"
 
 	"github.com/MKhiriev/stunning-adventure/models"
 )
 
 const (
-	ID    = "id"
-	MType = "type"
-	Value = "value"
-	Delta = "value"
+	ID     = "id"
+	MType  = "type"
+	Value  = "value"
+	Delta  = "value"
+	Labels = "labels"
 )
 
 type MetricsValidator struct {
@@ -20,11 +22,15 @@ type MetricsValidator struct {
 
 func NewMetricsValidator() *MetricsValidator {
 	return &MetricsValidator{
-		allowedMetricTypes: []string{models.Gauge, models.Counter},
+		allowedMetricTypes: []string{models.Gauge, models.Counter, models.Histogram, models.Summary, models.Set},
 	}
 }
 
 func (v *MetricsValidator) Validate(ctx context.Context, obj any, fields ...string) error {
+	if filter, ok := obj.(models.MetricsFilter); ok {
+		return v.validateFilter(filter)
+	}
+
 	metric, ok := obj.(models.Metrics)
 	if !ok {
 		// check if it's a pointer
@@ -37,7 +43,7 @@ func (v *MetricsValidator) Validate(ctx context.Context, obj any, fields ...stri
 
 	// if empty fields list - validate all!
 	if len(fields) == 0 {
-		fields = []string{"id", "type", "value"}
+		fields = []string{"id", "type", "value", "labels"}
 	}
 
 	for _, f := range fields {
@@ -54,12 +60,72 @@ func (v *MetricsValidator) Validate(ctx context.Context, obj any, fields ...stri
 				return ErrInvalidType
 			}
 		case "value":
-			if metric.Value == nil && metric.Delta == nil {
+			if metric.MType == models.Histogram {
+				if err := validateHistogram(metric.Histogram); err != nil {
+					return err
+				}
+				continue
+			}
+			if metric.MType == models.Summary {
+				// sketch is checked during decoding
+				if metric.Sketch == nil {
+					return ErrNoValue
+				}
+				continue
+			}
+			if metric.MType == models.Set {
+				if metric.Set == nil {
+					return ErrNoValue
+				}
+				continue
+			}
+			// counter is summed by delta and gauge is replaced by value, the other field is ignored by storages
+			if metric.MType == models.Counter && metric.Delta == nil {
 				return ErrNoValue
 			}
+			if metric.MType == models.Gauge && metric.Value == nil {
+				return ErrNoValue
+			}
+		case "labels":
+			for name := range metric.Labels {
+				if name == "" {
+					return ErrInvalidLabel
+				}
+			}
 		default:
 			return ErrUnknownField
 		}
 	}
 	return nil
 }
+
+// validateHistogram проверяет, что корзины гистограммы согласованы с count
+func validateHistogram(histogram *models.HistogramValue) error {
+	if histogram == nil {
+		return ErrNoValue
+	}
+	if err := histogram.Validate(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidHistogram, err)
+	}
+	return nil
+}
+
+// validateFilter проверяет фильтр массового удаления: пустой фильтр удалил бы все метрики
+func (v *MetricsValidator) validateFilter(filter models.MetricsFilter) error {
+	if filter.IsEmpty() {
+		return ErrEmptyFilter
+	}
+	if filter.MType != "" && !slices.Contains(v.allowedMetricTypes, filter.MType) {
+		return ErrInvalidType
+	}
+	for name := range filter.Labels {
+		if name == "" {
+			return ErrInvalidLabel
+		}
+	}
+	if _, err := filter.Matcher(); err != nil {
+		return fmt.Errorf("%w: %w", ErrInvalidRegex, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+// Заголовки, которыми агент представляется серверу
+const (
+	AgentIDHeader             = "X-Agent-ID"
+	AgentVersionHeader        = "X-Agent-Version"
+	AgentReportIntervalHeader = "X-Agent-Report-Interval" // интервал отправки метрик в секундах
+)
+
+// Метки, которыми агент помечает каждую отправляемую метрику
+const (
+	HostLabel     = "host"
+	InstanceLabel = "instance"
+)
+
+// LabelQueryPrefix префикс параметров запроса с метками метрики в текстовом API: `?label.host=web01`
+const LabelQueryPrefix = "label."
+
+// AgentInfo запись реестра агентов, присылающих метрики
+type AgentInfo struct {
+	ID             string    `json:"id"`
+	Address        string    `json:"address"`
+	Version        string    `json:"version,omitempty"`
+	FirstSeen      time.Time `json:"first_seen"`
+	LastSeen       time.Time `json:"last_seen"`
+	ReportInterval int64     `json:"report_interval"` // в секундах
+	LastReport     int       `json:"last_report"`     // количество метрик в последней отправке
+	MetricsCount   int64     `json:"metrics_count"`   // всего получено метрик
+	Stale          bool      `json:"stale"`
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"regexp"
+	"strings"
+)
+
+// MetricsFilter выбирает метрики для массового удаления. Заданные условия объединяются через И:
+// тип метрики, префикс имени, регулярное выражение для имени и значения меток
+type MetricsFilter struct {
+	MType  string            `json:"type,omitempty"`
+	Prefix string            `json:"prefix,omitempty"`
+	Regex  string            `json:"regex,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+}
+
+// IsEmpty фильтр без условий подходит под все метрики
+func (f MetricsFilter) IsEmpty() bool {
+	return f.MType == "" && f.Prefix == "" && f.Regex == "" && len(f.Labels) == 0
+}
+
+// Matcher возвращает функцию проверки метрики. Ошибка - если Regex не компилируется
+func (f MetricsFilter) Matcher() (func(Metrics) bool, error) {
+	var re *regexp.Regexp
+	if f.Regex != "" {
+		var err error
+		if re, err = regexp.Compile(f.Regex); err != nil {
+			return nil, err
+		}
+	}
+
+	return func(metric Metrics) bool {
+		if f.MType != "" && metric.MType != f.MType {
+			return false
+		}
+		if !strings.HasPrefix(metric.ID, f.Prefix) {
+			return false
+		}
+		if re != nil && !re.MatchString(metric.ID) {
+			return false
+		}
+		for name, value := range f.Labels {
+			if labelValue, ok := metric.Labels[name]; !ok || labelValue != value {
+				return false
+			}
+		}
+		return true
+	}, nil
+}
+
+// DeleteResult ответ на удаление метрик
+type DeleteResult struct {
+	Deleted int       `json:"deleted"`
+	Metrics []Metrics `json:"metrics,omitempty"`
+}
@@ -0,0 +1,162 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// DefaultHistogramBounds границы корзин по умолчанию (как у клиента Prometheus), в секундах
+var DefaultHistogramBounds = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+var (
+	ErrInvalidHistogram = errors.New("histogram is not valid")
+	ErrHistogramBounds  = errors.New("histogram bucket bounds differ from stored ones")
+)
+
+// HistogramValue распределение значений по корзинам.
+// Bounds - верхние границы корзин по возрастанию, последняя корзина +Inf подразумевается,
+// поэтому Counts на один элемент длиннее Bounds. Counts хранит количество значений в каждой корзине (не накопительно)
+type HistogramValue struct {
+	Bounds []float64 `json:"bounds"`
+	Counts []uint64  `json:"counts"`
+	Sum    float64   `json:"sum"`
+	Count  uint64    `json:"count"`
+}
+
+// NewHistogramValue пустая гистограмма с заданными границами корзин
+func NewHistogramValue(bounds []float64) *HistogramValue {
+	return &HistogramValue{
+		Bounds: slices.Clone(bounds),
+		Counts: make([]uint64, len(bounds)+1),
+	}
+}
+
+// ParseHistogramBounds разбирает границы корзин вида `0.1,0.5,1`. Пустая строка - границы по умолчанию
+func ParseHistogramBounds(s string) ([]float64, error) {
+	if strings.TrimSpace(s) == "" {
+		return slices.Clone(DefaultHistogramBounds), nil
+	}
+
+	var bounds []float64
+	for _, field := range strings.Split(s, ",") {
+		bound, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid histogram bound %q: %w", field, err)
+		}
+		bounds = append(bounds, bound)
+	}
+	if err := validateBounds(bounds); err != nil {
+		return nil, err
+	}
+
+	return bounds, nil
+}
+
+// NewHistogramMetric гистограмма с одним значением Value, разложенным по корзинам bounds
+func NewHistogramMetric(ID string, bounds []float64, Value string) (Metrics, error) {
+	value, err := strconv.ParseFloat(Value, 64)
+	if err != nil || math.IsNaN(value) {
+		return Metrics{}, errors.New("passed HISTOGRAM metric params are not valid")
+	}
+
+	histogram := NewHistogramValue(bounds)
+	histogram.Observe(value)
+	return Metrics{
+		ID:        ID,
+		MType:     Histogram,
+		Histogram: histogram,
+	}, nil
+}
+
+// Observe добавляет значение в гистограмму
+func (h *HistogramValue) Observe(value float64) {
+	// bucket `le` includes its upper bound
+	idx, _ := slices.BinarySearch(h.Bounds, value)
+	h.Counts[idx]++
+	h.Sum += value
+	h.Count++
+}
+
+// Merge возвращает сумму двух гистограмм с одинаковыми границами корзин
+func (h *HistogramValue) Merge(other *HistogramValue) (*HistogramValue, error) {
+	if !slices.Equal(h.Bounds, other.Bounds) {
+		return nil, ErrHistogramBounds
+	}
+
+	merged := &HistogramValue{
+		Bounds: slices.Clone(h.Bounds),
+		Counts: make([]uint64, len(h.Counts)),
+		Sum:    h.Sum + other.Sum,
+		Count:  h.Count + other.Count,
+	}
+	for i := range merged.Counts {
+		merged.Counts[i] = h.Counts[i] + other.Counts[i]
+	}
+
+	return merged, nil
+}
+
+// Cumulative количество значений, не превышающих границу каждой корзины (как `le` в Prometheus), последняя - +Inf
+func (h *HistogramValue) Cumulative() []uint64 {
+	cumulative := make([]uint64, len(h.Counts))
+	var total uint64
+	for i, count := range h.Counts {
+		total += count
+		cumulative[i] = total
+	}
+	return cumulative
+}
+
+// Validate проверяет согласованность гистограммы
+func (h *HistogramValue) Validate() error {
+	if err := validateBounds(h.Bounds); err != nil {
+		return err
+	}
+	if len(h.Counts) != len(h.Bounds)+1 {
+		return fmt.Errorf("%w: expected %d bucket counts, got %d", ErrInvalidHistogram, len(h.Bounds)+1, len(h.Counts))
+	}
+
+	var total uint64
+	for _, count := range h.Counts {
+		total += count
+	}
+	if total != h.Count {
+		return fmt.Errorf("%w: count %d is not equal to the sum of bucket counts %d", ErrInvalidHistogram, h.Count, total)
+	}
+	if math.IsNaN(h.Sum) {
+		return fmt.Errorf("%w: sum is NaN", ErrInvalidHistogram)
+	}
+
+	return nil
+}
+
+// String гистограмма в виде `count=3 sum=1.5 buckets=[0.5:1 1:2 +Inf:3]`, корзины накопительные
+func (h *HistogramValue) String() string {
+	buckets := make([]string, 0, len(h.Counts))
+	for i, count := range h.Cumulative() {
+		bound := "+Inf"
+		if i < len(h.Bounds) {
+			bound = strconv.FormatFloat(h.Bounds[i], 'f', -1, 64)
+		}
+		buckets = append(buckets, bound+":"+strconv.FormatUint(count, 10))
+	}
+
+	return fmt.Sprintf("count=%d sum=%s buckets=[%s]",
+		h.Count, strconv.FormatFloat(h.Sum, 'f', -1, 64), strings.Join(buckets, " "))
+}
+
+func validateBounds(bounds []float64) error {
+	for i, bound := range bounds {
+		if math.IsNaN(bound) || math.IsInf(bound, 0) {
+			return fmt.Errorf("%w: bucket bound must be a finite number", ErrInvalidHistogram)
+		}
+		if i > 0 && bound <= bounds[i-1] {
+			return fmt.Errorf("%w: bucket bounds must be strictly increasing", ErrInvalidHistogram)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package models
+
+import "time"
+
+// MetricSample одно сохранённое значение метрики в момент времени Timestamp.
+// Для counter хранится накопленное значение Delta, для gauge - Value.
+type MetricSample struct {
+	Timestamp time.Time `json:"timestamp"`
+	Delta     *int64    `json:"delta,omitempty"`
+	Value     *float64  `json:"value,omitempty"`
+}
+
+func NewMetricSample(timestamp time.Time, metric Metrics) MetricSample {
+	return MetricSample{
+		Timestamp: timestamp,
+		Delta:     metric.Delta,
+		Value:     metric.Value,
+	}
+}
+
+const (
+	AggregationMin      = "min"
+	AggregationMax      = "max"
+	AggregationAvg      = "avg"
+	AggregationLast     = "last"
+	AggregationRate     = "rate"
+	AggregationIncrease = "increase"
+)
+
+// HistoryQuery запрос истории метрики за период [From, To].
+// Если Step не задан - возвращаются все сохранённые значения без агрегации
+type HistoryQuery struct {
+	Metric      Metrics
+	From        time.Time
+	To          time.Time
+	Step        time.Duration
+	Aggregation string
+}
+
+// MetricHistory ответ на запрос истории метрики
+type MetricHistory struct {
+	ID          string            `json:"id"`
+	MType       string            `json:"type"`
+	Labels      map[string]string `json:"labels,omitempty"`
+	From        time.Time         `json:"from"`
+	To          time.Time         `json:"to"`
+	Step        int64             `json:"step,omitempty"` // in seconds
+	Aggregation string            `json:"aggregation,omitempty"`
+	Samples     []MetricSample    `json:"samples"`
+}
@@ -3,28 +3,51 @@ package models
 import (
 	"errors"
 	"fmt"
+	"maps"
 	"slices"
 	"strconv"
+	"strings"
+
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
 )
 
 const (
-	Counter = "counter"
-	Gauge   = "gauge"
+	Counter   = "counter"
+	Gauge     = "gauge"
+	Histogram = "histogram"
+	Summary   = "summary"
+	Set       = "set"
 )
 
-var allowedTypes = []string{Counter, Gauge}
+var allowedTypes = []string{Counter, Gauge, Histogram, Summary, Set}
+
+// mergeableTypes значения этих типов объединяются с сохраненными через Merge
+var mergeableTypes = []string{Histogram, Summary, Set}
+
+var keyEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, "=", `\=`)
+
+var ErrTypeMismatch = errors.New("metric type differs from stored one")
 
 // Metrics NOTE: Не усложняем пример, вводя иерархическую вложенность структур.
 // Органичиваясь плоской моделью.
 // Delta и Value объявлены через указатели,
 // что бы отличать значение "0", от не заданного значения
 // и соответственно не кодировать в структуру.
+// Labels входят в идентификатор метрики: метрики с одинаковым ID, но разными метками - разные серии.
+// Histogram задан только у метрик типа histogram, Sketch - у summary, Set - у set.
+// Quantiles и Cardinality вычисляются из скетчей при чтении метрики и не хранятся.
 type Metrics struct {
-	ID    string   `json:"id"`
-	MType string   `json:"type"`
-	Delta *int64   `json:"delta,omitempty"`
-	Value *float64 `json:"value,omitempty"`
-	Hash  string   `json:"hash,omitempty"`
+	ID          string              `json:"id"`
+	MType       string              `json:"type"`
+	Delta       *int64              `json:"delta,omitempty"`
+	Value       *float64            `json:"value,omitempty"`
+	Histogram   *HistogramValue     `json:"histogram,omitempty"`
+	Sketch      *sketch.DDSketch    `json:"sketch,omitempty"`
+	Quantiles   []Quantile          `json:"quantiles,omitempty"`
+	Set         *sketch.HyperLogLog `json:"set,omitempty"`
+	Cardinality *uint64             `json:"cardinality,omitempty"`
+	Hash        string              `json:"hash,omitempty"`
+	Labels      map[string]string   `json:"labels,omitempty"`
 }
 
 func NewMetric(ID, MType, Value string) (Metrics, error) {
@@ -40,6 +63,12 @@ func NewMetric(ID, MType, Value string) (Metrics, error) {
 		metric, err = newGauge(ID, MType, Value)
 	case Counter:
 		metric, err = newCounter(ID, MType, Value)
+	case Histogram:
+		metric, err = NewHistogramMetric(ID, DefaultHistogramBounds, Value)
+	case Summary:
+		metric, err = NewSummaryMetric(ID, Value)
+	case Set:
+		metric, err = NewSetMetric(ID, Value)
 	}
 	if err != nil {
 		return Metrics{}, fmt.Errorf("error occured during mteric creation: %w", err)
@@ -83,12 +112,107 @@ func newCounter(ID, MType, Value string) (Metrics, error) {
 	}, nil
 }
 
+// Key идентификатор серии: ID и отсортированные метки в виде `id;label1=value1;label2=value2`
+func (m *Metrics) Key() string {
+	return MetricKey(m.ID, m.Labels)
+}
+
+// SeriesKey идентификатор серии вместе с типом в виде `type:id;label1=value1`, как первичный ключ (id, type, labels) в БД:
+// метрики с одинаковыми ID и метками, но разными типами - разные серии
+func (m *Metrics) SeriesKey() string {
+	return m.MType + ":" + m.Key()
+}
+
+// LabelsString метки в виде `label1=value1,label2=value2`, отсортированные по имени
+func (m *Metrics) LabelsString() string {
+	pairs := make([]string, 0, len(m.Labels))
+	for _, name := range slices.Sorted(maps.Keys(m.Labels)) {
+		pairs = append(pairs, name+"="+m.Labels[name])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// MetricKey ключ серии. Символы `\`, `;` и `=` в ID и метках экранируются, поэтому ID `cpu;host=a`
+// и ID `cpu` с меткой host=a дают разные ключи
+func MetricKey(ID string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return keyEscaper.Replace(ID)
+	}
+
+	var builder strings.Builder
+	builder.WriteString(keyEscaper.Replace(ID))
+	for _, name := range slices.Sorted(maps.Keys(labels)) {
+		builder.WriteString(";" + keyEscaper.Replace(name) + "=" + keyEscaper.Replace(labels[name]))
+	}
+
+	return builder.String()
+}
+
+// IsMergeable объединяются ли значения метрик типа MType с сохраненными (гистограммы и скетчи)
+func IsMergeable(MType string) bool {
+	return slices.Contains(mergeableTypes, MType)
+}
+
+// Merge добавляет к сохраненной метрике новые значения update, сохраненная метрика не меняется
+func (m Metrics) Merge(update Metrics) (Metrics, error) {
+	if m.MType != update.MType {
+		return Metrics{}, fmt.Errorf("%w: %q can't be merged with %q", ErrTypeMismatch, m.MType, update.MType)
+	}
+
+	switch m.MType {
+	case Histogram:
+		if m.Histogram == nil || update.Histogram == nil {
+			return Metrics{}, ErrInvalidHistogram
+		}
+		merged, err := m.Histogram.Merge(update.Histogram)
+		if err != nil {
+			return Metrics{}, err
+		}
+		m.Histogram = merged
+		return m, nil
+	case Summary:
+		if m.Sketch == nil || update.Sketch == nil {
+			return Metrics{}, ErrNoSketch
+		}
+		merged := m.Sketch.Clone()
+		if err := merged.Merge(update.Sketch); err != nil {
+			return Metrics{}, err
+		}
+		m.Sketch = merged
+		return m, nil
+	case Set:
+		if m.Set == nil || update.Set == nil {
+			return Metrics{}, ErrNoSet
+		}
+		merged := m.Set.Clone()
+		if err := merged.Merge(update.Set); err != nil {
+			return Metrics{}, err
+		}
+		m.Set = merged
+		return m, nil
+	default:
+		return Metrics{}, fmt.Errorf("metrics of type %q can't be merged", m.MType)
+	}
+}
+
 func (m *Metrics) String() string {
+	if m.MType == Set {
+		return fmt.Sprintf(`{ID: %s, MType: %s, Labels: {%s}, Set: {precision=%d estimate=%d}}`,
+			m.ID, m.MType, m.LabelsString(), m.Set.Precision(), m.Set.Estimate())
+	}
+	if m.MType == Summary {
+		return fmt.Sprintf(`{ID: %s, MType: %s, Labels: {%s}, Sketch: {%s}}`,
+			m.ID, m.MType, m.LabelsString(), m.Sketch)
+	}
+	if m.MType == Histogram {
+		return fmt.Sprintf(`{ID: %s, MType: %s, Labels: {%s}, Histogram: {%s}}`,
+			m.ID, m.MType, m.LabelsString(), m.Histogram)
+	}
 	if m.MType == Gauge {
-		return fmt.Sprintf(`{ID: %s, MType: %s, Value: %.0f}`,
-			m.ID, m.MType, *m.Value)
+		return fmt.Sprintf(`{ID: %s, MType: %s, Labels: {%s}, Value: %.0f}`,
+			m.ID, m.MType, m.LabelsString(), *m.Value)
 	}
 
-	return fmt.Sprintf(`{ID: %s, MType: %s, Delta: %d}`,
-		m.ID, m.MType, *m.Delta)
+	return fmt.Sprintf(`{ID: %s, MType: %s, Labels: {%s}, Delta: %d}`,
+		m.ID, m.MType, m.LabelsString(), *m.Delta)
 }
@@ -0,0 +1,35 @@
+package models
+
+import (
+	"errors"
+
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
+)
+
+var ErrNoSet = errors.New("set has no sketch")
+
+// NewSetMetric set с одним значением Value в скетче точности по умолчанию
+func NewSetMetric(ID, Value string) (Metrics, error) {
+	if Value == "" {
+		return Metrics{}, errors.New("passed SET metric params are not valid")
+	}
+
+	s := sketch.NewDefaultHyperLogLog()
+	s.Add(Value)
+	return Metrics{
+		ID:    ID,
+		MType: Set,
+		Set:   s,
+	}, nil
+}
+
+// WithCardinality возвращает метрику с оценкой количества различных значений
+func (m Metrics) WithCardinality() (Metrics, error) {
+	if m.Set == nil {
+		return Metrics{}, ErrNoSet
+	}
+
+	cardinality := m.Set.Estimate()
+	m.Cardinality = &cardinality
+	return m, nil
+}
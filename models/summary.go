@@ -0,0 +1,59 @@
+package models
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/MKhiriev/stunning-adventure/internal/sketch"
+)
+
+var ErrNoSketch = errors.New("summary has no sketch")
+
+// Quantile значение квантиля Q метрики summary. В запросе задается только Q
+type Quantile struct {
+	Q     float64 `json:"q"`
+	Value float64 `json:"value"`
+}
+
+// NewSummaryMetric summary с одним значением Value в скетче точности по умолчанию
+func NewSummaryMetric(ID, Value string) (Metrics, error) {
+	value, err := strconv.ParseFloat(Value, 64)
+	if err != nil {
+		return Metrics{}, errors.New("passed SUMMARY metric params are not valid")
+	}
+
+	s := sketch.NewDefaultDDSketch()
+	if err = s.Add(value); err != nil {
+		return Metrics{}, err
+	}
+	return Metrics{
+		ID:     ID,
+		MType:  Summary,
+		Sketch: s,
+	}, nil
+}
+
+// WithQuantiles возвращает метрику с вычисленными квантилями qs, по умолчанию - sketch.DefaultQuantiles.
+// У пустого скетча квантилей нет
+func (m Metrics) WithQuantiles(qs ...float64) (Metrics, error) {
+	if m.Sketch == nil {
+		return Metrics{}, ErrNoSketch
+	}
+	if len(qs) == 0 {
+		qs = sketch.DefaultQuantiles
+	}
+
+	m.Quantiles = nil
+	for _, q := range qs {
+		value, err := m.Sketch.Quantile(q)
+		if errors.Is(err, sketch.ErrEmptySketch) {
+			return m, nil
+		}
+		if err != nil {
+			return Metrics{}, err
+		}
+		m.Quantiles = append(m.Quantiles, Quantile{Q: q, Value: value})
+	}
+
+	return m, nil
+}
@@ -1,6 +0,0 @@
-CREATE TABLE IF NOT EXISTS metrics (
-    id TEXT PRIMARY KEY,
-    type TEXT NOT NULL,
-    delta BIGINT,
-    value DOUBLE PRECISION
-);
\ No newline at end of file
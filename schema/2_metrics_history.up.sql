@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS metrics_history (
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    delta BIGINT,
+    value DOUBLE PRECISION,
+    ts TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS metrics_history_id_type_ts_idx ON metrics_history (id, type, ts);
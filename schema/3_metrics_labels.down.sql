@@ -0,0 +1,10 @@
+-- series with labels can not be kept under (id, type) primary key
+DELETE FROM metrics WHERE labels <> '{}';
+
+ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+ALTER TABLE metrics DROP COLUMN IF EXISTS labels;
+ALTER TABLE metrics ADD PRIMARY KEY (id, type);
+
+DROP INDEX IF EXISTS metrics_history_id_type_labels_ts_idx;
+ALTER TABLE metrics_history DROP COLUMN IF EXISTS labels;
+CREATE INDEX IF NOT EXISTS metrics_history_id_type_ts_idx ON metrics_history (id, type, ts);
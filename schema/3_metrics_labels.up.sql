@@ -0,0 +1,14 @@
+DO $$
+BEGIN
+    IF NOT EXISTS (SELECT 1 FROM information_schema.columns WHERE table_name = 'metrics' AND column_name = 'labels') THEN
+        ALTER TABLE metrics ADD COLUMN labels JSONB NOT NULL DEFAULT '{}';
+        ALTER TABLE metrics DROP CONSTRAINT IF EXISTS metrics_pkey;
+        ALTER TABLE metrics ADD PRIMARY KEY (id, type, labels);
+    END IF;
+END $$;
+
+ALTER TABLE metrics_history ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+-- history of a series is read by (id, type, labels) in time order
+DROP INDEX IF EXISTS metrics_history_id_type_ts_idx;
+CREATE INDEX IF NOT EXISTS metrics_history_id_type_labels_ts_idx ON metrics_history (id, type, labels, ts);
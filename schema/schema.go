@@ -0,0 +1,9 @@
+// Package schema содержит миграции схемы БД метрик
+package schema
+
+import "embed"
+
+// Migrations пронумерованные миграции: `<version>_<name>.up.sql` применяет миграцию, `<version>_<name>.down.sql` откатывает ее
+//
+//go:embed *.sql
+var Migrations embed.FS
@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS metrics (
+    id TEXT NOT NULL,
+    type TEXT NOT NULL,
+    labels TEXT NOT NULL DEFAULT '{}',
+    delta INTEGER,
+    value REAL,
+    PRIMARY KEY (id, type, labels)
+);
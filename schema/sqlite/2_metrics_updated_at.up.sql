@@ -0,0 +1,3 @@
+ALTER TABLE metrics ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+
+UPDATE metrics SET updated_at = CAST(strftime('%s', 'now') AS INTEGER);
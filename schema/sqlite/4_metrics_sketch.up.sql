@@ -0,0 +1 @@
+ALTER TABLE metrics ADD COLUMN sketch BLOB;
@@ -0,0 +1,9 @@
+// Package sqlite содержит миграции схемы метрик для SQLite
+package sqlite
+
+import "embed"
+
+// Migrations пронумерованные миграции в том же формате, что и миграции PostgreSQL в пакете schema
+//
+//go:embed *.sql
+var Migrations embed.FS